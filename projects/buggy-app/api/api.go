@@ -2,27 +2,35 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/certs"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-
-	httplogger "github.com/gleicon/go-httplogger"
 )
 
 // DbClient is for talking to the database
 type DbClient interface {
 	QueryRow(context.Context, string, ...interface{}) pgx.Row
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
+	Begin(context.Context) (pgx.Tx, error)
+	Ping(context.Context) error
 	Close()
 }
 
@@ -31,79 +39,330 @@ type Config struct {
 	Log            *log.Logger
 	AuthServiceUrl string
 	DatabaseUrl    string
+
+	// AuthTLS has the CA of the auth service's certificate and, for mutual TLS, the API's own
+	// certificate and key. Without a CA, the auth service is called without TLS. AuthServerName is
+	// the name the auth service's certificate must have, if not the host in AuthServiceUrl.
+	AuthTLS        certs.Files
+	AuthServerName string
+	// AuthServiceKey lets the API manage users' API keys in the auth service: see auth.WithServiceKey
+	AuthServiceKey []byte
+
+	// Notes are permanently deleted once they have been in the trash for TrashRetention.
+	// The trash is checked every TrashPurgeInterval. Zero for either disables purging.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// Requests are rate limited per user with RateLimit. Requests that fail authentication are
+	// limited per client IP with AnonymousRateLimit. See ratelimit.go.
+	RateLimit          RateLimit
+	AnonymousRateLimit RateLimit
 }
 
 type Service struct {
 	config     Config
 	authClient auth.Client
 	pool       DbClient
+
+	userLimiter      *rateLimiter
+	anonymousLimiter *rateLimiter
+	metrics          *metrics
 }
 
 func New(config Config) *Service {
 	return &Service{
-		config: config,
+		config:           config,
+		userLimiter:      newRateLimiter(config.RateLimit),
+		anonymousLimiter: newRateLimiter(config.AnonymousRateLimit),
+		metrics:          newMetrics(),
 	}
 }
 
-// HTTP handler for getting notes for a particular user
+// Request bodies larger than this are rejected before they are decoded
+const maxRequestBodySize = 2 * model.MaxContentLength
+
+// HTTP handler for the notes collection: GET lists the user's notes, POST creates a new one
 func (as *Service) handleMyNotes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		as.handleGetMyNotes(w, r)
+	case http.MethodPost:
+		as.handleCreateMyNote(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	}
+}
+
+// HTTP handler for getting notes for a particular user
+func (as *Service) handleGetMyNotes(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	// Get the authenticated user from the context -- this will have been written earlier
 	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
 	if !ok {
 		as.config.Log.Printf("api: route handler reached with invalid auth context")
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	encoding, ok := negotiate(w, r, listEncodings)
+	if !ok {
+		return
+	}
+
+	// Notes come back a page at a time: ?limit=50&cursor=<next_cursor from the previous page>,
+	// optionally filtered by tag
+	opts, err := listOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		fmt.Printf("api: bad list options: %v\n", err)
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Clients poll for changes, so first check whether their copy is still current. That only takes
+	// a count and a timestamp, rather than all the notes.
+	version, err := model.GetNotesVersionForOwner(ctx, as.pool, owner, opts)
+	if err != nil {
+		fmt.Printf("api: GetNotesVersionForOwner failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+	if notModified(w, r, encodingETag(notesETag(version), encoding), version.Modified) {
+		return
 	}
 
 	// Use the "model" layer to get a list of the owner's notes
-	notes, err := model.GetNotesForOwner(ctx, as.pool, owner)
+	notes, nextCursor, err := model.GetNotesForOwner(ctx, as.pool, owner, opts)
 	if err != nil {
 		fmt.Printf("api: GetNotesForOwner failed: %v\n", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	response := notesResponse{
+		Notes:      notes,
+		NextCursor: nextCursor,
+	}
+
+	// Convert the []Row into the encoding the client asked for
+	res, err := encoding.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Every encoding gets a link to the next page, as only JSON has a next_cursor field
+	if nextCursor != "" {
+		next := *r.URL
+		query := next.Query()
+		query.Set("cursor", nextCursor)
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+	}
+
+	// Write it back out!
+	w.Header().Set("Content-Type", encoding.ContentType)
+	w.Write(res)
+}
+
+// Read the paging and filtering parameters for a list of notes from the URL query:
+//
+//	?limit=50&cursor=abc&tag=work&tag=urgent&match=all
+func listOptionsFromQuery(query url.Values) (model.ListOptions, error) {
+	opts := model.ListOptions{
+		Cursor: query.Get("cursor"),
+		Tags:   query["tag"],
+	}
+	switch match := query.Get("match"); match {
+	case "", "any":
+	case "all":
+		opts.MatchAllTags = true
+	default:
+		return opts, fmt.Errorf("invalid match %q, expected any or all", match)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("invalid limit %q", limit)
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// HTTP handler for creating a note owned by the authenticated user. The body is JSON:
+//
+//	{"content": "Note content #tag"}
+func (as *Service) handleCreateMyNote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	// Unknown fields are rejected so that typos in the request don't go unnoticed
+	var input struct {
+		Content string `json:"content"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		fmt.Printf("api: could not decode note: %v\n", err)
+		writeProblem(w, r, http.StatusBadRequest, "body must be a JSON object with content")
+		return
+	}
+
+	note, err := model.CreateNote(ctx, as.pool, owner, input.Content)
+	if err != nil {
+		fmt.Printf("api: CreateNote failed: %v\n", err)
+		writeError(w, r, err)
+		return
 	}
 
 	response := struct {
-		Notes model.Notes `json:"notes"`
+		Note model.Note `json:"note"`
 	}{
-		Notes: notes,
+		Note: note,
 	}
 
-	// Convert the []Row into JSON
-	res, err := util.MarshalWithIndent(response, "")
+	res, err := util.JSON.Encode(response)
 	if err != nil {
 		fmt.Printf("api: response marshal failed: %v\n", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
 	}
 
-	// Write it back out!
-	w.Header().Add("Content-Type", "text/json")
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Header().Add("Location", fmt.Sprintf("/1/my/note/%s.json", note.Id))
+	w.WriteHeader(http.StatusCreated)
 	w.Write(res)
 }
 
-// HTTP handler for getting notes for a particular user
+// HTTP handler for a single note: GET reads it, PUT and PATCH update it, DELETE moves it to the trash
 func (as *Service) handleMyNoteById(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		as.handleGetMyNoteById(w, r)
+	case http.MethodPut, http.MethodPatch:
+		as.handleUpdateMyNote(w, r)
+	case http.MethodDelete:
+		as.handleTrashMyNote(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, PATCH, DELETE")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	}
+}
+
+// The URL.Path will be something like /1/my/notes/abc123.json.
+// path.Base strips everything but "abc123.json". We then trim off the extension (which might
+// be .md, or missing altogether) to give us just the ID.
+func noteIdFromPath(urlPath string) string {
+	return strings.TrimSuffix(path.Base(urlPath), path.Ext(urlPath))
+}
+
+// HTTP handler for getting a note the user owns, or that has been shared with them
+func (as *Service) handleGetMyNoteById(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	// Get the authenticated user from the context -- this will have been written earlier
-	_, ok := authuserctx.FromAuthenticatedContext(ctx)
+	user, ok := authuserctx.FromAuthenticatedContext(ctx)
 	if !ok {
 		as.config.Log.Printf("api: route handler reached with invalid auth context")
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
 	}
 
-	// The URL.Path will be something like /1/my/notes/abc123.json.
-	// path.Base strips everything but "abc123.json". We then Replace out the ".json" to give us
-	// just the ID.
-	id := strings.Replace(path.Base(r.URL.Path), ".json", "", 1)
+	id := noteIdFromPath(r.URL.Path)
 	if id == "" {
 		fmt.Printf("api: no ID supplied: url path %v\n", r.URL.Path)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "")
+		return
 	}
 
-	// Use the "model" layer to get a list of the owner's notes
-	note, err := model.GetNoteById(ctx, as.pool, id)
+	encoding, ok := negotiate(w, r, noteEncodings)
+	if !ok {
+		return
+	}
+
+	// Use the "model" layer to get the note, if the user is allowed to see it
+	note, err := model.GetNoteById(ctx, as.pool, user, id)
 	if err != nil {
 		fmt.Printf("api: GetNoteById failed: %v\n", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
+	}
+
+	// The client may already have this version of the note
+	if notModified(w, r, encodingETag(noteETag(note), encoding), note.Modified) {
+		return
+	}
+
+	response := noteResponse{
+		Note: note,
+	}
+
+	// Convert the note into the encoding the client asked for
+	res, err := encoding.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Write it back out!
+	w.Header().Set("Content-Type", encoding.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for updating the content of a note the user owns, or has been given write access to. PUT and PATCH take the same JSON body:
+//
+//	{"content": "New content"}
+//
+// Content is the only editable field, so a PATCH without it has nothing to do and is rejected.
+//
+// Clients should send the ETag they got when reading the note in an If-Match header. If the note has
+// been changed by someone else in the meantime the update is refused with 412 Precondition Failed, and
+// the client can re-read the note and try again.
+func (as *Service) handleUpdateMyNote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	id := noteIdFromPath(r.URL.Path)
+	if id == "" {
+		fmt.Printf("api: no ID supplied: url path %v\n", r.URL.Path)
+		writeProblem(w, r, http.StatusBadRequest, "")
+		return
+	}
+
+	var input struct {
+		Content *string `json:"content"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil || input.Content == nil {
+		fmt.Printf("api: could not decode note update: %v\n", err)
+		writeProblem(w, r, http.StatusBadRequest, "body must be a JSON object with content")
+		return
+	}
+
+	ifModified, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		writeProblem(w, r, http.StatusPreconditionFailed, "If-Match has no ETag for this note")
+		return
+	}
+
+	note, err := model.UpdateNote(ctx, as.pool, user, id, *input.Content, ifModified...)
+	if err != nil {
+		fmt.Printf("api: UpdateNote failed: %v\n", err)
+		writeError(w, r, err)
+		return
 	}
 
 	response := struct {
@@ -112,15 +371,15 @@ func (as *Service) handleMyNoteById(w http.ResponseWriter, r *http.Request) {
 		Note: note,
 	}
 
-	// Convert the []Row into JSON
-	res, err := util.MarshalWithIndent(response, "")
+	res, err := util.JSON.Encode(response)
 	if err != nil {
 		fmt.Printf("api: response marshal failed: %v\n", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
 	}
 
-	// Write it back out!
-	w.Header().Add("Content-Type", "text/json")
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Header().Set("ETag", noteETag(note))
 	w.Write(res)
 }
 
@@ -128,16 +387,51 @@ func (as *Service) handleMyNoteById(w http.ResponseWriter, r *http.Request) {
 // rather than running the whole server.
 func (as *Service) Handler() http.Handler {
 	mux := new(http.ServeMux)
-	mux.HandleFunc("/1/my/note/", as.wrapAuth(as.authClient, as.handleMyNoteById))
-	mux.HandleFunc("/1/my/notes.json", as.wrapAuth(as.authClient, as.handleMyNotes))
-	return httplogger.HTTPLogger(mux)
+	// Every route is traced and counted in the metrics, labelled with its pattern
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, as.wrapTracing(pattern, as.wrapMetrics(pattern, handler)))
+	}
+	handle("/1/my/note/", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleMyNote)))
+	handle("/1/my/tags.json", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleMyTags)))
+	handle("/1/my/trash/", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleRestoreMyNote)))
+	handle("/1/my/export", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleExport)))
+	handle("/1/my/export.zip", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleExport)))
+	handle("/1/my/import", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleImport)))
+	handle("/1/my/session", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleMySession)))
+	handle("/1/my/keys", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleMyKeys)))
+	handle("/1/my/keys/", as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleMyKey)))
+	// Logging in and refreshing are how clients get a token to authenticate with
+	handle("/1/sessions", as.handleSessions)
+	handle("/1/sessions/refresh", as.handleRefreshSession)
+	// Lists can be fetched with any of their extensions, or none to use the Accept header
+	for _, ext := range append([]string{""}, listExtensions()...) {
+		handle("/1/my/notes"+ext, as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleMyNotes)))
+		handle("/1/my/notes/search"+ext, as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleSearchMyNotes)))
+		handle("/1/my/trash"+ext, as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleMyTrash)))
+		handle("/1/shared/notes"+ext, as.wrapAuth(as.authClient, as.wrapRateLimit(as.handleSharedNotes)))
+	}
+	// Scrapes and health checks come every few seconds and aren't worth tracing
+	mux.HandleFunc("/metrics", as.wrapMetrics("/metrics", as.handleMetrics))
+	mux.HandleFunc("/healthz", as.wrapMetrics("/healthz", as.handleHealthz))
+	mux.HandleFunc("/readyz", as.wrapMetrics("/readyz", as.handleReadyz))
+	// Anything else is a problem too
+	handle("/", func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, "")
+	})
+	return as.wrapRequestId(as.wrapAccessLog(mux.ServeHTTP))
 }
 
 func (as *Service) Run(ctx context.Context) error {
 	listen := fmt.Sprintf(":%d", as.config.Port)
 
 	// Connect to the database via a "pool" of connections, allowing concurrency
-	pool, err := pgxpool.New(ctx, as.config.DatabaseUrl)
+	poolConfig, err := pgxpool.ParseConfig(as.config.DatabaseUrl)
+	if err != nil {
+		return fmt.Errorf("unable to parse database url: %w", err)
+	}
+	// Every query gets a span in the trace of the request it's for
+	poolConfig.ConnConfig.Tracer = tracing.QueryTracer{}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return fmt.Errorf("unable to create connection pool: %w", err)
 	}
@@ -146,12 +440,17 @@ func (as *Service) Run(ctx context.Context) error {
 	as.pool = pool
 
 	// Connect to the Auth service via the AuthClient
-	client, err := auth.NewClient(ctx, as.config.AuthServiceUrl)
+	client, err := as.newAuthClient(ctx)
 	if err != nil {
 		return err
 	}
 	as.authClient = client
 
+	as.metrics.registry.MustRegister(
+		util.NewPoolCollector("api", func() util.PoolStat { return pool.Stat() }),
+		client,
+	)
+
 	// mux is the root Handler
 	mux := as.Handler()
 	server := &http.Server{Addr: listen, Handler: mux}
@@ -164,6 +463,26 @@ func (as *Service) Run(ctx context.Context) error {
 		runErr = server.ListenAndServe()
 	}()
 
+	// Bring the stored tags up to date with the way tags are extracted, if it has changed since they
+	// were stored. Until it's done, finding notes by tag may not match their tags.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		n, err := model.UpdateTags(ctx, as.pool)
+		if err != nil {
+			as.config.Log.Printf("api: update tags failed: %v", err)
+		} else if n > 0 {
+			as.config.Log.Printf("api: updated the tags of %d notes", n)
+		}
+	}()
+
+	// Permanently delete old notes from the trash in the background
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		as.purgeTrash(ctx)
+	}()
+
 	as.config.Log.Printf("api service: listening: %s", listen)
 
 	// Wait for a signal to shut down...
@@ -174,3 +493,15 @@ func (as *Service) Run(ctx context.Context) error {
 	wg.Wait()
 	return runErr
 }
+
+// The auth client uses TLS if there's a CA to check the auth service's certificate with
+func (as *Service) newAuthClient(ctx context.Context) (*auth.GrpcClient, error) {
+	if as.config.AuthTLS.CA == "" {
+		return auth.NewClient(ctx, as.config.AuthServiceUrl)
+	}
+	store, err := certs.NewStore(as.config.AuthTLS)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificates: %w", err)
+	}
+	return auth.NewTLSClient(ctx, as.config.AuthServiceUrl, store.ClientConfig(as.config.AuthServerName))
+}
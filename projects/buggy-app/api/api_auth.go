@@ -2,8 +2,10 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
@@ -12,38 +14,108 @@ import (
 // wrapAuth takes a handler function (likely to be the API endpoint) and wraps it with an authentication
 // check using an AuthClient.
 //
+// Requests can authenticate with basic auth, which is checked with Verify, or with the access token of
+// a session as Authorization: Bearer, which is checked with Introspect (see api_session.go).
+//
+// The bearer token can be an API key instead, which is checked with Introspect too. An API key's scopes
+// limit what it can do: requests that only read need ScopeNotesRead, and everything else needs
+// ScopeNotesWrite. Passwords and sessions have every scope.
+//
+// A request that fails gets the same 401 whatever the auth service's reason for denying it.
+//
 // If the authentication passes, it adds the authenticated user ID to the context using the authuserctx
 // package, and then calls the inner handler. The ID can be retrieved later using the
-// `FromAuthenticatedContext` function.
+// `FromAuthenticatedContext` function. The scopes, and the API key if there is one, are added too, for
+// handlers to check with `HasScope` and `FromApiKeyContext`.
 func (as *Service) wrapAuth(client auth.Client, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
-		id, passwd, ok := r.BasicAuth()
-		// Malformed basic auth is not OK
-		if !ok {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		// A client that has failed too often recently is turned away before it reaches the auth service
+		ip := clientIP(r)
+		if decision := as.anonymousLimiter.peek(ip); !decision.allowed {
+			log.Printf("api: rate limited: ip %v\n", ip)
+			writeRateLimit(w, r, decision)
 			return
 		}
 
-		// Use the auth client to check if this id/password combo is approved
-		result, err := client.Verify(ctx, id, passwd)
+		var id string
+		var result *auth.VerifyResult
+		var err error
+		if token, ok := bearerToken(r); ok {
+			// Use the auth client to check the token, and find out whose it is
+			result, err = client.Introspect(ctx, token)
+			if err == nil {
+				id = result.Id
+			}
+		} else {
+			var passwd string
+			id, passwd, ok = r.BasicAuth()
+			// Malformed basic auth is not OK
+			if !ok {
+				as.unauthorized(w, r, ip)
+				return
+			}
+			// Use the auth client to check if this id/password combo is approved. The auth service
+			// counts failures from the client's IP, as well as for the id.
+			result, err = client.Verify(auth.WithSource(ctx, ip), id, passwd)
+		}
 		if err != nil {
 			log.Printf("api: verify error: %v\n", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeProblem(w, r, http.StatusInternalServerError, "")
 			return
 		}
 
-		// Unless we get an Allow, say no
+		// Unless we get an Allow, say no. The reason is only logged: telling the client whether the
+		// user exists or the password was wrong would help anyone guessing.
 		if result.State != auth.StateAllow {
-			log.Printf("api: verify denied: id %v\n", id)
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			log.Printf("api: verify denied: id %v, reason %v\n", id, result.Reason)
+			as.unauthorized(w, r, ip)
 			return
 		}
 
 		// Add the ID to the context and call the inner handler
 		ctx = authuserctx.NewAuthenticatedContext(ctx, id)
+		ctx = authuserctx.NewScopedContext(ctx, result.Scopes)
+		if result.KeyId != "" {
+			ctx = authuserctx.NewApiKeyContext(ctx, result.KeyId)
+		}
+		if scope := requiredScope(r); !authuserctx.HasScope(ctx, scope) {
+			log.Printf("api: missing scope: id %v, key %v, scope %v\n", id, result.KeyId, scope)
+			writeProblem(w, r, http.StatusForbidden, fmt.Sprintf("this API key doesn't have the %s scope", scope))
+			return
+		}
 		handler(w, r.WithContext(ctx))
 	}
 }
+
+// Reject a request that failed authentication. Each failure takes a token from the client IP's
+// bucket: once it's empty, the IP is turned away by wrapAuth until it refills.
+func (as *Service) unauthorized(w http.ResponseWriter, r *http.Request, ip string) {
+	decision := as.anonymousLimiter.take(ip)
+	writeRateLimit(w, r, decision)
+	if decision.allowed {
+		writeProblem(w, r, http.StatusUnauthorized, "")
+	}
+}
+
+// The scope a request needs: reading for the methods that only read, writing for anything else
+func requiredScope(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return auth.ScopeNotesRead
+	default:
+		return auth.ScopeNotesWrite
+	}
+}
+
+// The token from an Authorization: Bearer header, if there is one
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// A user's notes can be exported as a zip archive, and imported again, for example into another
+// environment:
+//
+//	GET  /1/my/export
+//	POST /1/my/import
+//
+// The archive has one Markdown file per note, with the note's metadata in front-matter:
+//
+//	---
+//	id: xyz789
+//	created: 2022-10-16T09:45:03.597524Z
+//	modified: 2022-10-16T09:45:03.597524Z
+//	tags: [food, home]
+//	---
+//	Shopping #food #home
+//
+// and a manifest.json listing the notes. Import also takes NDJSON with one note per line, as
+// written by /1/my/notes.ndjson. Imported notes get new IDs; the report of what happened to each
+// one includes the ID it had before.
+
+const exportVersion = 1
+
+// A zip can't be read until all of it has arrived, so imports are held in memory: this is as big
+// as they can get.
+const maxImportSize = 64 << 20
+
+// A small zip can hold a lot, so what's in it is limited too: how many files it has, how big they
+// are once inflated, all together, and how many notes there are. NDJSON has the same limit on notes.
+const (
+	maxImportEntries  = 2 * maxImportNotes
+	maxImportInflated = 256 << 20
+	maxImportNotes    = 10000
+)
+
+// errImportTooLarge is returned (wrapped, with which limit) when an import is over one of the limits
+var errImportTooLarge = errors.New("import is too large")
+
+type exportManifest struct {
+	Version  int           `json:"version"`
+	Owner    string        `json:"owner"`
+	Exported time.Time     `json:"exported"`
+	Notes    []exportEntry `json:"notes"`
+}
+
+type exportEntry struct {
+	Id       string    `json:"id"`
+	File     string    `json:"file"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	Tags     []string  `json:"tags"`
+}
+
+// Write a note as Markdown with front-matter
+func encodeFrontMatter(note model.Note) []byte {
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %s\n", note.Id)
+	fmt.Fprintf(&b, "created: %s\n", note.Created.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "modified: %s\n", note.Modified.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(note.Tags, ", "))
+	b.WriteString("---\n")
+	b.WriteString(note.Content)
+	return b.Bytes()
+}
+
+// Read a note written by encodeFrontMatter. A file without front-matter is all content. Tags are
+// ignored, as they come from the content, and so is modified, as importing modifies the note.
+func decodeFrontMatter(file []byte) (model.ImportNote, error) {
+	var note model.ImportNote
+	text := string(file)
+
+	rest, ok := cutPrefix(text, "---\n")
+	if !ok {
+		note.Content = text
+		return note, nil
+	}
+	header, content, ok := strings.Cut(rest, "\n---\n")
+	if !ok {
+		return note, errors.New("front-matter is not closed with ---")
+	}
+	note.Content = content
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch strings.TrimSpace(key) {
+		case "id":
+			note.SourceId = value
+		case "created":
+			note.Created, err = time.Parse(time.RFC3339Nano, value)
+		}
+		if err != nil {
+			return note, fmt.Errorf("bad %s in front-matter: %w", strings.TrimSpace(key), err)
+		}
+	}
+	return note, nil
+}
+
+// strings.CutPrefix, which arrived in Go 1.20
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// HTTP handler for downloading all the authenticated user's notes as a zip archive. The archive is
+// written as the notes are read, a page at a time, so it never has to be held in memory.
+func (as *Service) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	// Until the first page is read, we can still send an error response
+	opts := model.ListOptions{Limit: model.MaxPageSize}
+	notes, nextCursor, err := model.GetNotesForOwner(ctx, as.pool, owner, opts)
+	if err != nil {
+		fmt.Printf("api: GetNotesForOwner failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	exported := time.Now().UTC()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("notes-%s-%s.zip", owner, exported.Format("20060102")),
+	}))
+
+	archive := zip.NewWriter(w)
+	manifest := exportManifest{Version: exportVersion, Owner: owner, Exported: exported, Notes: []exportEntry{}}
+	for {
+		for _, note := range notes {
+			entry := exportEntry{
+				Id:       note.Id,
+				File:     fmt.Sprintf("notes/%s.md", note.Id),
+				Created:  note.Created,
+				Modified: note.Modified,
+				Tags:     note.Tags,
+			}
+			file, err := archive.CreateHeader(&zip.FileHeader{Name: entry.File, Method: zip.Deflate, Modified: note.Modified})
+			if err == nil {
+				_, err = file.Write(encodeFrontMatter(note))
+			}
+			if err != nil {
+				// The response has started, so all we can do is stop: the client gets a truncated archive
+				fmt.Printf("api: export write failed: %v\n", err)
+				return
+			}
+			manifest.Notes = append(manifest.Notes, entry)
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		opts.Cursor = nextCursor
+		notes, nextCursor, err = model.GetNotesForOwner(ctx, as.pool, owner, opts)
+		if err != nil {
+			fmt.Printf("api: GetNotesForOwner failed during export: %v\n", err)
+			return
+		}
+	}
+
+	res, err := util.MarshalWithIndent(manifest, "2")
+	if err != nil {
+		fmt.Printf("api: manifest marshal failed: %v\n", err)
+		return
+	}
+	file, err := archive.Create("manifest.json")
+	if err == nil {
+		_, err = file.Write(res)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		fmt.Printf("api: export write failed: %v\n", err)
+	}
+}
+
+// Read the notes from an export archive, in the order they appear in it
+func readImportZip(body []byte) ([]model.ImportNote, error) {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(archive.File) > maxImportEntries {
+		return nil, fmt.Errorf("%w: more than %d files", errImportTooLarge, maxImportEntries)
+	}
+
+	notes := []model.ImportNote{}
+	inflated := 0
+	for _, f := range archive.File {
+		// Only the notes matter: the manifest (and anything else) is skipped
+		if f.FileInfo().IsDir() || !strings.HasSuffix(f.Name, ".md") {
+			continue
+		}
+		if len(notes) == maxImportNotes {
+			return nil, fmt.Errorf("%w: more than %d notes", errImportTooLarge, maxImportNotes)
+		}
+		var note model.ImportNote
+		content, err := readZipFile(f, maxImportInflated-inflated)
+		if errors.Is(err, errImportTooLarge) {
+			return nil, err
+		}
+		inflated += len(content)
+		if err == nil {
+			note, err = decodeFrontMatter(content)
+		}
+		note.Source = f.Name
+		note.Err = err
+		notes = append(notes, note)
+	}
+	return notes, nil
+}
+
+// Read a file from an import zip, which must fit in what's left of maxImportInflated
+func readZipFile(f *zip.File, remaining int) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// Stop early if the file is bigger than any note (and its front-matter) can be, or than the
+	// import has room for, rather than inflating all of it
+	const limit = model.MaxContentLength + 4096
+	readLimit := limit
+	if remaining < readLimit {
+		readLimit = remaining
+	}
+	content, err := io.ReadAll(io.LimitReader(rc, int64(readLimit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > readLimit {
+		if readLimit == remaining {
+			return nil, fmt.Errorf("%w: more than %d bytes once inflated", errImportTooLarge, maxImportInflated)
+		}
+		return nil, errors.New("file is too large")
+	}
+	return content, nil
+}
+
+// Read notes from NDJSON, one JSON object per line. Fields other than those in a note are ignored.
+func readImportNDJSON(body []byte) ([]model.ImportNote, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, maxRequestBodySize)
+
+	notes := []model.ImportNote{}
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		if len(notes) == maxImportNotes {
+			return nil, fmt.Errorf("%w: more than %d notes", errImportTooLarge, maxImportNotes)
+		}
+		var input struct {
+			Id      string    `json:"id"`
+			Content string    `json:"content"`
+			Created time.Time `json:"created"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &input)
+		notes = append(notes, model.ImportNote{
+			Source:   fmt.Sprintf("line %d", line),
+			SourceId: input.Id,
+			Content:  input.Content,
+			Created:  input.Created,
+			Err:      err,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// HTTP handler for importing notes for the authenticated user, from an export archive
+// (Content-Type: application/zip) or NDJSON (Content-Type: application/x-ndjson).
+//
+// Imported notes are created and modified at the time of the import, unless ?created=keep asks for
+// them to keep the created times they were exported with.
+//
+// The response reports what happened to each note. The import is all or nothing: if any note
+// can't be imported, none are, and the response is a 400 Bad Request problem with the report in it.
+func (as *Service) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	var opts model.ImportOptions
+	switch created := r.URL.Query().Get("created"); created {
+	case "", "now":
+	case "keep":
+		opts.KeepCreated = true
+	default:
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("invalid created %q, expected now or keep", created))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		fmt.Printf("api: could not read import: %v\n", err)
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("imports must be at most %d bytes", maxImportSize))
+		return
+	}
+
+	var notes []model.ImportNote
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/zip":
+		notes, err = readImportZip(body)
+	case util.NDJSON.MediaType:
+		notes, err = readImportNDJSON(body)
+	default:
+		fmt.Printf("api: can't import %q\n", mediaType)
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "imports must be application/zip or application/x-ndjson")
+		return
+	}
+	if errors.Is(err, errImportTooLarge) {
+		fmt.Printf("api: could not read import: %v\n", err)
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("api: could not read import: %v\n", err)
+		writeProblem(w, r, http.StatusBadRequest, "import could not be read")
+		return
+	}
+
+	results, err := model.ImportNotes(ctx, as.pool, owner, notes, opts)
+	if err != nil && !errors.Is(err, model.ErrInvalidImport) {
+		fmt.Printf("api: ImportNotes failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	if err != nil {
+		// The report says which notes couldn't be imported, so it goes in the problem
+		fmt.Printf("api: ImportNotes failed: %v\n", err)
+		writeProblemBody(w, http.StatusBadRequest, struct {
+			problem
+			Imported int                  `json:"imported"`
+			Results  []model.ImportResult `json:"results"`
+		}{
+			problem:  newProblem(r, http.StatusBadRequest, "some notes could not be imported, so none were"),
+			Imported: 0,
+			Results:  results,
+		})
+		return
+	}
+
+	response := struct {
+		Imported int                  `json:"imported"`
+		Results  []model.ImportResult `json:"results"`
+	}{
+		Imported: len(results),
+		Results:  results,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Write(res)
+}
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestFrontMatterRoundTrip(t *testing.T) {
+	created := time.Date(2022, 10, 15, 19, 48, 19, 597524000, time.UTC)
+	note := model.Note{
+		Id:       "xyz789",
+		Content:  "Shopping #food\n---\nnot front-matter",
+		Created:  created,
+		Modified: created.Add(time.Hour),
+		Tags:     []string{"food"},
+	}
+
+	expected := "---\nid: xyz789\ncreated: 2022-10-15T19:48:19.597524Z\nmodified: 2022-10-15T20:48:19.597524Z\ntags: [food]\n---\n" + note.Content
+	encoded := encodeFrontMatter(note)
+	if string(encoded) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, encoded)
+	}
+
+	decoded, err := decodeFrontMatter(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Modified is dropped, as importing modifies the note
+	imported := model.ImportNote{SourceId: note.Id, Content: note.Content, Created: note.Created}
+	if !reflect.DeepEqual(decoded, imported) {
+		t.Fatalf("expected %+v, got %+v", imported, decoded)
+	}
+}
+
+func TestExport(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	created := time.Date(2022, 10, 15, 19, 48, 19, 597524000, time.UTC)
+
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified"}).
+		AddRow("xyz789", id, "Shopping #food", created, created).
+		AddRow("xyz790", id, "Ideas", created, created)
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE owner = (.+) ORDER BY created, id LIMIT (.+)$").
+		WithArgs(id, model.MaxPageSize+1).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/1/my/export", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/zip" {
+		t.Fatalf("expected zip, got %s", contentType)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	if err != nil {
+		t.Fatalf("could not read archive: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(b)
+	}
+
+	expected := "---\nid: xyz789\ncreated: 2022-10-15T19:48:19.597524Z\nmodified: 2022-10-15T19:48:19.597524Z\ntags: [food]\n---\nShopping #food"
+	if files["notes/xyz789.md"] != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, files["notes/xyz789.md"])
+	}
+
+	var manifest exportManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	if manifest.Owner != id || len(manifest.Notes) != 2 || manifest.Notes[1].File != "notes/xyz790.md" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(files))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestImportZip(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	created := time.Date(2022, 10, 15, 19, 48, 19, 597524000, time.UTC)
+	note := model.Note{Id: "old123", Content: "Shopping #food", Created: created, Modified: created, Tags: []string{"food"}}
+
+	var body bytes.Buffer
+	archive := zip.NewWriter(&body)
+	for name, content := range map[string][]byte{
+		"notes/old123.md": encodeFrontMatter(note),
+		"manifest.json":   []byte(`{"version": 1}`),
+	} {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(content)
+	}
+	archive.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^INSERT INTO public.note (.+) RETURNING id$").
+		WithArgs(id, note.Content, &created).
+		WillReturnRows(mock.NewRows([]string{"id"}).AddRow("new456"))
+	mock.ExpectExec("^INSERT INTO public.note_tag (.+)$").
+		WithArgs("new456", []string{"food"}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	req, err := http.NewRequest("POST", "/1/my/import?created=keep", &body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	req.Header.Add("Content-Type", "application/zip")
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Imported int                  `json:"imported"`
+		Results  []model.ImportResult `json:"results"`
+	}{
+		Imported: 1,
+		Results:  []model.ImportResult{{Source: "notes/old123.md", SourceId: "old123", Id: "new456"}},
+	}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestImportZipTooLarge(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	// Plenty of tiny notes make a small zip, but too many to import
+	var body bytes.Buffer
+	archive := zip.NewWriter(&body)
+	for i := 0; i <= maxImportNotes; i++ {
+		f, err := archive.Create(fmt.Sprintf("notes/%d.md", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("Note"))
+	}
+	archive.Close()
+	if body.Len() > maxImportSize {
+		t.Fatalf("expected the zip to be under %d bytes, got %d", maxImportSize, body.Len())
+	}
+
+	req, err := http.NewRequest("POST", "/1/my/import", &body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+	req.Header.Add("Content-Type", "application/zip")
+	res := httptest.NewRecorder()
+	as.Handler().ServeHTTP(res, req)
+
+	// Nothing is written
+	if res.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Fatalf("expected a problem, got %s", contentType)
+	}
+	if !strings.Contains(res.Body.String(), fmt.Sprintf("more than %d notes", maxImportNotes)) {
+		t.Fatalf("expected the limit in the problem, got %s", res.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+// sinceThen matches an argument that's a time between then and now, like the time of an import
+type sinceThen time.Time
+
+func (then sinceThen) Match(v interface{}) bool {
+	t, ok := v.(*time.Time)
+	return ok && t != nil && !t.Before(time.Time(then)) && !t.After(time.Now())
+}
+
+func TestImportNDJSONTimestamps(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	created := time.Date(2022, 10, 15, 19, 48, 19, 0, time.UTC)
+	// A modified time in the future would keep a note at the top of listings, so it's never kept
+	body := `{"id": "old1", "content": "First", "created": "2022-10-15T19:48:19Z", "modified": "2999-01-01T00:00:00Z"}
+{"id": "old2", "content": "Second", "created": "2999-01-01T00:00:00Z"}
+`
+	insert := "^INSERT INTO public.note \\(owner, content, created\\)\\s+VALUES (.+) RETURNING id$"
+
+	tests := map[string]struct {
+		query   string
+		created []interface{}
+	}{
+		// By default, the notes are as new
+		"now": {"", []interface{}{(*time.Time)(nil), (*time.Time)(nil)}},
+		// If asked, created is kept, but not in the future
+		"keep": {"?created=keep", []interface{}{&created, sinceThen(time.Now())}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock.ExpectBegin()
+			for i, content := range []string{"First", "Second"} {
+				mock.ExpectQuery(insert).
+					WithArgs(id, content, test.created[i]).
+					WillReturnRows(mock.NewRows([]string{"id"}).AddRow(fmt.Sprintf("new%d", i)))
+			}
+			mock.ExpectCommit()
+
+			req, err := http.NewRequest("POST", "/1/my/import"+test.query, strings.NewReader(body))
+			if err != nil {
+				log.Fatal(err)
+			}
+			req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+			req.Header.Add("Content-Type", "application/x-ndjson")
+			res := httptest.NewRecorder()
+			as.Handler().ServeHTTP(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, res.Code, res.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unfulfilled expectations: %s", err)
+			}
+		})
+	}
+
+	req, err := http.NewRequest("POST", "/1/my/import?created=later", strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	req.Header.Add("Content-Type", "application/x-ndjson")
+	res := httptest.NewRecorder()
+	as.Handler().ServeHTTP(res, req)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestImportNDJSONInvalid(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	// The second note is empty and the third isn't JSON, so nothing is imported
+	body := `{"id": "old1", "content": "First"}
+{"id": "old2", "content": ""}
+
+not json
+`
+
+	req, err := http.NewRequest("POST", "/1/my/import", strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	req.Header.Add("Content-Type", "application/x-ndjson")
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.Code)
+	}
+
+	var data struct {
+		Imported int                  `json:"imported"`
+		Results  []model.ImportResult `json:"results"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Imported != 0 || len(data.Results) != 3 {
+		t.Fatalf("unexpected report: %+v", data)
+	}
+	if data.Results[0].Error != "" || data.Results[1].Error == "" || data.Results[2].Source != "line 4" || data.Results[2].Error == "" {
+		t.Fatalf("unexpected report: %+v", data)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
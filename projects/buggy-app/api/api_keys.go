@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// API keys are for scripts and CI jobs, which send one as Authorization: Bearer in place of a
+// password. They're managed with:
+//
+//	GET    /1/my/keys
+//	POST   /1/my/keys
+//	DELETE /1/my/keys/:id
+//
+// The auth service keeps track of keys, and checks them (see wrapAuth). Keys can't be used to manage
+// keys, or a key that can only read notes could make itself one that can write them.
+
+// An API key, without the key itself. Expires and last_used are null for never.
+type apiKeyResponse struct {
+	Id       string     `json:"id"`
+	Name     string     `json:"name"`
+	Scopes   []string   `json:"scopes"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires"`
+	LastUsed *time.Time `json:"last_used"`
+}
+
+func newApiKeyResponse(key auth.ApiKey) apiKeyResponse {
+	res := apiKeyResponse{
+		Id:      key.Id,
+		Name:    key.Name,
+		Scopes:  key.Scopes,
+		Created: key.Created,
+	}
+	if !key.Expires.IsZero() {
+		res.Expires = &key.Expires
+	}
+	if !key.LastUsed.IsZero() {
+		res.LastUsed = &key.LastUsed
+	}
+	return res
+}
+
+// HTTP handler for a user's keys: GET lists them, POST makes a new one
+func (as *Service) handleMyKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		as.handleGetMyKeys(w, r)
+	case http.MethodPost:
+		as.handleCreateMyKey(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	}
+}
+
+// keyOwner is the user whose keys a request is for. It writes the response itself if the request
+// can't manage keys.
+func (as *Service) keyOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return "", false
+	}
+	if _, ok := authuserctx.FromApiKeyContext(ctx); ok {
+		writeProblem(w, r, http.StatusForbidden, "API keys can't be used to manage API keys")
+		return "", false
+	}
+	return owner, true
+}
+
+// The context of a call to the auth service's API key RPCs, with the key that says it's the API
+// calling them
+func (as *Service) serviceContext(r *http.Request) context.Context {
+	return auth.WithServiceKey(r.Context(), as.config.AuthServiceKey)
+}
+
+// HTTP handler for listing a user's keys
+func (as *Service) handleGetMyKeys(w http.ResponseWriter, r *http.Request) {
+	owner, ok := as.keyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := as.authClient.ListApiKeys(as.serviceContext(r), owner)
+	if err != nil {
+		fmt.Printf("api: ListApiKeys failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	response := struct {
+		Keys []apiKeyResponse `json:"keys"`
+	}{
+		Keys: make([]apiKeyResponse, 0, len(keys)),
+	}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, newApiKeyResponse(key))
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for making a key. The request body is JSON:
+//
+//	{"name": "ci", "scopes": ["notes:read"], "expires": "2030-01-01T00:00:00Z"}
+//
+// Expires can be left out for a key that lasts until it's revoked. The key itself is in the response,
+// and this is the only time it can be seen.
+func (as *Service) handleCreateMyKey(w http.ResponseWriter, r *http.Request) {
+	owner, ok := as.keyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name    string     `json:"name"`
+		Scopes  []string   `json:"scopes"`
+		Expires *time.Time `json:"expires"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		fmt.Printf("api: could not decode api key: %v\n", err)
+		writeProblem(w, r, http.StatusBadRequest, "body must be a JSON object with name, scopes and optionally expires")
+		return
+	}
+	key := auth.ApiKey{
+		Name:   input.Name,
+		Scopes: input.Scopes,
+	}
+	if input.Expires != nil {
+		key.Expires = *input.Expires
+	}
+	if err := auth.ValidateApiKey(key, time.Now()); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, secret, err := as.authClient.CreateApiKey(as.serviceContext(r), owner, key)
+	// The auth service has the last word on what a key can be, which could be stricter than ours
+	var invalid *auth.InvalidApiKeyError
+	if errors.As(err, &invalid) {
+		writeProblem(w, r, http.StatusBadRequest, invalid.Reason)
+		return
+	}
+	if err != nil {
+		fmt.Printf("api: CreateApiKey failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	response := struct {
+		Key    apiKeyResponse `json:"key"`
+		Secret string         `json:"secret"`
+	}{
+		Key:    newApiKeyResponse(*created),
+		Secret: secret,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	// The key is as good as a password, so mustn't be kept by anything between us and the client
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// HTTP handler for revoking a key. The URL.Path will be something like /1/my/keys/abc123.
+func (as *Service) handleMyKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	owner, ok := as.keyOwner(w, r)
+	if !ok {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/1/my/keys/")
+	if id == "" || strings.Contains(id, "/") {
+		writeProblem(w, r, http.StatusNotFound, "")
+		return
+	}
+
+	revoked, err := as.authClient.RevokeApiKey(as.serviceContext(r), owner, id)
+	if err != nil {
+		fmt.Printf("api: RevokeApiKey failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if !revoked {
+		writeProblem(w, r, http.StatusNotFound, "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestCreateMyKey(t *testing.T) {
+	as := New(defaultConfig)
+	client := auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+	as.authClient = client
+
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	body := `{"name": "ci", "scopes": ["notes:read"], "expires": "` + expires.Format(time.RFC3339) + `"}`
+	req, err := http.NewRequest("POST", "/1/my/keys", strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, res.Code, res.Body)
+	}
+	if cacheControl := res.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Fatalf("expected Cache-Control no-store, got %q", cacheControl)
+	}
+	var data struct {
+		Key    apiKeyResponse `json:"key"`
+		Secret string         `json:"secret"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Secret != auth.MockApiKey {
+		t.Fatalf("expected the key %q, got %q", auth.MockApiKey, data.Secret)
+	}
+	if data.Key.Name != "ci" || len(data.Key.Scopes) != 1 || data.Key.Expires == nil || !data.Key.Expires.Equal(expires) || data.Key.LastUsed != nil {
+		t.Fatalf("unexpected key %+v", data.Key)
+	}
+
+	// The new key is listed, without the key itself
+	req, err = http.NewRequest("GET", "/1/my/keys", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	assertJSON(res.Body.Bytes(), struct {
+		Keys []apiKeyResponse `json:"keys"`
+	}{Keys: []apiKeyResponse{data.Key}}, t)
+}
+
+func TestCreateMyKeyInvalid(t *testing.T) {
+	as := New(defaultConfig)
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	tests := map[string]struct {
+		body     string
+		expected string
+	}{
+		"not JSON":      {`ci`, "body must be a JSON object"},
+		"unknown field": {`{"name": "ci", "scopes": ["notes:read"], "admin": true}`, "body must be a JSON object"},
+		"no name":       {`{"scopes": ["notes:read"]}`, "needs a name"},
+		"no scopes":     {`{"name": "ci"}`, "at least one scope"},
+		"unknown scope": {`{"name": "ci", "scopes": ["notes:delete"]}`, "unknown scope"},
+		"expired":       {`{"name": "ci", "scopes": ["notes:read"], "expires": "2000-01-01T00:00:00Z"}`, "in the past"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/1/my/keys", strings.NewReader(test.body))
+			if err != nil {
+				log.Fatal(err)
+			}
+			req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+			res := httptest.NewRecorder()
+			handler := as.Handler()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.Code)
+			}
+			if !strings.Contains(res.Body.String(), test.expected) {
+				t.Fatalf("expected %q in the problem, got %s", test.expected, res.Body)
+			}
+		})
+	}
+}
+
+// strictKeyClient is a mock auth client whose service doesn't know of a scope the API does
+type strictKeyClient struct {
+	*auth.MockClient
+}
+
+func (c *strictKeyClient) CreateApiKey(ctx context.Context, userId string, key auth.ApiKey) (*auth.ApiKey, string, error) {
+	return nil, "", fmt.Errorf("failed to create api key: %w", &auth.InvalidApiKeyError{Reason: fmt.Sprintf("unknown scope %q", key.Scopes[0])})
+}
+
+func TestCreateMyKeyRejectedByAuth(t *testing.T) {
+	as := New(defaultConfig)
+	as.authClient = &strictKeyClient{auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})}
+
+	req, err := http.NewRequest("POST", "/1/my/keys", strings.NewReader(`{"name": "ci", "scopes": ["notes:write"]}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+	res := httptest.NewRecorder()
+	as.Handler().ServeHTTP(res, req)
+
+	// It's what was asked for that's wrong, not the API
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.Code)
+	}
+	if !strings.Contains(res.Body.String(), `unknown scope \"notes:write\"`) {
+		t.Fatalf("expected the auth service's reason in the problem, got %s", res.Body)
+	}
+}
+
+func TestRevokeMyKey(t *testing.T) {
+	as := New(defaultConfig)
+	client := auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+	as.authClient = client
+	key, _, err := client.CreateApiKey(context.Background(), "abc123", auth.ApiKey{Name: "ci", Scopes: auth.AllScopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second time, there's no such key
+	for _, expected := range []int{http.StatusNoContent, http.StatusNotFound} {
+		req, err := http.NewRequest("DELETE", "/1/my/keys/"+key.Id, strings.NewReader(""))
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+		res := httptest.NewRecorder()
+		handler := as.Handler()
+		handler.ServeHTTP(res, req)
+
+		if res.Code != expected {
+			t.Fatalf("expected status %d, got %d", expected, res.Code)
+		}
+	}
+}
+
+func TestMyKeysWithApiKey(t *testing.T) {
+	as := New(defaultConfig)
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State:  auth.StateAllow,
+		Scopes: auth.AllScopes,
+		KeyId:  "mock-key-1",
+	})
+
+	// Even a key that can do everything else can't make more keys
+	for _, method := range []string{"GET", "POST"} {
+		req, err := http.NewRequest(method, "/1/my/keys", strings.NewReader(`{"name": "ci", "scopes": ["notes:write"]}`))
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Add("Authorization", "Bearer "+auth.MockApiKey)
+		res := httptest.NewRecorder()
+		handler := as.Handler()
+		handler.ServeHTTP(res, req)
+
+		if res.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected status %d, got %d", method, http.StatusForbidden, res.Code)
+		}
+	}
+}
+
+func TestReadOnlyApiKey(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State:  auth.StateAllow,
+		Scopes: []string{auth.ScopeNotesRead},
+		KeyId:  "mock-key-1",
+	})
+
+	// Reading is fine...
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified"})
+	expectNotesVersion(mock, 0, time.Time{}).WithArgs(auth.MockUserId)
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE owner = (.+) ORDER BY created, id LIMIT (.+)$").
+		WithArgs(auth.MockUserId, model.DefaultPageSize+1).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/1/my/notes.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+auth.MockApiKey)
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	// ... but writing needs notes:write, and doesn't reach the database
+	req, err = http.NewRequest("POST", "/1/my/notes.json", strings.NewReader(`{"content": "Hello"}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+auth.MockApiKey)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, res.Code)
+	}
+	if !strings.Contains(res.Body.String(), auth.ScopeNotesWrite) {
+		t.Fatalf("expected the problem to name the missing scope, got %s", res.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
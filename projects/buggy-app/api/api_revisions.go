@@ -0,0 +1,222 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// Every update to a note keeps its previous content as a numbered revision:
+//
+//	GET  /1/my/note/:id/revisions.json
+//	GET  /1/my/note/:id/revisions/:rev.json
+//	GET  /1/my/note/:id/revisions/diff.json?from=:rev&to=:rev
+//	POST /1/my/note/:id/revisions/:rev/restore.json
+//
+// The diff is a unified diff, like `diff -u` or git produce. Leaving out `to` compares with the
+// note's current content.
+
+// HTTP handler for listing a note's revisions, newest first
+func (as *Service) handleMyNoteRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	user, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	id := noteSegmentsFromPath(r.URL.Path)[0]
+	revisions, err := model.GetRevisions(ctx, as.pool, user, id)
+	if err != nil {
+		fmt.Printf("api: GetRevisions failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Revisions []model.Revision `json:"revisions"`
+	}{
+		Revisions: revisions,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for getting one revision of a note. The URL.Path will be something
+// like /1/my/note/abc123/revisions/2.json, or 2.md, 2.txt or 2.html.
+func (as *Service) handleMyNoteRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	user, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	encoding, ok := negotiate(w, r, noteEncodings)
+	if !ok {
+		return
+	}
+
+	segments := noteSegmentsFromPath(r.URL.Path)
+	revision, err := strconv.Atoi(strings.TrimSuffix(segments[2], path.Ext(segments[2])))
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, "")
+		return
+	}
+
+	rev, err := model.GetRevision(ctx, as.pool, user, segments[0], revision)
+	if err != nil {
+		fmt.Printf("api: GetRevision failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := revisionResponse{
+		Revision: rev,
+	}
+
+	res, err := encoding.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", encoding.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for a unified diff between two revisions of a note
+func (as *Service) handleMyNoteRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	user, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := strconv.Atoi(query.Get("from"))
+	if err != nil {
+		fmt.Printf("api: bad diff revision: from %q\n", query.Get("from"))
+		writeProblem(w, r, http.StatusBadRequest, "from must be a revision number")
+		return
+	}
+	to := model.CurrentRevision
+	if query.Get("to") != "" {
+		to, err = strconv.Atoi(query.Get("to"))
+		if err != nil || to < 1 {
+			fmt.Printf("api: bad diff revision: to %q\n", query.Get("to"))
+			writeProblem(w, r, http.StatusBadRequest, "to must be a revision number")
+			return
+		}
+	}
+
+	id := noteSegmentsFromPath(r.URL.Path)[0]
+	diff, err := model.DiffRevisions(ctx, as.pool, user, id, from, to)
+	if err != nil {
+		fmt.Printf("api: DiffRevisions failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Diff model.RevisionDiff `json:"diff"`
+	}{
+		Diff: diff,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for making an old revision of a note its current content. The URL.Path will be
+// something like /1/my/note/abc123/revisions/2/restore.json.
+func (as *Service) handleRestoreMyNoteRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	user, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	segments := noteSegmentsFromPath(r.URL.Path)
+	revision, err := strconv.Atoi(segments[2])
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, "")
+		return
+	}
+
+	note, err := model.RestoreRevision(ctx, as.pool, user, segments[0], revision)
+	if err != nil {
+		fmt.Printf("api: RestoreRevision failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Note model.Note `json:"note"`
+	}{
+		Note: note,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Header().Set("ETag", noteETag(note))
+	w.Write(res)
+}
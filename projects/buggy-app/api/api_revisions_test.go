@@ -0,0 +1,178 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestMyNoteRevisions(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, first, second := "xyz789", time.Now().Add(-time.Hour), time.Now().Add(-time.Minute)
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, "Third", first, time.Now(), ""))
+	mock.ExpectQuery("^SELECT (.+) FROM public.note_revision WHERE note_id = (.+) ORDER BY revision DESC$").
+		WithArgs(noteId).
+		WillReturnRows(mock.NewRows([]string{"note_id", "revision", "content", "created"}).
+			AddRow(noteId, 2, "Second", second).
+			AddRow(noteId, 1, "First", first))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/1/my/note/%s/revisions.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Revisions []model.Revision `json:"revisions"`
+	}{Revisions: []model.Revision{
+		{NoteId: noteId, Revision: 2, Content: "Second", Created: second},
+		{NoteId: noteId, Revision: 1, Content: "First", Created: first},
+	}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNoteRevisionDiff(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId := "xyz789"
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, "Shopping\nbread\nmilk\n", time.Now(), time.Now(), ""))
+	mock.ExpectQuery("^SELECT (.+) FROM public.note_revision WHERE note_id = (.+) AND revision = (.+)$").
+		WithArgs(noteId, 1).
+		WillReturnRows(mock.NewRows([]string{"note_id", "revision", "content", "created"}).
+			AddRow(noteId, 1, "Shopping\nbread\n", time.Now()))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/1/my/note/%s/revisions/diff.json?from=1", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Diff model.RevisionDiff `json:"diff"`
+	}{Diff: model.RevisionDiff{
+		NoteId: noteId,
+		From:   1,
+		To:     model.CurrentRevision,
+		Diff:   "--- revision 1\n+++ current\n@@ -1,2 +1,3 @@\n Shopping\n bread\n+milk\n",
+	}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRestoreMyNoteRevision(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, content, created, modified := "xyz789", "Old content #tag1", time.Now(), time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n (.+) FOR UPDATE OF n$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, "New content", created, time.Now(), ""))
+	mock.ExpectQuery("^SELECT (.+) FROM public.note_revision WHERE note_id = (.+) AND revision = (.+)$").
+		WithArgs(noteId, 1).
+		WillReturnRows(mock.NewRows([]string{"note_id", "revision", "content", "created"}).
+			AddRow(noteId, 1, content, created))
+	mock.ExpectQuery("^UPDATE public.note SET content = (.+) WHERE id = (.+) RETURNING (.+)$").
+		WithArgs(content, noteId).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified"}).
+			AddRow(noteId, id, content, created, modified))
+	mock.ExpectExec("^DELETE FROM public.note_tag WHERE note_id = (.+)$").
+		WithArgs(noteId).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectExec("^INSERT INTO public.note_tag (.+)$").
+		WithArgs(noteId, []string{"tag1"}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/1/my/note/%s/revisions/1/restore.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Note model.Note `json:"note"`
+	}{Note: model.Note{Id: noteId, Owner: id, Content: content, Created: created, Modified: modified, Tags: []string{"tag1"}}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// HTTP handler for searching the authenticated user's notes:
+//
+//	GET /1/my/notes/search.json?q="banana bread" ban*&limit=10
+//
+// See model.SearchNotes for the query syntax.
+func (as *Service) handleSearchMyNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	encoding, ok := negotiate(w, r, listEncodings)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 0
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			fmt.Printf("api: invalid search limit %q\n", l)
+			writeProblem(w, r, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+
+	results, err := model.SearchNotes(ctx, as.pool, owner, query.Get("q"), limit)
+	if err != nil {
+		fmt.Printf("api: SearchNotes failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := searchResponse{
+		Results: results,
+	}
+
+	res, err := encoding.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", encoding.ContentType)
+	w.Write(res)
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// Sessions let clients send a short-lived access token with each request, instead of a password.
+// Logging in with basic auth starts a session, the refresh token gets new tokens before the access
+// token expires, and deleting the session logs out. The auth service keeps track of sessions: the
+// API only passes tokens on.
+
+// The tokens of a session, in the style of an OAuth 2.0 token response
+type sessionResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// HTTP handler for logging in: POST with basic auth starts a session
+func (as *Service) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	// Logging in fails like any other authentication, and is limited the same way
+	ip := clientIP(r)
+	if decision := as.anonymousLimiter.peek(ip); !decision.allowed {
+		log.Printf("api: rate limited: ip %v\n", ip)
+		writeRateLimit(w, r, decision)
+		return
+	}
+	id, passwd, ok := r.BasicAuth()
+	if !ok {
+		as.unauthorized(w, r, ip)
+		return
+	}
+
+	session, err := as.authClient.Login(auth.WithSource(r.Context(), ip), id, passwd)
+	if err != nil {
+		fmt.Printf("api: Login failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if session.State != auth.StateAllow {
+		log.Printf("api: login denied: id %v, reason %v\n", id, session.Reason)
+		as.unauthorized(w, r, ip)
+		return
+	}
+	writeSession(w, r, session, http.StatusCreated)
+}
+
+// HTTP handler for refreshing a session: POST a JSON body like {"refresh_token": "..."}
+func (as *Service) handleRefreshSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ip := clientIP(r)
+	if decision := as.anonymousLimiter.peek(ip); !decision.allowed {
+		log.Printf("api: rate limited: ip %v\n", ip)
+		writeRateLimit(w, r, decision)
+		return
+	}
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		fmt.Printf("api: could not decode refresh token: %v\n", err)
+		writeProblem(w, r, http.StatusBadRequest, "body must be a JSON object with refresh_token")
+		return
+	}
+	if input.RefreshToken == "" {
+		writeProblem(w, r, http.StatusBadRequest, "refresh_token must not be empty")
+		return
+	}
+
+	session, err := as.authClient.Refresh(r.Context(), input.RefreshToken)
+	if err != nil {
+		fmt.Printf("api: Refresh failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if session.State != auth.StateAllow {
+		log.Printf("api: refresh denied\n")
+		as.unauthorized(w, r, ip)
+		return
+	}
+	writeSession(w, r, session, http.StatusOK)
+}
+
+// HTTP handler for logging out: DELETE ends the session of the request's access token
+func (as *Service) handleMySession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	if _, ok := authuserctx.FromAuthenticatedContext(ctx); !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+	token, ok := bearerToken(r)
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, "there is no session to end without a bearer token")
+		return
+	}
+
+	if _, err := as.authClient.Revoke(ctx, token); err != nil {
+		fmt.Printf("api: Revoke failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeSession(w http.ResponseWriter, r *http.Request, session *auth.Session, status int) {
+	res, err := util.JSON.Encode(sessionResponse{
+		AccessToken:  session.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(session.ExpiresIn / time.Second),
+		RefreshToken: session.RefreshToken,
+	})
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	// Tokens are as good as passwords, so mustn't be kept by anything between us and the client
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(res)
+}
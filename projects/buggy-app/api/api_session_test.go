@@ -0,0 +1,202 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestLogin(t *testing.T) {
+	as := New(defaultConfig)
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	req, err := http.NewRequest("POST", "/1/sessions", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, res.Code)
+	}
+	if cacheControl := res.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Fatalf("expected Cache-Control no-store, got %q", cacheControl)
+	}
+	assertJSON(res.Body.Bytes(), sessionResponse{
+		AccessToken:  auth.MockAccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.DefaultAccessTokenTTL / time.Second),
+		RefreshToken: auth.MockRefreshToken,
+	}, t)
+}
+
+func TestLoginDenied(t *testing.T) {
+	as := New(defaultConfig)
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateDeny,
+	})
+
+	tests := map[string]struct {
+		method        string
+		authorization string
+		expected      int
+	}{
+		"wrong password": {"POST", util.BasicAuthHeaderValue("abc123", "wrong"), http.StatusUnauthorized},
+		"no password":    {"POST", "", http.StatusUnauthorized},
+		"GET":            {"GET", util.BasicAuthHeaderValue("abc123", "wrong"), http.StatusMethodNotAllowed},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, "/1/sessions", strings.NewReader(""))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if test.authorization != "" {
+				req.Header.Add("Authorization", test.authorization)
+			}
+			res := httptest.NewRecorder()
+			handler := as.Handler()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != test.expected {
+				t.Fatalf("expected status %d, got %d", test.expected, res.Code)
+			}
+		})
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	tests := map[string]struct {
+		state    string
+		body     string
+		expected int
+	}{
+		"allowed":       {auth.StateAllow, `{"refresh_token": "abc.def"}`, http.StatusOK},
+		"denied":        {auth.StateDeny, `{"refresh_token": "abc.def"}`, http.StatusUnauthorized},
+		"no token":      {auth.StateAllow, `{}`, http.StatusBadRequest},
+		"unknown field": {auth.StateAllow, `{"refresh": "abc.def"}`, http.StatusBadRequest},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			as := New(defaultConfig)
+			as.authClient = auth.NewMockClient(&auth.VerifyResult{
+				State: test.state,
+			})
+
+			req, err := http.NewRequest("POST", "/1/sessions/refresh", strings.NewReader(test.body))
+			if err != nil {
+				log.Fatal(err)
+			}
+			res := httptest.NewRecorder()
+			handler := as.Handler()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != test.expected {
+				t.Fatalf("expected status %d, got %d", test.expected, res.Code)
+			}
+		})
+	}
+}
+
+func TestMyNotesBearerAuth(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	// The notes are the token's user's
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified"})
+	expectNotesVersion(mock, 0, time.Time{}).WithArgs(auth.MockUserId)
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE owner = (.+) ORDER BY created, id LIMIT (.+)$").
+		WithArgs(auth.MockUserId, model.DefaultPageSize+1).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/1/my/notes.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", "Bearer "+auth.MockAccessToken)
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNotesBearerAuthDenied(t *testing.T) {
+	as := New(defaultConfig)
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateDeny,
+	})
+
+	for _, authorization := range []string{"Bearer revoked", "Bearer ", "Bearer"} {
+		req, err := http.NewRequest("GET", "/1/my/notes.json", strings.NewReader(""))
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Add("Authorization", authorization)
+		res := httptest.NewRecorder()
+		handler := as.Handler()
+		handler.ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expected status %d, got %d", authorization, http.StatusUnauthorized, res.Code)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	as := New(defaultConfig)
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	tests := map[string]struct {
+		authorization string
+		expected      int
+	}{
+		"bearer": {"Bearer " + auth.MockAccessToken, http.StatusNoContent},
+		// There's no session to end
+		"basic": {util.BasicAuthHeaderValue("abc123", "password"), http.StatusBadRequest},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("DELETE", "/1/my/session", strings.NewReader(""))
+			if err != nil {
+				log.Fatal(err)
+			}
+			req.Header.Add("Authorization", test.authorization)
+			res := httptest.NewRecorder()
+			handler := as.Handler()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != test.expected {
+				t.Fatalf("expected status %d, got %d", test.expected, res.Code)
+			}
+		})
+	}
+}
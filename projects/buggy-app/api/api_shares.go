@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// The owner of a note can share it with other users, who can then read it (and, with write
+// permission, update it) at the usual /1/my/note/:id.json URL:
+//
+//	GET    /1/my/note/:id/shares.json
+//	POST   /1/my/note/:id/shares.json
+//	DELETE /1/my/note/:id/shares/:user.json
+//	GET    /1/shared/notes.json
+
+// Split the part of the URL.Path after /1/my/note/ into segments, so /1/my/note/abc123/shares.json
+// gives ["abc123", "shares.json"].
+func noteSegmentsFromPath(urlPath string) []string {
+	return strings.Split(strings.TrimPrefix(urlPath, "/1/my/note/"), "/")
+}
+
+// HTTP handler for everything under /1/my/note/, which routes to the note itself, its shares or
+// its revisions
+func (as *Service) handleMyNote(w http.ResponseWriter, r *http.Request) {
+	segments := noteSegmentsFromPath(r.URL.Path)
+	switch {
+	case len(segments) == 1:
+		as.handleMyNoteById(w, r)
+	case len(segments) == 2 && segments[1] == "shares.json":
+		as.handleMyNoteShares(w, r)
+	case len(segments) == 3 && segments[1] == "shares" && strings.HasSuffix(segments[2], ".json"):
+		as.handleRevokeMyNoteShare(w, r)
+	case len(segments) == 2 && segments[1] == "revisions.json":
+		as.handleMyNoteRevisions(w, r)
+	case len(segments) == 3 && segments[1] == "revisions" && segments[2] == "diff.json":
+		as.handleMyNoteRevisionDiff(w, r)
+	case len(segments) == 3 && segments[1] == "revisions":
+		as.handleMyNoteRevision(w, r)
+	case len(segments) == 4 && segments[1] == "revisions" && segments[3] == "restore.json":
+		as.handleRestoreMyNoteRevision(w, r)
+	default:
+		writeProblem(w, r, http.StatusNotFound, "")
+	}
+}
+
+// HTTP handler for a note's shares: GET lists them, POST shares the note with another user
+func (as *Service) handleMyNoteShares(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		as.handleGetMyNoteShares(w, r)
+	case http.MethodPost:
+		as.handleGrantMyNoteShare(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+	}
+}
+
+// HTTP handler for listing the users a note has been shared with
+func (as *Service) handleGetMyNoteShares(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	id := noteSegmentsFromPath(r.URL.Path)[0]
+	shares, err := model.GetSharesForNote(ctx, as.pool, owner, id)
+	if err != nil {
+		fmt.Printf("api: GetSharesForNote failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Shares []model.Share `json:"shares"`
+	}{
+		Shares: shares,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for sharing a note with another user. The request body is JSON:
+//
+//	{"user": "abc123", "permission": "read"}
+//
+// Permission is "read" or "write". Sharing with a user who already has access changes their permission.
+func (as *Service) handleGrantMyNoteShare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	var input struct {
+		User       string           `json:"user"`
+		Permission model.Permission `json:"permission"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		fmt.Printf("api: could not decode share: %v\n", err)
+		writeProblem(w, r, http.StatusBadRequest, "body must be a JSON object with user and permission")
+		return
+	}
+
+	id := noteSegmentsFromPath(r.URL.Path)[0]
+	share, err := model.GrantShare(ctx, as.pool, owner, id, input.User, input.Permission)
+	if err != nil {
+		fmt.Printf("api: GrantShare failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Share model.Share `json:"share"`
+	}{
+		Share: share,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for no longer sharing a note with a user. The URL.Path will be something
+// like /1/my/note/abc123/shares/def456.json.
+func (as *Service) handleRevokeMyNoteShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	segments := noteSegmentsFromPath(r.URL.Path)
+	id, grantee := segments[0], strings.TrimSuffix(segments[2], ".json")
+	err := model.RevokeShare(ctx, as.pool, owner, id, grantee)
+	if err != nil {
+		fmt.Printf("api: RevokeShare failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP handler for listing the notes other users have shared with the authenticated user
+func (as *Service) handleSharedNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	user, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	encoding, ok := negotiate(w, r, listEncodings)
+	if !ok {
+		return
+	}
+
+	notes, err := model.GetNotesSharedWith(ctx, as.pool, user)
+	if err != nil {
+		fmt.Printf("api: GetNotesSharedWith failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	response := sharedNotesResponse{
+		Notes: notes,
+	}
+
+	res, err := encoding.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", encoding.ContentType)
+	w.Write(res)
+}
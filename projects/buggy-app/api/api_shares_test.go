@@ -0,0 +1,350 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestSharedNoteById(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "def456", "password"
+	owner, noteId, content, created, modified := "abc123", "xyz789", "Note content", time.Now(), time.Now()
+
+	// Someone else's note, shared with this user
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, owner, content, created, modified, "read"))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/1/my/note/%s.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Note model.Note `json:"note"`
+	}{Note: model.Note{Id: noteId, Owner: owner, Content: content, Created: created, Modified: modified, Tags: []string{}}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNoteByIdNotShared(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "def456", "password"
+	noteId := "xyz789"
+
+	// The note exists, but it isn't this user's and hasn't been shared with them
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, "abc123", "Note content", time.Now(), time.Now(), ""))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/1/my/note/%s.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateSharedNoteReadOnly(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "def456", "password"
+	noteId := "xyz789"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n (.+) FOR UPDATE OF n$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, "abc123", "Note content", time.Now(), time.Now(), "read"))
+	mock.ExpectRollback()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/1/my/note/%s.json", noteId), strings.NewReader(`{"content":"New content"}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTrashSharedNote(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "def456", "password"
+	noteId := "xyz789"
+
+	// Write access isn't enough: only the owner can delete a note
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n (.+) FOR UPDATE OF n$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, "abc123", "Note content", time.Now(), time.Now(), "write"))
+	mock.ExpectRollback()
+
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/1/my/note/%s.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGrantShare(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, grantee, created := "xyz789", "def456", time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n (.+) FOR UPDATE OF n$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, "Note content", time.Now(), time.Now(), ""))
+	mock.ExpectQuery("^INSERT INTO public.note_share (.+) ON CONFLICT (.+) RETURNING (.+)$").
+		WithArgs(noteId, grantee, model.PermissionWrite).
+		WillReturnRows(mock.NewRows([]string{"note_id", "grantee", "permission", "created"}).
+			AddRow(noteId, grantee, "write", created))
+	mock.ExpectCommit()
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/1/my/note/%s/shares.json", noteId), strings.NewReader(`{"user":"def456","permission":"write"}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Share model.Share `json:"share"`
+	}{Share: model.Share{NoteId: noteId, User: grantee, Permission: model.PermissionWrite, Created: created}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGrantShareInvalidPermission(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	req, err := http.NewRequest("POST", "/1/my/note/xyz789/shares.json", strings.NewReader(`{"user":"def456","permission":"owner"}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRevokeShare(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, grantee := "xyz789", "def456"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n (.+) FOR UPDATE OF n$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, "Note content", time.Now(), time.Now(), ""))
+	mock.ExpectExec("^DELETE FROM public.note_share WHERE note_id = (.+) AND grantee = (.+)$").
+		WithArgs(noteId, grantee).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/1/my/note/%s/shares/%s.json", noteId, grantee), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSharedNotes(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "def456", "password"
+	owner, noteId, content, created, modified := "abc123", "xyz789", "Note content #tag1", time.Now(), time.Now()
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note_share s JOIN public.note n (.+) WHERE s.grantee = (.+)$").
+		WithArgs(id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, owner, content, created, modified, "read"))
+
+	req, err := http.NewRequest("GET", "/1/shared/notes.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Notes []model.SharedNote `json:"notes"`
+	}{Notes: []model.SharedNote{{
+		Note:       model.Note{Id: noteId, Owner: owner, Content: content, Created: created, Modified: modified, Tags: []string{"tag1"}},
+		Permission: model.PermissionRead,
+	}}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestMyNotesByTagAny(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, content, created, modified := "xyz789", "Note content #work", time.Now(), time.Now()
+
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified"}).
+		AddRow(noteId, id, content, created, modified)
+
+	// Duplicate tags in the query are ignored
+	expectNotesVersion(mock, 1, modified).WithArgs(id, []string{"work", "home"})
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE owner = (.+) AND id IN \\(SELECT note_id FROM public.note_tag WHERE tag = ANY\\(\\$3\\)\\) ORDER BY (.+)$").
+		WithArgs(id, model.DefaultPageSize+1, []string{"work", "home"}).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/1/my/notes.json?tag=work&tag=home&tag=work", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Notes []model.Note `json:"notes"`
+	}{Notes: []model.Note{
+		{Id: noteId, Owner: id, Content: content, Created: created, Modified: modified, Tags: []string{"work"}},
+	}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNotesByTagAll(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	expectNotesVersion(mock, 0, time.Time{}).WithArgs(id, []string{"work", "home"}, 2)
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE (.+) tag = ANY\\(\\$3\\) GROUP BY note_id HAVING count\\(\\*\\) = \\$4\\) ORDER BY (.+)$").
+		WithArgs(id, model.DefaultPageSize+1, []string{"work", "home"}, 2).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified"}))
+
+	req, err := http.NewRequest("GET", "/1/my/notes.json?tag=work&tag=home&match=all", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNotesByTagBadMatch(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	req, err := http.NewRequest("GET", "/1/my/notes.json?tag=work&match=most", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "password"))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestMyTags(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	rows := mock.NewRows([]string{"tag", "count"}).
+		AddRow("work", 3).
+		AddRow("home", 1)
+
+	mock.ExpectQuery("SELECT t.tag, count(.+) FROM public.note_tag t (.+) WHERE n.owner = (.+) GROUP BY t.tag").
+		WithArgs(id).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/1/my/tags.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Tags []model.TagCount `json:"tags"`
+	}{Tags: []model.TagCount{{Tag: "work", Count: 3}, {Tag: "home", Count: 1}}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// Deleting a note moves it to the trash, from where it can be listed and restored:
+//
+//	DELETE /1/my/note/:id.json
+//	GET    /1/my/trash.json
+//	POST   /1/my/trash/:id/restore.json
+//
+// Notes that have been in the trash for longer than Config.TrashRetention are deleted for good by
+// purgeTrash, which runs in the background for as long as the Service does.
+
+// HTTP handler for moving a note to the trash
+func (as *Service) handleTrashMyNote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	id := noteIdFromPath(r.URL.Path)
+	if id == "" {
+		fmt.Printf("api: no ID supplied: url path %v\n", r.URL.Path)
+		writeProblem(w, r, http.StatusBadRequest, "")
+		return
+	}
+
+	err := model.TrashNote(ctx, as.pool, owner, id)
+	if err != nil {
+		fmt.Printf("api: TrashNote failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP handler for listing the notes in the trash
+func (as *Service) handleMyTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	encoding, ok := negotiate(w, r, listEncodings)
+	if !ok {
+		return
+	}
+
+	notes, err := model.GetTrashForOwner(ctx, as.pool, owner)
+	if err != nil {
+		fmt.Printf("api: GetTrashForOwner failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	response := trashResponse{
+		Notes: notes,
+	}
+
+	res, err := encoding.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", encoding.ContentType)
+	w.Write(res)
+}
+
+// HTTP handler for taking a note back out of the trash. The URL.Path will be something
+// like /1/my/trash/abc123/restore.json.
+func (as *Service) handleRestoreMyNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	ctx := r.Context()
+	owner, ok := authuserctx.FromAuthenticatedContext(ctx)
+	if !ok {
+		as.config.Log.Printf("api: route handler reached with invalid auth context")
+		writeProblem(w, r, http.StatusUnauthorized, "")
+		return
+	}
+
+	id, action, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/1/my/trash/"), "/")
+	if !found || id == "" || action != "restore.json" {
+		writeProblem(w, r, http.StatusNotFound, "")
+		return
+	}
+
+	note, err := model.RestoreNote(ctx, as.pool, owner, id)
+	if err != nil {
+		fmt.Printf("api: RestoreNote failed: %v\n", err)
+		writeError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Note model.Note `json:"note"`
+	}{
+		Note: note,
+	}
+
+	res, err := util.JSON.Encode(response)
+	if err != nil {
+		fmt.Printf("api: response marshal failed: %v\n", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.Header().Set("ETag", noteETag(note))
+	w.Write(res)
+}
+
+// Periodically delete notes that have been in the trash for longer than the retention period,
+// until the context is cancelled.
+func (as *Service) purgeTrash(ctx context.Context) {
+	if as.config.TrashRetention <= 0 || as.config.TrashPurgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(as.config.TrashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := model.PurgeTrash(ctx, as.pool, as.config.TrashRetention)
+			if err != nil {
+				as.config.Log.Printf("api: purge trash failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				as.config.Log.Printf("api: purged %d notes from the trash", n)
+			}
+		}
+	}
+}
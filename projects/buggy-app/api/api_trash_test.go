@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestTrashNote(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId := "xyz789"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+) FOR UPDATE OF n$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, "Note content", time.Now(), time.Now(), ""))
+	mock.ExpectExec("^UPDATE public.note SET deleted_at = now\\(\\) WHERE id = (.+)$").
+		WithArgs(noteId).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/1/my/note/%s.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d", http.StatusNoContent, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTrashNoteNotFound(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId := "xyz789"
+
+	// Already in the trash, or not visible to this user
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}))
+	mock.ExpectRollback()
+
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("/1/my/note/%s.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyTrash(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, content, created, modified, deleted := "xyz789", "Note content", time.Now(), time.Now(), time.Now()
+
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified", "deleted_at"}).
+		AddRow(noteId, id, content, created, modified, &deleted)
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE owner = (.+) AND deleted_at IS NOT NULL (.+)$").
+		WithArgs(id).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/1/my/trash.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Notes []model.Note `json:"notes"`
+	}{Notes: []model.Note{
+		{Id: noteId, Owner: id, Content: content, Created: created, Modified: modified, Tags: []string{}, Deleted: &deleted},
+	}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRestoreNote(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, content, created, modified := "xyz789", "Note content", time.Now(), time.Now()
+
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified"}).
+		AddRow(noteId, id, content, created, modified)
+
+	mock.ExpectQuery("^UPDATE public.note SET deleted_at = NULL WHERE id = (.+) AND owner = (.+) AND deleted_at IS NOT NULL RETURNING (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/1/my/trash/%s/restore.json", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	data := struct {
+		Note model.Note `json:"note"`
+	}{Note: model.Note{Id: noteId, Owner: id, Content: content, Created: created, Modified: modified, Tags: []string{}}}
+	assertJSON(res.Body.Bytes(), data, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	config := defaultConfig
+	config.TrashRetention = 24 * time.Hour
+	config.TrashPurgeInterval = 10 * time.Millisecond
+	as := New(config)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+
+	mock.ExpectExec("^DELETE FROM public.note WHERE deleted_at IS NOT NULL AND deleted_at < (.+)$").
+		WithArgs(float64(24 * 60 * 60)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 2))
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		as.purgeTrash(ctx)
+	}()
+
+	// Give the purge a few chances to run
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil && time.Now().Before(deadline) {
+		<-time.After(10 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
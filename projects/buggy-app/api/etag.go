@@ -0,0 +1,123 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+)
+
+// ETags for notes are derived from the modified timestamp, which the database moves on every time
+// the note is written (see the note_update_modified trigger). Postgres stores timestamps to the
+// microsecond, so that's the precision we encode:
+//
+//	ETag: "5xq1z2m8k0"
+//
+// ETags are strong, so each encoding of a note needs its own: anything other than JSON gets the
+// extension added, like "5xq1z2m8k0-md". Lists of notes have an ETag made from the count of notes
+// in the list and the latest time any of them changed (see model.NotesVersion):
+//
+//	ETag: "2s.5xq1z2m8k0"
+
+func noteETag(note model.Note) string {
+	return `"` + strconv.FormatInt(note.Modified.UnixMicro(), 36) + `"`
+}
+
+func notesETag(version model.NotesVersion) string {
+	return `"` + strconv.FormatInt(int64(version.Count), 36) + "." + strconv.FormatInt(version.Modified.UnixMicro(), 36) + `"`
+}
+
+// The ETag for a particular encoding of a resource
+func encodingETag(etag string, encoding util.Encoding) string {
+	if encoding.Extension == util.JSON.Extension {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + strings.TrimPrefix(encoding.Extension, ".") + `"`
+}
+
+// Parse an ETag we generated for a note, in any encoding, back into the modified timestamp it
+// was made from
+func parseNoteETag(etag string) (time.Time, bool) {
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return time.Time{}, false
+	}
+	version, ext, found := strings.Cut(etag[1:len(etag)-1], "-")
+	if _, ok := noteEncodings.ForExtension("." + ext); found && !ok {
+		return time.Time{}, false
+	}
+	micros, err := strconv.ParseInt(version, 36, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(micros).UTC(), true
+}
+
+// Parse an If-Match header into the modified timestamps the client is prepared to overwrite.
+//
+// An empty header, or "*", means the write is unconditional, so no timestamps are returned. If the header
+// is present but none of its ETags could be ours (weak ETags never match for If-Match) then ok is false,
+// and the precondition has already failed.
+func parseIfMatch(header string) (modified []time.Time, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, true
+	}
+	for _, etag := range strings.Split(header, ",") {
+		if t, ok := parseNoteETag(strings.TrimSpace(etag)); ok {
+			modified = append(modified, t)
+		}
+	}
+	return modified, len(modified) > 0
+}
+
+// Write the caching headers for a response, and check the request's conditions against them
+// (https://httpwg.org/specs/rfc9110.html#conditional.requests). If the client's copy is still
+// current, 304 Not Modified is written and true is returned: the handler has nothing more to do.
+//
+// Responses are private to the user. Clients may keep them, but must check they are current before
+// using them again: they are cheap to check, and notes change often.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence: If-Modified-Since is only one second precise
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if !etagListMatches(header, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || lastModified.IsZero() || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	// A 304 has no body, so the headers that describe one would be wrong
+	w.Header().Del("Content-Type")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Check an If-None-Match header against an ETag. The comparison is weak: a W/ prefix is ignored.
+func etagListMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestParseNoteETag(t *testing.T) {
+	modified := time.Date(2022, 10, 16, 9, 45, 3, 597524000, time.UTC)
+	etag := noteETag(model.Note{Modified: modified})
+
+	valid := []string{etag, encodingETag(etag, util.Markdown), encodingETag(etag, util.HTML)}
+	for _, etag := range valid {
+		parsed, ok := parseNoteETag(etag)
+		if !ok || !parsed.Equal(modified) {
+			t.Errorf("expected %s to parse as %v, got %v (%t)", etag, modified, parsed, ok)
+		}
+	}
+
+	invalid := []string{"", `"`, etag[1:], `"not-ours!"`, strings.TrimSuffix(etag, `"`) + `-csv"`, `W/` + etag}
+	for _, etag := range invalid {
+		if _, ok := parseNoteETag(etag); ok {
+			t.Errorf("expected %s not to parse", etag)
+		}
+	}
+}
+
+func TestMyNoteByIdNotModified(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, content := "xyz789", "Note content"
+	modified := time.Date(2022, 10, 16, 9, 45, 3, 597524000, time.UTC)
+	etag := noteETag(model.Note{Modified: modified})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		status int
+		etag   string
+	}{
+		{"etag matches", "/1/my/note/%s.json", "If-None-Match", etag, http.StatusNotModified, etag},
+		{"weak etag matches", "/1/my/note/%s.json", "If-None-Match", `"other", W/` + etag, http.StatusNotModified, etag},
+		{"etag is stale", "/1/my/note/%s.json", "If-None-Match", noteETag(model.Note{Modified: modified.Add(-time.Second)}), http.StatusOK, etag},
+		{"etag is for another encoding", "/1/my/note/%s.md", "If-None-Match", etag, http.StatusOK, encodingETag(etag, util.Markdown)},
+		{"not modified since", "/1/my/note/%s.json", "If-Modified-Since", modified.Add(time.Second).Format(http.TimeFormat), http.StatusNotModified, etag},
+		{"modified since", "/1/my/note/%s.json", "If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat), http.StatusOK, etag},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+				AddRow(noteId, id, content, modified, modified, "")
+			mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+) WHERE n.id = (.+)$").
+				WithArgs(noteId, id).
+				WillReturnRows(rows)
+
+			req, err := http.NewRequest("GET", fmt.Sprintf(test.path, noteId), strings.NewReader(""))
+			if err != nil {
+				log.Fatal(err)
+			}
+			req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+			req.Header.Add(test.header, test.value)
+			res := httptest.NewRecorder()
+			handler := as.Handler()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, res.Code)
+			}
+			if got := res.Header().Get("ETag"); got != test.etag {
+				t.Fatalf("expected ETag %s, got %s", test.etag, got)
+			}
+			if got := res.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+				t.Fatalf("expected Last-Modified %s, got %s", modified.Format(http.TimeFormat), got)
+			}
+			if got := res.Header().Get("Cache-Control"); got != "private, no-cache" {
+				t.Fatalf("expected Cache-Control private, no-cache, got %s", got)
+			}
+			if test.status == http.StatusNotModified && res.Body.Len() != 0 {
+				t.Fatalf("expected no body, got %s", res.Body.String())
+			}
+		})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNotesNotModified(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	modified := time.Date(2022, 10, 16, 9, 45, 3, 597524000, time.UTC)
+	etag := notesETag(model.NotesVersion{Count: 2, Modified: modified})
+
+	// The notes themselves are never read: the version is enough to know the client's copy is current
+	expectNotesVersion(mock, 2, modified).WithArgs(id)
+
+	req, err := http.NewRequest("GET", "/1/my/notes.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	req.Header.Add("If-None-Match", etag)
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotModified {
+		t.Fatalf("expected status %d, got %d", http.StatusNotModified, res.Code)
+	}
+	if got := res.Header().Get("ETag"); got != etag {
+		t.Fatalf("expected ETag %s, got %s", etag, got)
+	}
+
+	// The count changing is enough for the list to be sent again, whatever the latest change
+	expectNotesVersion(mock, 1, modified).WithArgs(id)
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE owner = (.+) ORDER BY created, id LIMIT (.+)$").
+		WithArgs(id, model.DefaultPageSize+1).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified"}).
+			AddRow("xyz789", id, "Note content", modified, modified))
+
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	expectedETag := notesETag(model.NotesVersion{Count: 1, Modified: modified})
+	if got := res.Header().Get("ETag"); got != expectedETag {
+		t.Fatalf("expected ETag %s, got %s", expectedETag, got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"google.golang.org/grpc/connectivity"
+)
+
+// The service has two health endpoints, for whatever is running it (docker-compose, Kubernetes...):
+//
+//	/healthz  the service is alive. If this fails, it should be restarted.
+//	/readyz   the service can handle requests: the database answers a ping and the connection to the
+//	          auth service is up. If this fails, requests shouldn't be sent to it until it passes.
+//
+// Both say what they found, but nothing more, as they don't need auth:
+//
+//	HTTP/1.1 503 Service Unavailable
+//
+//	{"status":"unavailable","checks":{"auth":"ok","database":"unavailable"}}
+
+// How long the database has to answer a ping
+const readinessTimeout = 2 * time.Second
+
+const (
+	healthOk          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// HTTP handler for liveness: if we can answer, we're alive
+func (as *Service) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, r, healthResponse{Status: healthOk})
+}
+
+// HTTP handler for readiness, which checks what the service depends on
+func (as *Service) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	res := healthResponse{
+		Status: healthOk,
+		Checks: map[string]string{
+			"database": as.checkDatabase(ctx),
+			"auth":     as.checkAuth(),
+		},
+	}
+	for _, check := range res.Checks {
+		if check != healthOk {
+			res.Status = healthUnavailable
+		}
+	}
+	writeHealth(w, r, res)
+}
+
+func (as *Service) checkDatabase(ctx context.Context) string {
+	// Run sets up the pool, so until it has there's no database
+	if as.pool == nil {
+		return healthUnavailable
+	}
+	if err := as.pool.Ping(ctx); err != nil {
+		log.Printf("api: readiness: database ping failed: %v\n", err)
+		return healthUnavailable
+	}
+	return healthOk
+}
+
+func (as *Service) checkAuth() string {
+	if as.authClient == nil {
+		return healthUnavailable
+	}
+	switch state := as.authClient.State(); state {
+	case connectivity.Ready:
+		return healthOk
+	case connectivity.Idle:
+		// An idle connection reconnects as soon as it's used
+		return healthOk
+	default:
+		log.Printf("api: readiness: auth connection %v\n", state)
+		return healthUnavailable
+	}
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, res healthResponse) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeProblem(w, r, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	body, err := util.JSON.Encode(res)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if res.Status != healthOk {
+		status = http.StatusServiceUnavailable
+	}
+	// Checkers want the answer now, not one from earlier
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", util.JSON.ContentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
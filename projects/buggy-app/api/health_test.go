@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/pashagolub/pgxmock/v2"
+	"google.golang.org/grpc/connectivity"
+)
+
+// stateClient is a mock auth client with a connection in a given state
+type stateClient struct {
+	*auth.MockClient
+	state connectivity.State
+}
+
+func (c *stateClient) State() connectivity.State { return c.state }
+
+func TestHealthz(t *testing.T) {
+	// Alive even with nothing to depend on
+	as := New(defaultConfig)
+
+	res := httptest.NewRecorder()
+	as.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/healthz", nil))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	assertJSON(res.Body.Bytes(), healthResponse{Status: "ok"}, t)
+}
+
+func TestReadyz(t *testing.T) {
+	pingErr := errors.New("dial tcp 10.0.0.5:5432: connection refused")
+
+	tests := map[string]struct {
+		noPool    bool
+		pingErr   error
+		authState connectivity.State
+		status    int
+		checks    map[string]string
+	}{
+		"ready": {
+			authState: connectivity.Ready,
+			status:    http.StatusOK,
+			checks:    map[string]string{"database": "ok", "auth": "ok"},
+		},
+		"auth idle": {
+			authState: connectivity.Idle,
+			status:    http.StatusOK,
+			checks:    map[string]string{"database": "ok", "auth": "ok"},
+		},
+		"database down": {
+			pingErr:   pingErr,
+			authState: connectivity.Ready,
+			status:    http.StatusServiceUnavailable,
+			checks:    map[string]string{"database": "unavailable", "auth": "ok"},
+		},
+		"auth down": {
+			authState: connectivity.TransientFailure,
+			status:    http.StatusServiceUnavailable,
+			checks:    map[string]string{"database": "ok", "auth": "unavailable"},
+		},
+		"not running": {
+			noPool:    true,
+			authState: connectivity.Ready,
+			status:    http.StatusServiceUnavailable,
+			checks:    map[string]string{"database": "unavailable", "auth": "ok"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			as := New(defaultConfig)
+			mock, err := pgxmock.NewPool(pgxmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer mock.Close()
+			if !test.noPool {
+				as.pool = mock
+				mock.ExpectPing().WillReturnError(test.pingErr)
+			}
+			as.authClient = &stateClient{
+				MockClient: auth.NewMockClient(&auth.VerifyResult{State: auth.StateAllow}),
+				state:      test.authState,
+			}
+
+			res := httptest.NewRecorder()
+			as.Handler().ServeHTTP(res, httptest.NewRequest("GET", "/readyz", nil))
+
+			if res.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, res.Code)
+			}
+			if cacheControl := res.Header().Get("Cache-Control"); cacheControl != "no-store" {
+				t.Fatalf("expected Cache-Control no-store, got %s", cacheControl)
+			}
+			var body healthResponse
+			if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(body.Checks, test.checks) {
+				t.Fatalf("expected checks %v, got %v", test.checks, body.Checks)
+			}
+			// Nothing about what went wrong gets out
+			if strings.Contains(res.Body.String(), "refused") {
+				t.Fatalf("expected no error details, got %s", res.Body.String())
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are served at /metrics in the Prometheus text format
+// (https://prometheus.io/docs/instrumenting/exposition_formats/). As well as the usual Go and
+// process metrics there are:
+//
+//	api_http_requests_total{route, method, status}            requests handled
+//	api_http_request_duration_seconds{route, method, status}  how long they took
+//	api_db_pool_*                                              the database connection pool
+//	grpc_client_*, auth_client_*                               calls to the auth service
+//
+// The route is the pattern the request matched, like /1/my/note/, so there are only as many as
+// there are routes.
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "api_http_requests_total",
+			Help: "HTTP requests handled, by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "api_http_request_duration_seconds",
+			Help:    "How long HTTP requests took to handle, by route, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// wrapMetrics takes a handler function and wraps it so that the requests it handles are counted
+// and timed, labelled with the route.
+func (as *Service) wrapMetrics(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		handler(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			// Nothing was written, which net/http sends as an empty 200
+			status = http.StatusOK
+		}
+		labels := prometheus.Labels{"route": route, "method": methodLabel(r.Method), "status": strconv.Itoa(status)}
+		as.metrics.requests.With(labels).Inc()
+		as.metrics.duration.With(labels).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Clients can send any method, so anything we don't know is counted together
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// HTTP handler for the metrics. They aren't secret, so there is no auth; in production, /metrics
+// shouldn't be reachable from outside.
+func (as *Service) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(as.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
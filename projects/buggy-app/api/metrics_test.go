@@ -0,0 +1,96 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestMetrics(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	mock.ExpectQuery("SELECT t.tag, count(.+) FROM public.note_tag t (.+) WHERE n.owner = (.+) GROUP BY t.tag").
+		WithArgs(id).
+		WillReturnRows(mock.NewRows([]string{"tag", "count"}))
+
+	handler := as.Handler()
+	requests := []struct {
+		method, path string
+	}{
+		{"GET", "/1/my/tags.json"},
+		{"DELETE", "/1/my/tags.json"},
+		{"GET", "/1/nothing/here.json"},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, r.path, strings.NewReader(""))
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Fatalf("expected the text format, got %s", contentType)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Routes are labelled with their pattern, so unknown paths are all counted as "/"
+	expected := []string{
+		`api_http_requests_total{method="GET",route="/1/my/tags.json",status="200"} 1`,
+		`api_http_requests_total{method="DELETE",route="/1/my/tags.json",status="405"} 1`,
+		`api_http_requests_total{method="GET",route="/",status="404"} 1`,
+		`api_http_request_duration_seconds_count{method="GET",route="/1/my/tags.json",status="200"} 1`,
+		`# TYPE api_http_request_duration_seconds histogram`,
+		`# TYPE go_goroutines gauge`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected metrics to contain %q", line)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMethodLabel(t *testing.T) {
+	if label := methodLabel("GET"); label != "GET" {
+		t.Fatalf("expected GET, got %s", label)
+	}
+	if label := methodLabel("BREW"); label != "other" {
+		t.Fatalf("expected other, got %s", label)
+	}
+}
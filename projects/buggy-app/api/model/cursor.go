@@ -0,0 +1,47 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursors mark a position in a list of notes ordered by (created, id). To callers they are opaque
+// strings: the encoding is an implementation detail and may change, so clients should only ever pass
+// back a cursor they were given.
+//
+// Inside, a cursor is the created timestamp (in microseconds, the precision Postgres stores) and ID
+// of the last note on a page, base64-encoded so it's URL-safe:
+//
+//	base64("1665913503597524:JBmytGF3")
+
+type cursor struct {
+	created time.Time
+	id      string
+}
+
+func encodeCursor(c cursor) string {
+	raw := fmt.Sprintf("%d:%s", c.created.UnixMicro(), c.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	micros, id, found := strings.Cut(string(raw), ":")
+	if !found || id == "" {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	c.created = time.UnixMicro(n).UTC()
+	c.id = id
+	return c, nil
+}
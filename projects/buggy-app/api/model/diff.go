@@ -0,0 +1,230 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A line-based diff of two texts, written in the unified format used by `diff -u` and git.
+//
+// The edit script comes from Myers' algorithm ("An O(ND) Difference Algorithm and Its Variations"),
+// which finds the shortest one. Its cost grows with the number of differences, which is small for
+// the edits people make to notes, and common leading and trailing lines are trimmed before it runs.
+// Its memory grows with the square of the number of differences, though, so past maxDiffEdits it
+// gives up, and the changed lines are all replaced at once.
+
+// How many unchanged lines to show around each change
+const diffContext = 3
+
+// The most edits myers looks for. The rounds it keeps for the trace back take about
+// maxDiffEdits² ints, which is 8 MB at 1000.
+const maxDiffEdits = 1000
+
+type diffOp int
+
+const (
+	diffEqual diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffLine struct {
+	op   diffOp
+	text string
+}
+
+// Split text into lines, keeping each line's "\n" so that a missing newline at the end counts as a change
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func diffLines(a, b []string) []diffLine {
+	// Lines at the start and end that haven't changed don't need the full algorithm
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{diffEqual, text})
+	}
+	lines = append(lines, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{diffEqual, text})
+	}
+	return lines
+}
+
+// Find the shortest edit script turning a into b. v[k] holds the furthest x reached on diagonal k
+// (where k = x - y); the v from the start of each round is kept so the path can be traced back.
+// If it needs more than maxDiffEdits edits, every line of a is deleted and every line of b inserted.
+func myers(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			return replaceLines(a, b)
+		}
+		// Only diagonals -d..d can have been reached so far
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return nil
+}
+
+// The edit script that deletes all of a, then inserts all of b
+func replaceLines(a, b []string) []diffLine {
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a {
+		lines = append(lines, diffLine{diffDelete, text})
+	}
+	for _, text := range b {
+		lines = append(lines, diffLine{diffInsert, text})
+	}
+	return lines
+}
+
+func backtrack(trace [][]int, a, b []string) []diffLine {
+	var reversed []diffLine
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		// v covers diagonals -d..d
+		at := func(k int) int { return v[k+d] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, diffLine{diffEqual, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			reversed = append(reversed, diffLine{diffInsert, b[y-1]})
+		} else {
+			reversed = append(reversed, diffLine{diffDelete, a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, diffLine{diffEqual, a[x-1]})
+		x--
+		y--
+	}
+
+	lines := make([]diffLine, len(reversed))
+	for i, line := range reversed {
+		lines[len(reversed)-1-i] = line
+	}
+	return lines
+}
+
+// Write a unified diff turning a into b. Identical texts give an empty diff.
+func unifiedDiff(fromName, toName, a, b string) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	// The number of lines of a and b before each line of the diff
+	aPos := make([]int, len(lines)+1)
+	bPos := make([]int, len(lines)+1)
+	for i, line := range lines {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if line.op != diffInsert {
+			aPos[i+1]++
+		}
+		if line.op != diffDelete {
+			bPos[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].op == diffEqual {
+			i++
+			continue
+		}
+
+		// A hunk runs from a few lines before this change to a few lines after the last change that
+		// is close enough to share context with it
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(lines) && j <= end+2*diffContext+1; j++ {
+			if lines[j].op != diffEqual {
+				end = j
+			}
+		}
+		end += diffContext + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aPos[start], aPos[end]-aPos[start]),
+			hunkRange(bPos[start], bPos[end]-bPos[start]),
+		)
+		for _, line := range lines[start:end] {
+			out.WriteString([]string{" ", "-", "+"}[line.op])
+			out.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// The line range of a hunk, which starts after the given number of lines. An empty range is
+// written as the line before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	default:
+		return fmt.Sprintf("%d,%d", before+1, count)
+	}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := map[string]struct {
+		a, b     string
+		expected string
+	}{
+		"identical": {
+			a:        "one\ntwo\n",
+			b:        "one\ntwo\n",
+			expected: "",
+		},
+		"changed line": {
+			a: "one\ntwo\nthree\n",
+			b: "one\n2\nthree\n",
+			expected: "--- a\n+++ b\n" +
+				"@@ -1,3 +1,3 @@\n" +
+				" one\n-two\n+2\n three\n",
+		},
+		"from empty": {
+			a: "",
+			b: "one\n",
+			expected: "--- a\n+++ b\n" +
+				"@@ -0,0 +1 @@\n" +
+				"+one\n",
+		},
+		"no newline at end": {
+			a: "one\ntwo",
+			b: "one\ntwo\nthree",
+			expected: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,3 @@\n" +
+				" one\n-two\n\\ No newline at end of file\n+two\n+three\n\\ No newline at end of file\n",
+		},
+		"separate hunks": {
+			a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b: "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			expected: "--- a\n+++ b\n" +
+				"@@ -1,4 +1,4 @@\n" +
+				"-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n" +
+				" 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		"close changes share a hunk": {
+			a: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b: "one\n2\n3\n4\n5\n6\n7\neight\n",
+			expected: "--- a\n+++ b\n" +
+				"@@ -1,8 +1,8 @@\n" +
+				"-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			diff := unifiedDiff("a", "b", test.a, test.b)
+			if diff != test.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", test.expected, diff)
+			}
+		})
+	}
+}
+
+func TestDiffLinesRoundTrip(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+
+	var fromA, fromB []string
+	edits := 0
+	for _, line := range diffLines(a, b) {
+		if line.op != diffInsert {
+			fromA = append(fromA, line.text)
+		}
+		if line.op != diffDelete {
+			fromB = append(fromB, line.text)
+		}
+		if line.op != diffEqual {
+			edits++
+		}
+	}
+
+	if strings.Join(fromA, " ") != strings.Join(a, " ") || strings.Join(fromB, " ") != strings.Join(b, " ") {
+		t.Fatalf("diff does not reproduce its inputs: %v, %v", fromA, fromB)
+	}
+	// The example from Myers' paper, whose shortest edit script has 5 edits
+	if edits != 5 {
+		t.Fatalf("expected 5 edits, got %d", edits)
+	}
+}
+
+func TestUnifiedDiffTooManyEdits(t *testing.T) {
+	// The biggest notes, with nothing in common, would need tens of GB to diff line by line, so
+	// they're replaced whole
+	a := strings.Repeat("a\n", MaxContentLength/2)
+	b := strings.Repeat("b\n", MaxContentLength/2)
+	lines := MaxContentLength / 2
+
+	diff := unifiedDiff("a", "b", a, b)
+	header := fmt.Sprintf("--- a\n+++ b\n@@ -1,%d +1,%d @@\n", lines, lines)
+	expected := header + strings.Repeat("-a\n", lines) + strings.Repeat("+b\n", lines)
+	if diff != expected {
+		t.Fatalf("expected one hunk replacing all %d lines, got %d bytes starting:\n%.200s", lines, len(diff), diff)
+	}
+
+	// Just past the limit, unchanged lines in the middle are replaced too
+	a = strings.Repeat("a\n", maxDiffEdits/2+1) + "same\n" + strings.Repeat("a\n", maxDiffEdits/2+1)
+	b = strings.Repeat("b\n", maxDiffEdits/2+1) + "same\n" + strings.Repeat("b\n", maxDiffEdits/2+1)
+	for _, line := range diffLines(splitLines(a), splitLines(b)) {
+		if line.op == diffEqual {
+			t.Fatalf("expected every line to be replaced, got %q unchanged", line.text)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package model
+
+import "errors"
+
+// Kind says what sort of thing went wrong, so that the caller can tell its own client: for the
+// API, it decides the HTTP status.
+type Kind int
+
+const (
+	// KindInternal is for errors that aren't the caller's fault, like the database being down.
+	// Any error that isn't an *Error is internal.
+	KindInternal Kind = iota
+	// KindNotFound is for something that doesn't exist, or that the caller isn't allowed to know exists
+	KindNotFound
+	// KindForbidden is for something the caller can see, but can't do what they asked with
+	KindForbidden
+	// KindValidation is for input that can't be used
+	KindValidation
+	// KindConflict is for a request that clashes with the current state of something
+	KindConflict
+)
+
+// Error is an error the model returns when the caller has asked for something it can't have. Each
+// one is a variable, like ErrNotFound, so use errors.Is to check for a particular error. They are
+// often wrapped with details of what was wrong with the input, which are safe to show to the user.
+type Error struct {
+	Kind    Kind
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "model: " + e.Message
+}
+
+// Get the Kind of the model Error in err's chain, or KindInternal if there isn't one
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
@@ -0,0 +1,226 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Extract tags from the note. A tag is a # at the start of a word, followed by letters, digits, _ or -:
+//
+//	Buy milk #shopping today       → shopping
+//	#Work/Project-X review         → work/project-x
+//	Café #réunion #Réunion         → réunion
+//
+// Tags can be hierarchical, with levels separated by /. They must contain at least one letter, so
+// "issue #42" isn't a tag. Tags are case-folded and de-duplicated, keeping the order in which they first
+// appear.
+//
+// Notes are often Markdown, so # in headings ("## Monday"), code spans and fenced code blocks, and URLs
+// (https://example.com/page#section) are not tags.
+func extractTags(input string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	inFence, fence := false, ""
+
+	for _, line := range strings.Split(input, "\n") {
+		// Fenced code blocks run from ``` (or ~~~) to a matching fence
+		if marker, ok := codeFence(line); ok {
+			if !inFence {
+				inFence, fence = true, marker
+			} else if strings.HasPrefix(marker, fence) {
+				inFence = false
+			}
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		for _, tag := range lineTags(line) {
+			tag = foldTag(tag)
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// Tags longer than this (in runes) are ignored
+const maxTagLength = 100
+
+// Find the tags in one line of a note, as written
+func lineTags(line string) []string {
+	var tags []string
+
+	// "# Heading": the #s are Markdown, not a tag, but the heading text can contain tags
+	i := skipHeading(line)
+
+	prev := ' '
+	for i < len(line) {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		atBoundary := !isWordRune(prev) && prev != '#' && prev != '&'
+
+		switch {
+		case r == '`':
+			// Code spans are skipped whole: `#not-a-tag`
+			i = skipCodeSpan(line, i)
+			prev = '`'
+			continue
+
+		case atBoundary && isURLStart(line[i:]):
+			// URLs run up to the next space
+			end := strings.IndexFunc(line[i:], unicode.IsSpace)
+			if end == -1 {
+				end = len(line) - i
+			}
+			i += end
+			prev = ' '
+			continue
+
+		case r == '#' && atBoundary:
+			tag := scanTag(line[i+size:])
+			if isTag(tag) {
+				tags = append(tags, tag)
+			}
+			i += size + len(tag)
+			prev = '#'
+			if tag != "" {
+				prev, _ = utf8.DecodeLastRuneInString(tag)
+			}
+			continue
+		}
+
+		i += size
+		prev = r
+	}
+	return tags
+}
+
+// Read a tag from the start of s (just after the #)
+func scanTag(s string) string {
+	end := 0
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if !isTagRune(r) {
+			break
+		}
+		// A / only continues the tag if there's another level after it: "#work/" is "work"
+		if r == '/' {
+			next, _ := utf8.DecodeRuneInString(s[end+size:])
+			if !isTagRune(next) || next == '/' || next == '-' {
+				break
+			}
+		}
+		end += size
+	}
+	// Trailing hyphens are punctuation: "#todo-" is "todo"
+	return strings.TrimRight(s[:end], "-")
+}
+
+func isTag(tag string) bool {
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		return false
+	}
+	return strings.IndexFunc(tag, unicode.IsLetter) != -1
+}
+
+// Tags are compared in Unicode normal form and case-folded, so #Café, #CAFÉ and #café are the same tag
+func foldTag(tag string) string {
+	return cases.Fold().String(norm.NFC.String(tag))
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.M, r)
+}
+
+func isTagRune(r rune) bool {
+	return isWordRune(r) || r == '-' || r == '/'
+}
+
+// Does s start with something like https://, mailto: or www.?
+func isURLStart(s string) bool {
+	if strings.HasPrefix(strings.ToLower(s), "www.") {
+		return true
+	}
+	// Schemes are short, so there's no need to look further than this for the colon
+	const maxScheme = 32
+	head := s
+	if len(head) > maxScheme+1 {
+		head = head[:maxScheme+1]
+	}
+	end := strings.IndexByte(head, ':')
+	if end < 1 {
+		return false
+	}
+	scheme, rest := s[:end], s[end+1:]
+	for i, r := range scheme {
+		isAlpha := ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+		if !isAlpha && (i == 0 || !(('0' <= r && r <= '9') || r == '+' || r == '.' || r == '-')) {
+			return false
+		}
+	}
+	return strings.HasPrefix(rest, "//") || strings.EqualFold(scheme, "mailto")
+}
+
+// If the line is a Markdown heading, return the index just after the leading #s
+func skipHeading(line string) int {
+	trimmed := strings.TrimLeft(line, " ")
+	indent := len(line) - len(trimmed)
+	if indent > 3 {
+		return 0
+	}
+	hashes := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+	if hashes == 0 || hashes > 6 {
+		return 0
+	}
+	rest := trimmed[hashes:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0
+	}
+	return indent + hashes
+}
+
+// Skip a code span starting at line[i], returning the index just after it. A span opened by N
+// backticks is closed by the next run of exactly N; if there isn't one, the backticks are literal.
+func skipCodeSpan(line string, i int) int {
+	n := 0
+	for i+n < len(line) && line[i+n] == '`' {
+		n++
+	}
+	for j := i + n; j < len(line); {
+		if line[j] != '`' {
+			j++
+			continue
+		}
+		m := 0
+		for j+m < len(line) && line[j+m] == '`' {
+			m++
+		}
+		if m == n {
+			return j + m
+		}
+		j += m
+	}
+	return i + n
+}
+
+// If the line opens or closes a fenced code block, return its fence (``` or ~~~, possibly longer)
+func codeFence(line string) (string, bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return "", false
+	}
+	for _, c := range []string{"`", "~"} {
+		n := len(trimmed) - len(strings.TrimLeft(trimmed, c))
+		if n >= 3 {
+			return strings.Repeat(c, n), true
+		}
+	}
+	return "", false
+}
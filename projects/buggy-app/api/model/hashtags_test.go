@@ -0,0 +1,74 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{name: "no tags", input: "Just a note", expected: []string{}},
+		{name: "one tag", input: "Buy milk #shopping", expected: []string{"shopping"}},
+		{name: "tag ends at a space", input: "Buy milk #shopping today", expected: []string{"shopping"}},
+		{name: "tag at start", input: "#Monday Remember to take time for self-care", expected: []string{"monday"}},
+		{name: "several tags", input: "#one #two, #three.", expected: []string{"one", "two", "three"}},
+		{name: "adjacent tags", input: "#one#two", expected: []string{"one"}},
+		{name: "punctuation ends a tag", input: "(see #todo) #done! #why? #end;", expected: []string{"todo", "done", "why", "end"}},
+		{name: "underscore and hyphen", input: "#snake_case #kebab-case", expected: []string{"snake_case", "kebab-case"}},
+		{name: "trailing hyphen", input: "#todo- next", expected: []string{"todo"}},
+		{name: "digits", input: "#2023goals #q4", expected: []string{"2023goals", "q4"}},
+		{name: "numbers are not tags", input: "Fixed issue #42 and #1", expected: []string{}},
+		{name: "not at a word boundary", input: "C#sharp email@host#frag a_#b", expected: []string{}},
+		{name: "html entity", input: "it&#39;s &#x41;", expected: []string{}},
+		{name: "empty tag", input: "# #  ##", expected: []string{}},
+
+		{name: "case folded", input: "#Work #WORK #work", expected: []string{"work"}},
+		{name: "unicode letters", input: "#réunion #日本語 #Ελλάδα", expected: []string{"réunion", "日本語", "ελλάδα"}},
+		{name: "unicode folding", input: "#Straße #STRASSE", expected: []string{"strasse"}},
+		{name: "combining marks normalized", input: "#café #café", expected: []string{"café"}},
+
+		{name: "hierarchical", input: "#work/project #Work/Project/Q1", expected: []string{"work/project", "work/project/q1"}},
+		{name: "trailing slash", input: "#work/ and #home//garden", expected: []string{"work", "home"}},
+
+		{name: "url fragment", input: "See https://example.com/page#section", expected: []string{}},
+		{name: "url then tag", input: "https://example.com/#top #reading", expected: []string{"reading"}},
+		{name: "markdown link", input: "[docs](http://example.com/#install) #docs", expected: []string{"docs"}},
+		{name: "www url", input: "www.example.com/#x", expected: []string{}},
+
+		{name: "heading", input: "# Monday\nNothing", expected: []string{}},
+		{name: "heading with tag", input: "## Plans #work", expected: []string{"work"}},
+		{name: "heading needs a space", input: "#heading", expected: []string{"heading"}},
+
+		{name: "code span", input: "Use `#define` for #c", expected: []string{"c"}},
+		{name: "double backtick code span", input: "``a ` #no`` #yes", expected: []string{"yes"}},
+		{name: "unclosed code span", input: "a ` #yes", expected: []string{"yes"}},
+		{name: "fenced code", input: "#before\n```sh\n# comment #not\n```\n#after", expected: []string{"before", "after"}},
+		{name: "tilde fence", input: "~~~\n#not\n~~~\n#yes", expected: []string{"yes"}},
+		{name: "unclosed fence", input: "```\n#not", expected: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tags := extractTags(test.input)
+			if !reflect.DeepEqual(test.expected, tags) {
+				t.Fatalf("%q: expected %q, got %q", test.input, test.expected, tags)
+			}
+		})
+	}
+}
+
+func TestExtractTagsTooLong(t *testing.T) {
+	input := "#" + strings.Repeat("a", maxTagLength+1) + " #ok"
+	expected := []string{"ok"}
+
+	tags := extractTags(input)
+
+	if !reflect.DeepEqual(expected, tags) {
+		t.Fatalf("expected %v, got %v", expected, tags)
+	}
+}
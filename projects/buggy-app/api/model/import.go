@@ -0,0 +1,105 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A note to be imported, from an export of this or another environment. The note always gets a new
+// ID, and is modified as of the import: what it was exported with would break ETags and
+// If-Modified-Since, which expect a note's modified time to only ever go up.
+type ImportNote struct {
+	// Where the note came from, like a file name or line number, for the report
+	Source   string
+	SourceId string
+	Content  string
+	// When the note was created where it came from, which is only kept if ImportOptions say so
+	Created time.Time
+	// Set if the note couldn't be read. It fails the import like an invalid note does.
+	Err error
+}
+
+// What happened to one imported note. Id is the new note's ID, and Error is set instead if it
+// couldn't be imported.
+type ImportResult struct {
+	Source   string `json:"source"`
+	SourceId string `json:"source_id,omitempty"`
+	Id       string `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Options for importing notes. The zero value gives every note the time of the import.
+type ImportOptions struct {
+	// Keep the Created time notes were exported with, so they keep their place in listings, which
+	// are oldest first. A time in the future is taken to be now.
+	KeepCreated bool
+}
+
+// ErrInvalidImport is returned when any of the notes in an import can't be imported
+var ErrInvalidImport = &Error{KindValidation, "invalid import"}
+
+// Import notes for the owner. The import is all or nothing: every note is checked before anything
+// is written, and then they are all created in one transaction. The results say what happened to
+// each note, in order. If any are invalid, ErrInvalidImport is returned along with the results.
+func ImportNotes(ctx context.Context, conn dbConn, owner string, notes []ImportNote, opts ImportOptions) ([]ImportResult, error) {
+	if owner == "" {
+		return nil, errors.New("model: owner not supplied")
+	}
+
+	results := make([]ImportResult, len(notes))
+	invalid := false
+	for i, note := range notes {
+		results[i] = ImportResult{Source: note.Source, SourceId: note.SourceId}
+		err := note.Err
+		if err == nil {
+			err = validateContent(note.Content)
+		}
+		if err != nil {
+			results[i].Error = err.Error()
+			invalid = true
+		}
+	}
+	if invalid {
+		return results, ErrInvalidImport
+	}
+
+	now := time.Now().UTC()
+	err := withTx(ctx, conn, func(tx pgx.Tx) error {
+		for i, note := range notes {
+			// Timestamps that aren't kept are left to the database defaults. The columns have no
+			// time zone, and hold UTC.
+			var created *time.Time
+			if opts.KeepCreated && !note.Created.IsZero() {
+				t := note.Created.UTC()
+				if t.After(now) {
+					t = now
+				}
+				created = &t
+			}
+
+			var id string
+			err := tx.QueryRow(ctx,
+				`INSERT INTO public.note (owner, content, created)
+				VALUES ($1, $2, COALESCE($3::timestamp, current_timestamp))
+				RETURNING id`,
+				owner, note.Content, created,
+			).Scan(&id)
+			if err != nil {
+				return fmt.Errorf("model: could not insert note %s: %w", note.Source, err)
+			}
+			if err := insertTags(ctx, tx, id, extractTags(note.Content)); err != nil {
+				return err
+			}
+			results[i].Id = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
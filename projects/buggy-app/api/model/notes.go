@@ -4,37 +4,107 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type Note struct {
-	Id       string    `json:"id"`
-	Owner    string    `json:"owner"`
-	Content  string    `json:"content"`
-	Created  time.Time `json:"created"`
-	Modified time.Time `json:"modified"`
-	Tags     []string  `json:"tags"`
+	Id       string     `json:"id"`
+	Owner    string     `json:"owner"`
+	Content  string     `json:"content"`
+	Created  time.Time  `json:"created"`
+	Modified time.Time  `json:"modified"`
+	Tags     []string   `json:"tags"`
+	Deleted  *time.Time `json:"deleted,omitempty"`
 }
 
 type Notes []Note
 
+// Notes larger than this are rejected when created.
+const MaxContentLength = 64 * 1024
+
+var (
+	// ErrInvalidNote is returned (wrapped) when a note fails validation. Use errors.Is to check for it.
+	ErrInvalidNote = &Error{KindValidation, "invalid note"}
+	// ErrNotFound is returned when a note does not exist, or is not visible to the caller.
+	ErrNotFound = &Error{KindNotFound, "note not found"}
+	// ErrForbidden is returned when the caller can see a note, but can't do what they asked with it.
+	ErrForbidden = &Error{KindForbidden, "forbidden"}
+	// ErrModified is returned when a conditional write finds the note has changed since it was read.
+	ErrModified = &Error{KindConflict, "note has been modified"}
+	// ErrInvalidListOptions is returned (wrapped) when a limit or cursor can't be used.
+	ErrInvalidListOptions = &Error{KindValidation, "invalid list options"}
+)
+
 type dbConn interface {
 	Query(ctx context.Context, sql string, optionsAndArgs ...interface{}) (pgx.Rows, error)
 	QueryRow(context.Context, string, ...interface{}) pgx.Row
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
+	Begin(context.Context) (pgx.Tx, error)
+}
+
+// Run fn in a transaction, committing if it returns nil and rolling back if not
+func withTx(ctx context.Context, conn dbConn, fn func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("model: could not begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("model: could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Options for listing notes. The zero value gets the first page, of DefaultPageSize notes.
+type ListOptions struct {
+	// Maximum number of notes to return, up to MaxPageSize
+	Limit int
+	// Cursor is the NextCursor returned with a previous page, or empty for the first page
+	Cursor string
+	// Only list notes with these tags: any of them, or all of them if MatchAllTags is set
+	Tags         []string
+	MatchAllTags bool
 }
 
-func GetNotesForOwner(ctx context.Context, conn dbConn, owner string) (Notes, error) {
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// Get a page of the owner's notes, oldest first. If there are more notes to come, the returned cursor
+// can be passed back in ListOptions to get the next page; it's empty when this was the last page.
+func GetNotesForOwner(ctx context.Context, conn dbConn, owner string, opts ListOptions) (Notes, string, error) {
 	if owner == "" {
-		return nil, errors.New("model: owner not supplied")
+		return nil, "", errors.New("model: owner not supplied")
+	}
+
+	limit, err := opts.pageSize()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// We ask for one more note than we need: if it comes back, there's another page. The
+	// (owner, created) index means Postgres only reads the notes on this page.
+	conditions, args, err := listConditions(opts, []interface{}{owner, limit + 1})
+	if err != nil {
+		return nil, "", err
 	}
 
-	queryRows, err := conn.Query(ctx, "SELECT id, owner, content, created, modified FROM public.note")
+	query := "SELECT id, owner, content, created, modified FROM public.note WHERE " +
+		strings.Join(conditions, " AND ") +
+		" ORDER BY created, id LIMIT $2"
+
+	queryRows, err := conn.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("model: could not query notes: %w", err)
+		return nil, "", fmt.Errorf("model: could not query notes: %w", err)
 	}
 	defer queryRows.Close()
 
@@ -43,12 +113,252 @@ func GetNotesForOwner(ctx context.Context, conn dbConn, owner string) (Notes, er
 		note := Note{}
 		err = queryRows.Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified)
 		if err != nil {
-			return nil, fmt.Errorf("model: query scan failed: %w", err)
+			return nil, "", fmt.Errorf("model: query scan failed: %w", err)
+		}
+		note.Tags = extractTags(note.Content)
+		notes = append(notes, note)
+	}
+
+	if queryRows.Err() != nil {
+		return nil, "", fmt.Errorf("model: query read failed: %w", queryRows.Err())
+	}
+
+	if len(notes) <= limit {
+		return notes, "", nil
+	}
+	notes = notes[:limit]
+	last := notes[len(notes)-1]
+	return notes, encodeCursor(cursor{created: last.Created, id: last.Id}), nil
+}
+
+// The number of notes on a page
+func (opts ListOptions) pageSize() (int, error) {
+	if opts.Limit == 0 {
+		return DefaultPageSize, nil
+	}
+	if opts.Limit < 0 || opts.Limit > MaxPageSize {
+		return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListOptions, MaxPageSize)
+	}
+	return opts.Limit, nil
+}
+
+// Build the conditions for listing the owner's notes. args must start with the owner, as $1: the
+// values the conditions need are appended to it.
+func listConditions(opts ListOptions, args []interface{}) ([]string, []interface{}, error) {
+	conditions := []string{"owner = $1", "deleted_at IS NULL"}
+	if opts.Cursor != "" {
+		after, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, after.created, after.id)
+		conditions = append(conditions, fmt.Sprintf("(created, id) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+	if tags := uniqueTags(queryTags(opts.Tags)); len(tags) > 0 {
+		args = append(args, tags)
+		if opts.MatchAllTags {
+			// Tags are unique per note, so a note has all of them if it matches as many as we asked for
+			args = append(args, len(tags))
+			conditions = append(conditions, fmt.Sprintf(
+				"id IN (SELECT note_id FROM public.note_tag WHERE tag = ANY($%d) GROUP BY note_id HAVING count(*) = $%d)",
+				len(args)-1, len(args),
+			))
+		} else {
+			conditions = append(conditions, fmt.Sprintf(
+				"id IN (SELECT note_id FROM public.note_tag WHERE tag = ANY($%d))",
+				len(args),
+			))
+		}
+	}
+	return conditions, args, nil
+}
+
+// A summary of a list of the owner's notes, for deciding whether a client's copy of the list is
+// still current without reading the notes themselves.
+//
+// Count is the number of notes in the whole list (not just one page). Modified is the latest time
+// any of the owner's notes changed, including ones in the trash or filtered out: every write to a
+// note moves its modified timestamp on, so a note being created, changed, trashed or restored
+// always changes it, even when the note joins or leaves the list. It's zero if the owner has no
+// notes.
+type NotesVersion struct {
+	Count    int
+	Modified time.Time
+}
+
+// Get the version of a list of the owner's notes. It takes the same options as GetNotesForOwner.
+func GetNotesVersionForOwner(ctx context.Context, conn dbConn, owner string, opts ListOptions) (NotesVersion, error) {
+	var version NotesVersion
+	if owner == "" {
+		return version, errors.New("model: owner not supplied")
+	}
+
+	if _, err := opts.pageSize(); err != nil {
+		return version, err
+	}
+	conditions, args, err := listConditions(opts, []interface{}{owner})
+	if err != nil {
+		return version, err
+	}
+
+	query := "SELECT count(*) FILTER (WHERE " + strings.Join(conditions, " AND ") + "), max(modified) " +
+		"FROM public.note WHERE owner = $1"
+
+	var modified *time.Time
+	err = conn.QueryRow(ctx, query, args...).Scan(&version.Count, &modified)
+	if err != nil {
+		return version, fmt.Errorf("model: query scan failed: %w", err)
+	}
+	if modified != nil {
+		version.Modified = *modified
+	}
+	return version, nil
+}
+
+// Get a note the user owns, or that has been shared with them
+func GetNoteById(ctx context.Context, conn dbConn, user, id string) (Note, error) {
+	if user == "" {
+		return Note{}, errors.New("model: user not supplied")
+	}
+	if id == "" {
+		return Note{}, errors.New("model: id not supplied")
+	}
+	return authorizeNote(ctx, conn, id, user, PermissionRead)
+}
+
+// Create a note for the owner. The database generates the ID and timestamps, which are returned
+// in the created Note.
+func CreateNote(ctx context.Context, conn dbConn, owner, content string) (Note, error) {
+	var note Note
+	if owner == "" {
+		return note, errors.New("model: owner not supplied")
+	}
+	if err := validateContent(content); err != nil {
+		return note, err
+	}
+
+	// The note and its tags are written together, so the tags can't get out of sync
+	err := withTx(ctx, conn, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx,
+			"INSERT INTO public.note (owner, content) VALUES ($1, $2) RETURNING id, owner, content, created, modified",
+			owner, content,
+		)
+		err := row.Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified)
+		if err != nil {
+			return fmt.Errorf("model: could not insert note: %w", err)
+		}
+		note.Tags = extractTags(note.Content)
+		return insertTags(ctx, tx, note.Id, note.Tags)
+	})
+	return note, err
+}
+
+// Update the content of a note. The user must own it, or have been given write access to it.
+//
+// If any ifModified timestamps are supplied the update is a compare-and-set: it only happens if the
+// note's current modified timestamp is one of them, otherwise ErrModified is returned. The database
+// trigger (note_update_modified) moves the modified timestamp on, so a client that read the note
+// before someone else wrote to it can't silently overwrite their change.
+func UpdateNote(ctx context.Context, conn dbConn, user, id, content string, ifModified ...time.Time) (Note, error) {
+	var note Note
+	if user == "" {
+		return note, errors.New("model: user not supplied")
+	}
+	if id == "" {
+		return note, errors.New("model: id not supplied")
+	}
+	if err := validateContent(content); err != nil {
+		return note, err
+	}
+
+	err := withTx(ctx, conn, func(tx pgx.Tx) error {
+		// The note stays locked until the transaction ends, so nobody can change it between
+		// this check and the update
+		current, err := authorizeNote(ctx, tx, id, user, PermissionWrite)
+		if err != nil {
+			return err
 		}
-		if note.Owner == owner {
-			note.Tags = extractTags(note.Content)
-			notes = append(notes, note)
+		if len(ifModified) > 0 && !containsTime(ifModified, current.Modified) {
+			return ErrModified
 		}
+
+		note, err = setNoteContent(ctx, tx, id, content)
+		return err
+	})
+	return note, err
+}
+
+// Write new content to a note that has already been authorized and locked. The database trigger
+// (note_record_revision) keeps the old content as a revision.
+func setNoteContent(ctx context.Context, tx pgx.Tx, id, content string) (Note, error) {
+	var note Note
+	err := tx.QueryRow(ctx,
+		"UPDATE public.note SET content = $1 WHERE id = $2 RETURNING id, owner, content, created, modified",
+		content, id,
+	).Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified)
+	if err != nil {
+		return note, fmt.Errorf("model: could not update note: %w", err)
+	}
+	note.Tags = extractTags(note.Content)
+	return note, replaceTags(ctx, tx, note.Id, note.Tags)
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	for _, candidate := range times {
+		if candidate.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Move one of the owner's notes to the trash. It disappears from GetNotesForOwner and GetNoteById, but
+// can be brought back with RestoreNote until PurgeTrash removes it for good. Only the owner of a note
+// can trash it.
+func TrashNote(ctx context.Context, conn dbConn, owner, id string) error {
+	if owner == "" {
+		return errors.New("model: owner not supplied")
+	}
+	if id == "" {
+		return errors.New("model: id not supplied")
+	}
+
+	return withTx(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := authorizeNote(ctx, tx, id, owner, permissionOwner); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "UPDATE public.note SET deleted_at = now() WHERE id = $1", id)
+		if err != nil {
+			return fmt.Errorf("model: could not trash note: %w", err)
+		}
+		return nil
+	})
+}
+
+// Get the notes the owner has moved to the trash, most recently trashed first
+func GetTrashForOwner(ctx context.Context, conn dbConn, owner string) (Notes, error) {
+	if owner == "" {
+		return nil, errors.New("model: owner not supplied")
+	}
+
+	queryRows, err := conn.Query(ctx,
+		"SELECT id, owner, content, created, modified, deleted_at FROM public.note WHERE owner = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC",
+		owner,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("model: could not query trash: %w", err)
+	}
+	defer queryRows.Close()
+
+	notes := []Note{}
+	for queryRows.Next() {
+		note := Note{}
+		err = queryRows.Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified, &note.Deleted)
+		if err != nil {
+			return nil, fmt.Errorf("model: query scan failed: %w", err)
+		}
+		note.Tags = extractTags(note.Content)
+		notes = append(notes, note)
 	}
 
 	if queryRows.Err() != nil {
@@ -58,30 +368,54 @@ func GetNotesForOwner(ctx context.Context, conn dbConn, owner string) (Notes, er
 	return notes, nil
 }
 
-func GetNoteById(ctx context.Context, conn dbConn, id string) (Note, error) {
+// Take one of the owner's notes back out of the trash
+func RestoreNote(ctx context.Context, conn dbConn, owner, id string) (Note, error) {
 	var note Note
+	if owner == "" {
+		return note, errors.New("model: owner not supplied")
+	}
 	if id == "" {
 		return note, errors.New("model: id not supplied")
 	}
 
-	row := conn.QueryRow(ctx, "SELECT id, owner, content, created, modified FROM public.note WHERE id = $1", id)
-
-	err := row.Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified)
+	err := conn.QueryRow(ctx,
+		"UPDATE public.note SET deleted_at = NULL WHERE id = $1 AND owner = $2 AND deleted_at IS NOT NULL RETURNING id, owner, content, created, modified",
+		id, owner,
+	).Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return note, ErrNotFound
+	}
 	if err != nil {
-		return note, fmt.Errorf("model: query scan failed: %w", err)
+		return note, fmt.Errorf("model: could not restore note: %w", err)
 	}
 	note.Tags = extractTags(note.Content)
 	return note, nil
 }
 
-// Extract tags from the note. We're looking for #something. There could be
-// multiple tags, so we FindAll.
-func extractTags(input string) []string {
-	re := regexp.MustCompile(`#([^#]+)`)
-	matches := re.FindAllStringSubmatch(input, -1)
-	tags := make([]string, 0, len(matches))
-	for _, f := range matches {
-		tags = append(tags, strings.TrimSpace(f[1]))
+// Permanently delete notes, for every owner, that have been in the trash for longer than retention.
+// It returns the number of notes deleted.
+func PurgeTrash(ctx context.Context, conn dbConn, retention time.Duration) (int64, error) {
+	// The cut-off is computed by Postgres so that it's in the same clock and time zone as deleted_at
+	tag, err := conn.Exec(ctx,
+		"DELETE FROM public.note WHERE deleted_at IS NOT NULL AND deleted_at < now() - make_interval(secs => $1)",
+		retention.Seconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("model: could not purge trash: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Check that content is something we're happy to store
+func validateContent(content string) error {
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("%w: content must not be empty", ErrInvalidNote)
+	}
+	if len(content) > MaxContentLength {
+		return fmt.Errorf("%w: content must be at most %d bytes", ErrInvalidNote, MaxContentLength)
+	}
+	if !utf8.ValidString(content) {
+		return fmt.Errorf("%w: content must be valid UTF-8", ErrInvalidNote)
 	}
-	return tags
+	return nil
 }
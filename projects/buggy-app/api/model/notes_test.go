@@ -1,8 +1,14 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
 )
 
 func TestTags(t *testing.T) {
@@ -26,3 +32,42 @@ func TestTagsTrim(t *testing.T) {
 		t.Fatalf("expected %v, got %v", expected, tags)
 	}
 }
+
+func TestCreateNoteValidation(t *testing.T) {
+	contents := map[string]string{
+		"empty":     "",
+		"blank":     " \n\t",
+		"too long":  strings.Repeat("a", MaxContentLength+1),
+		"bad utf-8": "\xff\xfe",
+	}
+
+	for name, content := range contents {
+		t.Run(name, func(t *testing.T) {
+			mock, err := pgxmock.NewConn()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer mock.Close(context.Background())
+
+			_, err = CreateNote(context.Background(), mock, "abc123", content)
+			if !errors.Is(err, ErrInvalidNote) {
+				t.Fatalf("expected ErrInvalidNote, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	expected := cursor{
+		created: time.Date(2022, 10, 16, 9, 45, 3, 597524000, time.UTC),
+		id:      "JBmytGF3",
+	}
+
+	actual, err := decodeCursor(encodeCursor(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
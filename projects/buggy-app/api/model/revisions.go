@@ -0,0 +1,169 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Whenever a note's content changes, the database trigger (note_record_revision) keeps the previous
+// content in the note_revision table. Revisions are numbered from 1 for each note, so the highest
+// numbered revision is the content the note had just before its current content.
+//
+// Anyone who can read a note can read its revisions. Restoring one needs write access.
+
+// A previous version of a note's content. Created is when that content was written.
+type Revision struct {
+	NoteId   string    `json:"note_id"`
+	Revision int       `json:"revision"`
+	Content  string    `json:"content"`
+	Created  time.Time `json:"created"`
+}
+
+// A unified diff between two versions of a note. A To of CurrentRevision means the note's current content.
+type RevisionDiff struct {
+	NoteId string `json:"note_id"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Diff   string `json:"diff"`
+}
+
+// Used in place of a revision number to mean the note's current content
+const CurrentRevision = 0
+
+// ErrInvalidRevision is returned (wrapped) when a revision number can't be used
+var ErrInvalidRevision = &Error{KindValidation, "invalid revision"}
+
+// Get the revisions of a note the user can read, newest first
+func GetRevisions(ctx context.Context, conn dbConn, user, id string) ([]Revision, error) {
+	if user == "" {
+		return nil, errors.New("model: user not supplied")
+	}
+	if id == "" {
+		return nil, errors.New("model: id not supplied")
+	}
+	if _, err := authorizeNote(ctx, conn, id, user, PermissionRead); err != nil {
+		return nil, err
+	}
+
+	queryRows, err := conn.Query(ctx,
+		"SELECT note_id, revision, content, created FROM public.note_revision WHERE note_id = $1 ORDER BY revision DESC",
+		id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("model: could not query revisions: %w", err)
+	}
+	defer queryRows.Close()
+
+	revisions := []Revision{}
+	for queryRows.Next() {
+		revision := Revision{}
+		err = queryRows.Scan(&revision.NoteId, &revision.Revision, &revision.Content, &revision.Created)
+		if err != nil {
+			return nil, fmt.Errorf("model: query scan failed: %w", err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if queryRows.Err() != nil {
+		return nil, fmt.Errorf("model: query read failed: %w", queryRows.Err())
+	}
+
+	return revisions, nil
+}
+
+// Get one revision of a note the user can read
+func GetRevision(ctx context.Context, conn dbConn, user, id string, revision int) (Revision, error) {
+	if user == "" {
+		return Revision{}, errors.New("model: user not supplied")
+	}
+	if id == "" {
+		return Revision{}, errors.New("model: id not supplied")
+	}
+	if _, err := authorizeNote(ctx, conn, id, user, PermissionRead); err != nil {
+		return Revision{}, err
+	}
+	return getRevision(ctx, conn, id, revision)
+}
+
+func getRevision(ctx context.Context, conn dbConn, id string, revision int) (Revision, error) {
+	var rev Revision
+	if revision < 1 {
+		return rev, fmt.Errorf("%w: revisions are numbered from 1", ErrInvalidRevision)
+	}
+
+	err := conn.QueryRow(ctx,
+		"SELECT note_id, revision, content, created FROM public.note_revision WHERE note_id = $1 AND revision = $2",
+		id, revision,
+	).Scan(&rev.NoteId, &rev.Revision, &rev.Content, &rev.Created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rev, ErrNotFound
+	}
+	if err != nil {
+		return rev, fmt.Errorf("model: query scan failed: %w", err)
+	}
+	return rev, nil
+}
+
+// Get a unified diff from one revision of a note the user can read to another, or to its
+// current content if to is CurrentRevision
+func DiffRevisions(ctx context.Context, conn dbConn, user, id string, from, to int) (RevisionDiff, error) {
+	if user == "" {
+		return RevisionDiff{}, errors.New("model: user not supplied")
+	}
+	if id == "" {
+		return RevisionDiff{}, errors.New("model: id not supplied")
+	}
+	note, err := authorizeNote(ctx, conn, id, user, PermissionRead)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+
+	fromRev, err := getRevision(ctx, conn, id, from)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	toName, toContent := "current", note.Content
+	if to != CurrentRevision {
+		toRev, err := getRevision(ctx, conn, id, to)
+		if err != nil {
+			return RevisionDiff{}, err
+		}
+		toName, toContent = fmt.Sprintf("revision %d", to), toRev.Content
+	}
+
+	return RevisionDiff{
+		NoteId: id,
+		From:   from,
+		To:     to,
+		Diff:   unifiedDiff(fmt.Sprintf("revision %d", from), toName, fromRev.Content, toContent),
+	}, nil
+}
+
+// Make an old revision of a note the user can write to its current content. Like any other update,
+// the content it replaces is kept as a new revision, so a restore can itself be undone.
+func RestoreRevision(ctx context.Context, conn dbConn, user, id string, revision int) (Note, error) {
+	var note Note
+	if user == "" {
+		return note, errors.New("model: user not supplied")
+	}
+	if id == "" {
+		return note, errors.New("model: id not supplied")
+	}
+
+	err := withTx(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := authorizeNote(ctx, tx, id, user, PermissionWrite); err != nil {
+			return err
+		}
+		rev, err := getRevision(ctx, tx, id, revision)
+		if err != nil {
+			return err
+		}
+		note, err = setNoteContent(ctx, tx, id, rev.Content)
+		return err
+	})
+	return note, err
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// A note that matched a search, with a highlighted snippet of the matching content and its rank
+// (higher is better).
+//
+// The snippet is HTML: the note content is escaped, and matching words are wrapped in <mark> tags.
+type SearchResult struct {
+	Note
+	Snippet string  `json:"snippet"`
+	Rank    float32 `json:"rank"`
+}
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// ErrInvalidSearch is returned (wrapped) when a search query or limit can't be used.
+var ErrInvalidSearch = &Error{KindValidation, "invalid search"}
+
+// ts_headline doesn't escape the content it highlights, so we ask it to mark matches with control
+// characters, escape the whole snippet and only then turn the markers into tags. The markers are
+// removed from the content first, so that any in a note can't be taken for ours.
+const (
+	headlineStart = "\x01"
+	headlineStop  = "\x02"
+)
+
+// Search the owner's notes, best match first. The query supports:
+//
+//	banana bread     notes containing both words
+//	"banana bread"   the phrase
+//	ban*             words starting with "ban"
+//	-banana          notes not containing the word
+//	banana OR apple  notes containing either word
+func SearchNotes(ctx context.Context, conn dbConn, owner, q string, limit int) ([]SearchResult, error) {
+	if owner == "" {
+		return nil, errors.New("model: owner not supplied")
+	}
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit < 0 || limit > MaxSearchLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidSearch, MaxSearchLimit)
+	}
+
+	tsquery, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	queryRows, err := conn.Query(ctx,
+		`SELECT id, owner, content, created, modified,
+			ts_headline('english', translate(content, chr(1) || chr(2), ''), query, 'StartSel=`+headlineStart+`, StopSel=`+headlineStop+`, MaxFragments=2'),
+			ts_rank(search, query) AS rank
+		FROM public.note, to_tsquery('english', $2) query
+		WHERE owner = $1 AND deleted_at IS NULL AND search @@ query
+		ORDER BY rank DESC, created DESC
+		LIMIT $3`,
+		owner, tsquery, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("model: could not search notes: %w", err)
+	}
+	defer queryRows.Close()
+
+	results := []SearchResult{}
+	for queryRows.Next() {
+		result := SearchResult{}
+		err = queryRows.Scan(&result.Id, &result.Owner, &result.Content, &result.Created, &result.Modified, &result.Snippet, &result.Rank)
+		if err != nil {
+			return nil, fmt.Errorf("model: query scan failed: %w", err)
+		}
+		result.Tags = extractTags(result.Content)
+		result.Snippet = highlight(result.Snippet)
+		results = append(results, result)
+	}
+
+	if queryRows.Err() != nil {
+		return nil, fmt.Errorf("model: query read failed: %w", queryRows.Err())
+	}
+
+	return results, nil
+}
+
+// Turn a ts_headline result into safe HTML
+func highlight(headline string) string {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, headlineStart, "<mark>")
+	return strings.ReplaceAll(escaped, headlineStop, "</mark>")
+}
+
+// Convert a user's search into Postgres to_tsquery syntax:
+//
+//	"banana bread" ban* -apple OR pear  →  (banana <-> bread) & ban:* & !apple | pear
+//
+// Only letters and digits make it into the output, so the user can't inject tsquery operators of their
+// own. Words containing punctuation (like "e-mail") are split up and must appear next to each other, which
+// is how Postgres indexes them.
+func parseSearchQuery(q string) (string, error) {
+	var terms []string
+	// The operator to put before the next term
+	op := "&"
+
+	for _, token := range tokenizeSearch(q) {
+		if !token.phrase && token.text == "OR" {
+			if len(terms) > 0 {
+				op = "|"
+			}
+			continue
+		}
+
+		text, negate := token.text, false
+		if !token.phrase && strings.HasPrefix(text, "-") {
+			text, negate = text[1:], true
+		}
+		prefix := strings.HasSuffix(text, "*")
+		text = strings.TrimRight(text, "*")
+
+		words := strings.FieldsFunc(text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if len(words) == 0 {
+			continue
+		}
+		for i := range words {
+			words[i] = strings.ToLower(words[i])
+		}
+		if prefix {
+			words[len(words)-1] += ":*"
+		}
+
+		term := strings.Join(words, " <-> ")
+		if len(words) > 1 {
+			term = "(" + term + ")"
+		}
+		if negate {
+			term = "!" + term
+		}
+
+		if len(terms) > 0 {
+			terms = append(terms, op)
+		}
+		terms = append(terms, term)
+		op = "&"
+	}
+
+	if len(terms) == 0 {
+		return "", fmt.Errorf("%w: query must contain at least one word", ErrInvalidSearch)
+	}
+	return strings.Join(terms, " "), nil
+}
+
+type searchToken struct {
+	text   string
+	phrase bool
+}
+
+// Split a search into whitespace-separated words and "quoted phrases". An unterminated quote runs
+// to the end of the query.
+func tokenizeSearch(q string) []searchToken {
+	var tokens []searchToken
+	for {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			return tokens
+		}
+		if q[0] == '"' {
+			phrase, rest, _ := strings.Cut(q[1:], `"`)
+			tokens = append(tokens, searchToken{text: phrase, phrase: true})
+			q = rest
+			continue
+		}
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end == -1 {
+			end = len(q)
+		}
+		tokens = append(tokens, searchToken{text: q[:end]})
+		q = q[end:]
+	}
+}
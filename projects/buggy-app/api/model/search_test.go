@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{query: "banana", expected: "banana"},
+		{query: "Banana Bread", expected: "banana & bread"},
+		{query: `"banana bread"`, expected: "(banana <-> bread)"},
+		{query: `"banana bre*"`, expected: "(banana <-> bre:*)"},
+		{query: "ban*", expected: "ban:*"},
+		{query: "-apple banana", expected: "!apple & banana"},
+		{query: "apple OR pear", expected: "apple | pear"},
+		{query: "e-mail", expected: "(e <-> mail)"},
+		{query: `"unterminated phrase`, expected: "(unterminated <-> phrase)"},
+		{query: "café naïve", expected: "café & naïve"},
+		// tsquery operators typed by the user are not passed through
+		{query: "a&b | !c <-> (d):*", expected: "(a <-> b) & c & d:*"},
+		{query: "OR banana OR", expected: "banana"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			actual, err := parseSearchQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseSearchQueryEmpty(t *testing.T) {
+	for _, query := range []string{"", "   ", `""`, "-", "* OR !"} {
+		if _, err := parseSearchQuery(query); !errors.Is(err, ErrInvalidSearch) {
+			t.Fatalf("%q: expected ErrInvalidSearch, got %v", query, err)
+		}
+	}
+}
+
+func TestSearchNotes(t *testing.T) {
+	mock, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close(context.Background())
+
+	owner, created := "abc123", time.Now()
+	content := "Banana <b>bread</b> #baking"
+
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified", "ts_headline", "rank"}).
+		AddRow("xyz789", owner, content, created, created, "\x01Banana\x02 <b>bread</b>", float32(0.06))
+
+	// Markers in the content are removed before it's highlighted
+	mock.ExpectQuery("SELECT (.+) ts_headline\\('english', translate\\(content, chr\\(1\\) \\|\\| chr\\(2\\), ''\\), query, (.+) FROM public.note, to_tsquery(.+) WHERE owner = (.+) AND search @@ query (.+)").
+		WithArgs(owner, "banana", DefaultSearchLimit).
+		WillReturnRows(rows)
+
+	results, err := SearchNotes(context.Background(), mock, owner, "banana", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SearchResult{{
+		Note: Note{
+			Id: "xyz789", Owner: owner, Content: content, Created: created, Modified: created,
+			Tags: []string{"baking"},
+		},
+		// Note content is escaped, and only the match is marked up
+		Snippet: "<mark>Banana</mark> &lt;b&gt;bread&lt;/b&gt;",
+		Rank:    0.06,
+	}}
+	if !reflect.DeepEqual(expected, results) {
+		t.Fatalf("expected %v, got %v", expected, results)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
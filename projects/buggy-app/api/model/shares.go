@@ -0,0 +1,253 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Notes can be shared with other users by their owner, with read or write access. Every read of, or
+// write to, a single note goes through authorizeNote, which considers both ownership and shares.
+
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	// Only the owner of a note can delete it, or change who it's shared with. This can't be granted.
+	permissionOwner Permission = "owner"
+)
+
+// Each permission includes the ones below it
+var permissionLevels = map[Permission]int{
+	PermissionRead:  1,
+	PermissionWrite: 2,
+	permissionOwner: 3,
+}
+
+func (p Permission) allows(want Permission) bool {
+	return permissionLevels[p] >= permissionLevels[want]
+}
+
+// A user a note has been shared with
+type Share struct {
+	NoteId     string     `json:"note_id"`
+	User       string     `json:"user"`
+	Permission Permission `json:"permission"`
+	Created    time.Time  `json:"created"`
+}
+
+// A note that has been shared with the user, and what they are allowed to do with it
+type SharedNote struct {
+	Note
+	Permission Permission `json:"permission"`
+}
+
+// ErrInvalidShare is returned (wrapped) when a share can't be granted
+var ErrInvalidShare = &Error{KindValidation, "invalid share"}
+
+// The foreign key violation error code from Postgres
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const foreignKeyViolation = "23503"
+
+// Load a note, checking that the user is allowed to access it in the way they want.
+//
+// If the user has no access to the note at all, this is ErrNotFound, so that we don't reveal which
+// notes exist. If they can see it but want more than they have been given, it's ErrForbidden.
+//
+// For anything beyond reading, the note is locked FOR UPDATE: in a transaction, it can't be changed by
+// anyone else until the transaction ends.
+func authorizeNote(ctx context.Context, conn dbConn, id, user string, want Permission) (Note, error) {
+	var note Note
+	var granted string
+
+	// permission is '' when the note hasn't been shared with the user
+	query := `SELECT n.id, n.owner, n.content, n.created, n.modified, COALESCE(s.permission, '')
+		FROM public.note n
+		LEFT JOIN public.note_share s ON s.note_id = n.id AND s.grantee = $2
+		WHERE n.id = $1 AND n.deleted_at IS NULL`
+	if want != PermissionRead {
+		query += " FOR UPDATE OF n"
+	}
+
+	err := conn.QueryRow(ctx, query, id, user).
+		Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified, &granted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return note, ErrNotFound
+	}
+	if err != nil {
+		return note, fmt.Errorf("model: query scan failed: %w", err)
+	}
+
+	var has Permission
+	switch {
+	case note.Owner == user:
+		has = permissionOwner
+	case granted != "":
+		has = Permission(granted)
+	default:
+		return Note{}, ErrNotFound
+	}
+	if !has.allows(want) {
+		return Note{}, ErrForbidden
+	}
+
+	note.Tags = extractTags(note.Content)
+	return note, nil
+}
+
+// Share one of the owner's notes with another user, or change the permission they have been given
+func GrantShare(ctx context.Context, conn dbConn, owner, id, grantee string, permission Permission) (Share, error) {
+	var share Share
+	if owner == "" {
+		return share, errors.New("model: owner not supplied")
+	}
+	if id == "" {
+		return share, errors.New("model: id not supplied")
+	}
+	if grantee == "" {
+		return share, fmt.Errorf("%w: user must not be empty", ErrInvalidShare)
+	}
+	if grantee == owner {
+		return share, fmt.Errorf("%w: notes can't be shared with their owner", ErrInvalidShare)
+	}
+	if permission != PermissionRead && permission != PermissionWrite {
+		return share, fmt.Errorf("%w: permission must be %q or %q", ErrInvalidShare, PermissionRead, PermissionWrite)
+	}
+
+	var granted string
+	err := withTx(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := authorizeNote(ctx, tx, id, owner, permissionOwner); err != nil {
+			return err
+		}
+		err := tx.QueryRow(ctx,
+			`INSERT INTO public.note_share (note_id, grantee, permission) VALUES ($1, $2, $3)
+			ON CONFLICT (note_id, grantee) DO UPDATE SET permission = EXCLUDED.permission
+			RETURNING note_id, grantee, permission, created`,
+			id, grantee, permission,
+		).Scan(&share.NoteId, &share.User, &granted, &share.Created)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return fmt.Errorf("%w: user %q not found", ErrInvalidShare, grantee)
+		}
+		if err != nil {
+			return fmt.Errorf("model: could not insert share: %w", err)
+		}
+		share.Permission = Permission(granted)
+		return nil
+	})
+	return share, err
+}
+
+// Get the users one of the owner's notes has been shared with
+func GetSharesForNote(ctx context.Context, conn dbConn, owner, id string) ([]Share, error) {
+	if owner == "" {
+		return nil, errors.New("model: owner not supplied")
+	}
+	if id == "" {
+		return nil, errors.New("model: id not supplied")
+	}
+
+	shares := []Share{}
+	err := withTx(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := authorizeNote(ctx, tx, id, owner, permissionOwner); err != nil {
+			return err
+		}
+
+		queryRows, err := tx.Query(ctx,
+			"SELECT note_id, grantee, permission, created FROM public.note_share WHERE note_id = $1 ORDER BY created",
+			id,
+		)
+		if err != nil {
+			return fmt.Errorf("model: could not query shares: %w", err)
+		}
+		defer queryRows.Close()
+
+		for queryRows.Next() {
+			share := Share{}
+			var permission string
+			err = queryRows.Scan(&share.NoteId, &share.User, &permission, &share.Created)
+			if err != nil {
+				return fmt.Errorf("model: query scan failed: %w", err)
+			}
+			share.Permission = Permission(permission)
+			shares = append(shares, share)
+		}
+
+		if queryRows.Err() != nil {
+			return fmt.Errorf("model: query read failed: %w", queryRows.Err())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// Stop sharing one of the owner's notes with a user
+func RevokeShare(ctx context.Context, conn dbConn, owner, id, grantee string) error {
+	if owner == "" {
+		return errors.New("model: owner not supplied")
+	}
+	if id == "" {
+		return errors.New("model: id not supplied")
+	}
+
+	return withTx(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := authorizeNote(ctx, tx, id, owner, permissionOwner); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, "DELETE FROM public.note_share WHERE note_id = $1 AND grantee = $2", id, grantee)
+		if err != nil {
+			return fmt.Errorf("model: could not delete share: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// Get the notes other users have shared with the user, most recently shared first
+func GetNotesSharedWith(ctx context.Context, conn dbConn, user string) ([]SharedNote, error) {
+	if user == "" {
+		return nil, errors.New("model: user not supplied")
+	}
+
+	queryRows, err := conn.Query(ctx,
+		`SELECT n.id, n.owner, n.content, n.created, n.modified, s.permission
+		FROM public.note_share s
+		JOIN public.note n ON n.id = s.note_id
+		WHERE s.grantee = $1 AND n.deleted_at IS NULL
+		ORDER BY s.created DESC, n.id`,
+		user,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("model: could not query shared notes: %w", err)
+	}
+	defer queryRows.Close()
+
+	notes := []SharedNote{}
+	for queryRows.Next() {
+		note := SharedNote{}
+		var permission string
+		err = queryRows.Scan(&note.Id, &note.Owner, &note.Content, &note.Created, &note.Modified, &permission)
+		if err != nil {
+			return nil, fmt.Errorf("model: query scan failed: %w", err)
+		}
+		note.Permission = Permission(permission)
+		note.Tags = extractTags(note.Content)
+		notes = append(notes, note)
+	}
+
+	if queryRows.Err() != nil {
+		return nil, fmt.Errorf("model: query read failed: %w", queryRows.Err())
+	}
+
+	return notes, nil
+}
@@ -0,0 +1,211 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Tags are extracted from note content, and also stored in the note_tag table so that notes can be
+// found by tag. The stored tags are rewritten in the same transaction as the note whenever its
+// content changes.
+
+// A tag, and how many of the owner's notes have it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// Get every tag used in the owner's notes (not counting the trash), most used first
+func GetTagsForOwner(ctx context.Context, conn dbConn, owner string) ([]TagCount, error) {
+	if owner == "" {
+		return nil, errors.New("model: owner not supplied")
+	}
+
+	queryRows, err := conn.Query(ctx,
+		`SELECT t.tag, count(*) FROM public.note_tag t
+		JOIN public.note n ON n.id = t.note_id
+		WHERE n.owner = $1 AND n.deleted_at IS NULL
+		GROUP BY t.tag
+		ORDER BY count(*) DESC, t.tag`,
+		owner,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("model: could not query tags: %w", err)
+	}
+	defer queryRows.Close()
+
+	tags := []TagCount{}
+	for queryRows.Next() {
+		tag := TagCount{}
+		err = queryRows.Scan(&tag.Tag, &tag.Count)
+		if err != nil {
+			return nil, fmt.Errorf("model: query scan failed: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if queryRows.Err() != nil {
+		return nil, fmt.Errorf("model: query read failed: %w", queryRows.Err())
+	}
+
+	return tags, nil
+}
+
+// The version of extractTags. Bump it whenever the way tags are extracted changes, and the API will
+// re-extract the stored tags of existing notes when it next starts: see UpdateTags.
+const TagVersion = 1
+
+// Bring the stored tags up to TagVersion, if they were extracted by an older version, by re-extracting
+// the tags of every note. The version is only updated once every note has been done, so if this fails
+// part way, the next call starts again. It returns the number of notes updated.
+func UpdateTags(ctx context.Context, conn dbConn) (int, error) {
+	var version int
+	err := conn.QueryRow(ctx, "SELECT version FROM public.tag_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("model: could not get tag version: %w", err)
+	}
+	if version >= TagVersion {
+		return 0, nil
+	}
+
+	count, err := RetagNotes(ctx, conn)
+	if err != nil {
+		return count, err
+	}
+	_, err = conn.Exec(ctx, "UPDATE public.tag_version SET version = $1 WHERE version < $1", TagVersion)
+	if err != nil {
+		return count, fmt.Errorf("model: could not update tag version: %w", err)
+	}
+	return count, nil
+}
+
+// Re-extract and store the tags of every note, for every owner. A note whose tags change is
+// modified, so that the ETags and Last-Modified of lists filtered by tag, and of the note, change
+// with them. It returns the number of notes whose tags changed.
+func RetagNotes(ctx context.Context, conn dbConn) (int, error) {
+	// Notes are read a batch at a time, in ID order, so we never hold every note in memory. Each
+	// batch is locked until its tags are stored, so that notes being edited meanwhile, or retagged
+	// by another API server, are done one after the other.
+	const batchSize = 500
+	count, after := 0, ""
+	for {
+		// The notes, with the tags they have stored
+		notes := []Note{}
+		err := withTx(ctx, conn, func(tx pgx.Tx) error {
+			queryRows, err := tx.Query(ctx,
+				`SELECT id, content, ARRAY(SELECT tag FROM public.note_tag WHERE note_id = note.id)
+				FROM public.note WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE`,
+				after, batchSize,
+			)
+			if err != nil {
+				return fmt.Errorf("model: could not query notes: %w", err)
+			}
+			for queryRows.Next() {
+				note := Note{}
+				if err := queryRows.Scan(&note.Id, &note.Content, &note.Tags); err != nil {
+					queryRows.Close()
+					return fmt.Errorf("model: query scan failed: %w", err)
+				}
+				notes = append(notes, note)
+			}
+			queryRows.Close()
+			if queryRows.Err() != nil {
+				return fmt.Errorf("model: query read failed: %w", queryRows.Err())
+			}
+
+			for _, note := range notes {
+				tags := extractTags(note.Content)
+				if sameTags(note.Tags, tags) {
+					continue
+				}
+				if err := replaceTags(ctx, tx, note.Id, tags); err != nil {
+					return err
+				}
+				// note_update_modified sets modified to now
+				_, err := tx.Exec(ctx, "UPDATE public.note SET modified = current_timestamp WHERE id = $1", note.Id)
+				if err != nil {
+					return fmt.Errorf("model: could not update note: %w", err)
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		if len(notes) == 0 {
+			return count, nil
+		}
+		after = notes[len(notes)-1].Id
+	}
+}
+
+// Whether stored tags are the same as extracted ones, in any order
+func sameTags(stored, extracted []string) bool {
+	extracted = uniqueTags(extracted)
+	if len(stored) != len(extracted) {
+		return false
+	}
+	seen := make(map[string]bool, len(stored))
+	for _, tag := range stored {
+		seen[tag] = true
+	}
+	for _, tag := range extracted {
+		if !seen[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+// Store the tags for a newly-inserted note
+func insertTags(ctx context.Context, tx pgx.Tx, noteId string, tags []string) error {
+	tags = uniqueTags(tags)
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx,
+		"INSERT INTO public.note_tag (note_id, tag) SELECT $1, unnest($2::text[])",
+		noteId, tags,
+	)
+	if err != nil {
+		return fmt.Errorf("model: could not insert tags: %w", err)
+	}
+	return nil
+}
+
+// Replace the stored tags of a note whose content has changed
+func replaceTags(ctx context.Context, tx pgx.Tx, noteId string, tags []string) error {
+	_, err := tx.Exec(ctx, "DELETE FROM public.note_tag WHERE note_id = $1", noteId)
+	if err != nil {
+		return fmt.Errorf("model: could not delete tags: %w", err)
+	}
+	return insertTags(ctx, tx, noteId, tags)
+}
+
+// Tags asked for by a user are folded in the same way as extracted tags, and may start with a #
+func queryTags(tags []string) []string {
+	folded := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		folded = append(folded, foldTag(strings.TrimPrefix(tag, "#")))
+	}
+	return folded
+}
+
+// Remove empty and duplicate tags, keeping the first of each
+func uniqueTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	unique := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		unique = append(unique, tag)
+	}
+	return unique
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestUpdateTags(t *testing.T) {
+	mock, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close(context.Background())
+
+	// Tags from the old regex are re-extracted, a batch at a time...
+	mock.ExpectQuery("^SELECT version FROM public.tag_version$").
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT id, content, (.+) FROM public.note WHERE id > (.+) FOR UPDATE$").
+		WithArgs("", 500).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "content", "tags"}).
+			AddRow("abc123", "## Monday #Work and `#code`", []string{"# Monday", "Work and `", "code`"}).
+			AddRow("def456", "#home #Shopping", []string{"shopping", "home"}))
+	// ... and a note whose tags change is modified, so lists filtered by tag get a new ETag...
+	mock.ExpectExec("^DELETE FROM public.note_tag WHERE note_id = (.+)$").
+		WithArgs("abc123").
+		WillReturnResult(pgxmock.NewResult("DELETE", 3))
+	mock.ExpectExec("^INSERT INTO public.note_tag (.+)$").
+		WithArgs("abc123", []string{"work"}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec("^UPDATE public.note SET modified = current_timestamp WHERE id = (.+)$").
+		WithArgs("abc123").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	// ... but one whose tags are the same is left alone
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT id, content, (.+) FROM public.note WHERE id > (.+) FOR UPDATE$").
+		WithArgs("def456", 500).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "content", "tags"}))
+	mock.ExpectCommit()
+	// ... and then the version is updated
+	mock.ExpectExec("^UPDATE public.tag_version SET version = (.+)$").
+		WithArgs(TagVersion).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	n, err := UpdateTags(context.Background(), mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 note to be updated, got %d", n)
+	}
+
+	// Once they're up to date, there's nothing to do
+	mock.ExpectQuery("^SELECT version FROM public.tag_version$").
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(TagVersion))
+
+	n, err = UpdateTags(context.Background(), mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected no notes to be updated, got %d", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/requestidctx"
+	"github.com/jackc/pgx/v5"
+)
+
+// Errors are written as RFC 7807 problem details (https://www.rfc-editor.org/rfc/rfc7807):
+//
+//	HTTP/1.1 404 Not Found
+//	Content-Type: application/problem+json
+//	X-Request-Id: 3f2c0e5d9a8b4c7e1f6a2b3c4d5e6f70
+//
+//	{
+//		"type": "about:blank",
+//		"title": "Not Found",
+//		"status": 404,
+//		"detail": "note not found",
+//		"instance": "/1/my/note/xyz789.json",
+//		"request_id": "3f2c0e5d9a8b4c7e1f6a2b3c4d5e6f70"
+//	}
+//
+// The request_id is the one in the X-Request-Id header (see requestid.go), so that a problem a user
+// reports can be found in the logs.
+
+const problemContentType = "application/problem+json"
+
+type problem struct {
+	// Problems are only told apart by their status, so the type is always about:blank
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+func newProblem(r *http.Request, status int, detail string) problem {
+	requestId, _ := requestidctx.FromRequestIdContext(r.Context())
+	return problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestId: requestId,
+	}
+}
+
+// Write a problem response. detail is shown to the client, so it must not say anything about how
+// the service works inside; it can be empty.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblemBody(w, status, newProblem(r, status, detail))
+}
+
+// Write a problem response with a body that extends problem with more members
+func writeProblemBody(w http.ResponseWriter, status int, body interface{}) {
+	res, err := util.JSON.Encode(body)
+	if err != nil {
+		// A problem is only strings and numbers, so this can't happen
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Anything set for the response we were going to write doesn't describe this one
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+// Write the problem response for an error, which is usually from the model. Model errors tell the
+// client what was wrong with their request; anything else is a 500, with no detail.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	detail := ""
+	var modelErr *model.Error
+	if status < http.StatusInternalServerError && errors.As(err, &modelErr) {
+		// Model errors are wrapped with details of what was wrong with the input, which are
+		// worth showing, but only if nothing else has been wrapped around them
+		detail = modelErr.Error()
+		if strings.HasPrefix(err.Error(), detail) {
+			detail = err.Error()
+		}
+		detail = strings.TrimPrefix(detail, "model: ")
+	}
+	writeProblem(w, r, status, detail)
+}
+
+func errorStatus(err error) int {
+	// A write made conditional with If-Match finding the note has changed is a failed precondition
+	// (https://httpwg.org/specs/rfc9110.html#field.if-match), not just any conflict
+	if errors.Is(err, model.ErrModified) {
+		return http.StatusPreconditionFailed
+	}
+	// The model should turn a missing row into ErrNotFound, but if it doesn't, it's still not a 500
+	if errors.Is(err, pgx.ErrNoRows) {
+		return http.StatusNotFound
+	}
+
+	switch model.KindOf(err) {
+	case model.KindNotFound:
+		return http.StatusNotFound
+	case model.KindForbidden:
+		return http.StatusForbidden
+	case model.KindValidation:
+		return http.StatusBadRequest
+	case model.KindConflict:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
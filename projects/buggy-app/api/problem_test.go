@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		detail string
+	}{
+		{model.ErrNotFound, http.StatusNotFound, "note not found"},
+		{model.ErrForbidden, http.StatusForbidden, "forbidden"},
+		{fmt.Errorf("%w: content must not be empty", model.ErrInvalidNote), http.StatusBadRequest, "invalid note: content must not be empty"},
+		{model.ErrModified, http.StatusPreconditionFailed, "note has been modified"},
+		{&model.Error{Kind: model.KindConflict, Message: "clash"}, http.StatusConflict, "clash"},
+		{pgx.ErrNoRows, http.StatusNotFound, ""},
+		// Wrapping hides the details, as they might not be about the input
+		{fmt.Errorf("api: could not do something: %w", model.ErrInvalidNote), http.StatusBadRequest, "invalid note"},
+		// Internal errors must not say anything about what went wrong
+		{errors.New("dial tcp 10.0.0.5:5432: connection refused"), http.StatusInternalServerError, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/1/my/note/xyz789.json", nil)
+			res := httptest.NewRecorder()
+			writeError(res, req, test.err)
+
+			if res.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, res.Code)
+			}
+			if contentType := res.Header().Get("Content-Type"); contentType != problemContentType {
+				t.Fatalf("expected %s, got %s", problemContentType, contentType)
+			}
+			var p problem
+			if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			expected := problem{
+				Type:     "about:blank",
+				Title:    http.StatusText(test.status),
+				Status:   test.status,
+				Detail:   test.detail,
+				Instance: "/1/my/note/xyz789.json",
+			}
+			if p != expected {
+				t.Fatalf("expected %+v, got %+v", expected, p)
+			}
+		})
+	}
+}
+
+func TestMyNoteByIdNotFoundProblem(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+) WHERE n.id = (.+)$").
+		WithArgs("xyz789", id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}))
+
+	req, err := http.NewRequest("GET", "/1/my/note/xyz789.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, res.Code)
+	}
+
+	var p problem
+	if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.RequestId == "" || p.RequestId != res.Header().Get("X-Request-Id") {
+		t.Fatalf("expected request id %q in the problem, got %q", res.Header().Get("X-Request-Id"), p.RequestId)
+	}
+	if p.Detail != "note not found" {
+		t.Fatalf("expected detail %q, got %q", "note not found", p.Detail)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUnknownRouteProblem(t *testing.T) {
+	as := New(defaultConfig)
+
+	req, err := http.NewRequest("GET", "/1/nothing/here.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != problemContentType {
+		t.Fatalf("expected %s, got %s", problemContentType, contentType)
+	}
+}
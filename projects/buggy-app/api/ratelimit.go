@@ -0,0 +1,202 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/authuserctx"
+)
+
+// Every request costs an auth Verify and at least one database query, so clients are rate limited
+// with a token bucket each (https://en.wikipedia.org/wiki/Token_bucket). A bucket holds up to Burst
+// tokens and refills at Rate tokens per second; each request takes one, and a request that finds the
+// bucket empty is rejected with 429 Too Many Requests.
+//
+// Authenticated requests are limited per user. Requests that fail authentication are limited per
+// client IP, so guessing passwords is slow, and once an IP has run out, its requests are rejected
+// before they reach the auth service at all.
+//
+// Responses carry the state of the bucket in the headers from
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/:
+//
+//	RateLimit-Limit: 20
+//	RateLimit-Remaining: 0
+//	RateLimit-Reset: 2
+//	Retry-After: 1
+
+// A RateLimit is the size of a token bucket and how fast it refills. The zero value means no limit.
+type RateLimit struct {
+	// Requests per second, on average
+	Rate float64
+	// The most requests that can be made at once, after a quiet spell
+	Burst int
+}
+
+func (limit RateLimit) enabled() bool {
+	return limit.Rate > 0 && limit.Burst > 0
+}
+
+// How long an empty bucket takes to fill
+func (limit RateLimit) window() time.Duration {
+	return time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second))
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// The outcome of a request taking a token from a bucket
+type rateLimitDecision struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// Until the bucket is full again
+	reset time.Duration
+	// Until the next token, when the request wasn't allowed
+	retryAfter time.Duration
+}
+
+// rateLimiter keeps a bucket per key. Buckets are only kept while they are refilling: a full
+// bucket is the same as no bucket, so they are swept away once in a while.
+type rateLimiter struct {
+	limit RateLimit
+	// The clock, which tests replace
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Create a limiter, or nil if the limit is disabled. A nil limiter allows everything.
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if !limit.enabled() {
+		return nil
+	}
+	return &rateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Take a token from key's bucket, if there is one
+func (l *rateLimiter) take(key string) rateLimitDecision {
+	return l.decide(key, true)
+}
+
+// Check whether key's bucket has a token, without taking it
+func (l *rateLimiter) peek(key string) rateLimitDecision {
+	return l.decide(key, false)
+}
+
+func (l *rateLimiter) decide(key string, take bool) rateLimitDecision {
+	if l == nil {
+		return rateLimitDecision{allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+	}
+	// Refill for the time since the bucket was last used
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(l.limit.Burst), b.tokens+elapsed.Seconds()*l.limit.Rate)
+		b.updated = now
+	}
+
+	decision := rateLimitDecision{limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		decision.allowed = true
+		if take {
+			b.tokens--
+			l.buckets[key] = b
+		}
+	} else {
+		decision.retryAfter = l.timeToRefill(1 - b.tokens)
+	}
+	decision.remaining = int(b.tokens)
+	decision.reset = l.timeToRefill(float64(l.limit.Burst) - b.tokens)
+	return decision
+}
+
+func (l *rateLimiter) timeToRefill(tokens float64) time.Duration {
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// Forget the buckets that have filled up. This runs at most once per window, and any bucket last
+// used more than a window ago is full.
+func (l *rateLimiter) sweep(now time.Time) {
+	window := l.limit.window()
+	if now.Sub(l.lastSweep) < window {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= window {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Write the RateLimit-* headers for a decision, and if the request wasn't allowed, the 429 response
+func writeRateLimit(w http.ResponseWriter, r *http.Request, decision rateLimitDecision) {
+	if decision.limit == 0 {
+		// Not limited
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.reset)))
+	if !decision.allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.retryAfter)))
+		writeProblem(w, r, http.StatusTooManyRequests, "")
+	}
+}
+
+// Headers count in whole seconds, and rounding down could send a client back too early
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// The IP address the request came from. X-Forwarded-For is not used: any client can set it, and
+// this service isn't deployed behind a proxy that would.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// wrapRateLimit takes a handler function and wraps it with a rate limit check. It goes inside
+// wrapAuth, so the request is limited by the authenticated user ID; if there isn't one, the limit is
+// per client IP.
+func (as *Service) wrapRateLimit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var decision rateLimitDecision
+		if id, ok := authuserctx.FromAuthenticatedContext(r.Context()); ok {
+			decision = as.userLimiter.take(id)
+		} else {
+			decision = as.anonymousLimiter.take(clientIP(r))
+		}
+
+		writeRateLimit(w, r, decision)
+		if !decision.allowed {
+			as.config.Log.Printf("api: rate limited: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+// fakeClock only moves when it's told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2022, 10, 16, 9, 45, 3, 0, time.UTC)}
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimit{Rate: 2, Burst: 3})
+	limiter.now = clock.Now
+
+	// The bucket starts full, so the whole burst is allowed at once
+	for i := 2; i >= 0; i-- {
+		decision := limiter.take("abc123")
+		if !decision.allowed {
+			t.Fatalf("expected request to be allowed with %d remaining", i)
+		}
+		if decision.remaining != i {
+			t.Fatalf("expected %d remaining, got %d", i, decision.remaining)
+		}
+	}
+
+	decision := limiter.take("abc123")
+	if decision.allowed {
+		t.Fatalf("expected request to be limited")
+	}
+	if decision.retryAfter != 500*time.Millisecond {
+		t.Fatalf("expected to retry after 500ms, got %v", decision.retryAfter)
+	}
+	if decision.reset != 1500*time.Millisecond {
+		t.Fatalf("expected reset after 1.5s, got %v", decision.reset)
+	}
+
+	// Half a second refills one token
+	clock.Advance(500 * time.Millisecond)
+	if decision := limiter.take("abc123"); !decision.allowed {
+		t.Fatalf("expected request to be allowed after refill")
+	}
+	if decision := limiter.take("abc123"); decision.allowed {
+		t.Fatalf("expected request to be limited again")
+	}
+
+	// Waiting longer than it takes to refill doesn't overfill the bucket
+	clock.Advance(time.Hour)
+	if decision := limiter.take("abc123"); decision.remaining != 2 {
+		t.Fatalf("expected 2 remaining, got %d", decision.remaining)
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimit{Rate: 1, Burst: 1})
+	limiter.now = clock.Now
+
+	if decision := limiter.take("abc123"); !decision.allowed {
+		t.Fatalf("expected abc123 to be allowed")
+	}
+	if decision := limiter.take("abc123"); decision.allowed {
+		t.Fatalf("expected abc123 to be limited")
+	}
+	// Each key has a bucket of its own
+	if decision := limiter.take("mno456"); !decision.allowed {
+		t.Fatalf("expected mno456 to be allowed")
+	}
+}
+
+func TestRateLimiterPeek(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimit{Rate: 1, Burst: 1})
+	limiter.now = clock.Now
+
+	for i := 0; i < 3; i++ {
+		if decision := limiter.peek("abc123"); !decision.allowed {
+			t.Fatalf("expected peek not to take a token")
+		}
+	}
+	limiter.take("abc123")
+	if decision := limiter.peek("abc123"); decision.allowed {
+		t.Fatalf("expected peek to see an empty bucket")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newRateLimiter(RateLimit{Rate: 1, Burst: 10})
+	limiter.now = clock.Now
+
+	limiter.take("abc123")
+	clock.Advance(5 * time.Second)
+	limiter.take("mno456")
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(limiter.buckets))
+	}
+
+	// abc123's bucket has refilled, but mno456's hasn't
+	clock.Advance(5 * time.Second)
+	limiter.take("xyz789")
+	if _, ok := limiter.buckets["abc123"]; ok {
+		t.Fatalf("expected full bucket to be swept")
+	}
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(limiter.buckets))
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{})
+	if limiter != nil {
+		t.Fatalf("expected no limiter for the zero RateLimit")
+	}
+	if decision := limiter.take("abc123"); !decision.allowed {
+		t.Fatalf("expected a nil limiter to allow everything")
+	}
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/1/my/notes.json", nil)
+	writeRateLimit(res, req, limiter.take("abc123"))
+	if limit := res.Header().Get("RateLimit-Limit"); limit != "" {
+		t.Fatalf("expected no rate limit headers, got RateLimit-Limit %s", limit)
+	}
+}
+
+func TestMyTagsRateLimited(t *testing.T) {
+	config := defaultConfig
+	config.RateLimit = RateLimit{Rate: 0.5, Burst: 1}
+	as := New(config)
+	clock := newFakeClock()
+	as.userLimiter.now = clock.Now
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	// Only the first request reaches the database
+	mock.ExpectQuery("SELECT t.tag, count(.+) FROM public.note_tag t (.+) WHERE n.owner = (.+) GROUP BY t.tag").
+		WithArgs(id).
+		WillReturnRows(mock.NewRows([]string{"tag", "count"}))
+
+	req, err := http.NewRequest("GET", "/1/my/tags.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	handler := as.Handler()
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	if remaining := res.Header().Get("RateLimit-Remaining"); remaining != "0" {
+		t.Fatalf("expected RateLimit-Remaining 0, got %s", remaining)
+	}
+
+	clock.Advance(time.Second)
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, res.Code)
+	}
+	expected := map[string]string{
+		"Retry-After":         "1",
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "1",
+	}
+	for header, value := range expected {
+		if got := res.Header().Get(header); got != value {
+			t.Errorf("expected %s %s, got %s", header, value, got)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAuthFailuresRateLimited(t *testing.T) {
+	config := defaultConfig
+	config.AnonymousRateLimit = RateLimit{Rate: 0.1, Burst: 2}
+	as := New(config)
+	clock := newFakeClock()
+	as.anonymousLimiter.now = clock.Now
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateDeny,
+	})
+
+	req, err := http.NewRequest("GET", "/1/my/notes.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.1:54321"
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue("abc123", "wrong"))
+	handler := as.Handler()
+
+	for i := 0; i < 2; i++ {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, res.Code)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, res.Code)
+	}
+	if retryAfter := res.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Fatalf("expected Retry-After 10, got %s", retryAfter)
+	}
+
+	// Another IP is unaffected
+	req.RemoteAddr = "192.0.2.2:54321"
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, res.Code)
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Responses can be written in more than one way. A single note (or revision) can be fetched as
+// JSON, or as its Markdown content, plain text or HTML. Lists of notes can be fetched as JSON, CSV
+// or NDJSON. The client chooses with an extension on the path (/1/my/note/abc123.md), or leaves the
+// extension off and sends an Accept header.
+var (
+	noteEncodings = util.Registry{util.JSON, util.Markdown, util.PlainText, util.HTML}
+	listEncodings = util.Registry{util.JSON, util.CSV, util.NDJSON}
+)
+
+// The path extensions lists can be fetched with, like ".csv"
+func listExtensions() []string {
+	extensions := make([]string, len(listEncodings))
+	for i, encoding := range listEncodings {
+		extensions[i] = encoding.Extension
+	}
+	return extensions
+}
+
+// Choose how to write the response to r. When the client has asked for something we can't write,
+// an error response is written and ok is false.
+func negotiate(w http.ResponseWriter, r *http.Request, encodings util.Registry) (encoding util.Encoding, ok bool) {
+	if ext := path.Ext(r.URL.Path); ext != "" {
+		encoding, ok = encodings.ForExtension(ext)
+		if !ok {
+			writeProblem(w, r, http.StatusNotFound, "")
+		}
+		return encoding, ok
+	}
+
+	// The response depends on the Accept header, so caches must keep one copy per value of it
+	w.Header().Add("Vary", "Accept")
+	encoding, ok = encodings.Negotiate(r.Header.Get("Accept"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotAcceptable, "")
+	}
+	return encoding, ok
+}
+
+// Markdown is rendered with GitHub-flavoured extensions. Raw HTML in notes is dropped by goldmark
+// and the output is sanitized as well, so a note can't inject script into the page.
+var (
+	markdown  = goldmark.New(goldmark.WithExtensions(extension.GFM))
+	sanitizer = bluemonday.UGCPolicy()
+)
+
+var htmlDocument = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<article>
+{{.Body}}</article>
+</body>
+</html>
+`))
+
+func renderHTML(title, content string) ([]byte, error) {
+	var body bytes.Buffer
+	if err := markdown.Convert([]byte(content), &body); err != nil {
+		return nil, fmt.Errorf("could not render markdown: [%w]", err)
+	}
+
+	var b bytes.Buffer
+	err := htmlDocument.Execute(&b, struct {
+		Title string
+		Body  template.HTML
+	}{
+		Title: title,
+		Body:  template.HTML(sanitizer.SanitizeBytes(body.Bytes())),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not render html: [%w]", err)
+	}
+	return b.Bytes(), nil
+}
+
+// The response for a single note
+type noteResponse struct {
+	Note model.Note `json:"note"`
+}
+
+func (res noteResponse) MarshalMarkdown() ([]byte, error) {
+	return []byte(res.Note.Content), nil
+}
+
+func (res noteResponse) MarshalPlainText() ([]byte, error) {
+	return []byte(res.Note.Content), nil
+}
+
+func (res noteResponse) MarshalHTML() ([]byte, error) {
+	return renderHTML(fmt.Sprintf("Note %s", res.Note.Id), res.Note.Content)
+}
+
+// The response for a single revision of a note
+type revisionResponse struct {
+	Revision model.Revision `json:"revision"`
+}
+
+func (res revisionResponse) MarshalMarkdown() ([]byte, error) {
+	return []byte(res.Revision.Content), nil
+}
+
+func (res revisionResponse) MarshalPlainText() ([]byte, error) {
+	return []byte(res.Revision.Content), nil
+}
+
+func (res revisionResponse) MarshalHTML() ([]byte, error) {
+	return renderHTML(fmt.Sprintf("Note %s, revision %d", res.Revision.NoteId, res.Revision.Revision), res.Revision.Content)
+}
+
+// The columns every CSV list of notes starts with. Tags are separated by spaces.
+var noteCSVHeader = []string{"id", "owner", "created", "modified", "tags", "content"}
+
+func noteCSVRecord(note model.Note) []string {
+	return []string{
+		note.Id,
+		note.Owner,
+		note.Created.Format(time.RFC3339Nano),
+		note.Modified.Format(time.RFC3339Nano),
+		strings.Join(note.Tags, " "),
+		note.Content,
+	}
+}
+
+// The response for a page of notes. In CSV and NDJSON there's nowhere to put the cursor for the
+// next page, so it goes in a Link header instead (see handleGetMyNotes).
+type notesResponse struct {
+	Notes      model.Notes `json:"notes"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+func (res notesResponse) MarshalCSV() ([][]string, error) {
+	records := [][]string{noteCSVHeader}
+	for _, note := range res.Notes {
+		records = append(records, noteCSVRecord(note))
+	}
+	return records, nil
+}
+
+func (res notesResponse) MarshalRecords() ([]interface{}, error) {
+	records := make([]interface{}, len(res.Notes))
+	for i, note := range res.Notes {
+		records[i] = note
+	}
+	return records, nil
+}
+
+// The response for the notes in the trash
+type trashResponse struct {
+	Notes model.Notes `json:"notes"`
+}
+
+func (res trashResponse) MarshalCSV() ([][]string, error) {
+	records := [][]string{append(noteCSVHeader, "deleted")}
+	for _, note := range res.Notes {
+		deleted := ""
+		if note.Deleted != nil {
+			deleted = note.Deleted.Format(time.RFC3339Nano)
+		}
+		records = append(records, append(noteCSVRecord(note), deleted))
+	}
+	return records, nil
+}
+
+func (res trashResponse) MarshalRecords() ([]interface{}, error) {
+	return notesResponse{Notes: res.Notes}.MarshalRecords()
+}
+
+// The response for the notes shared with the user
+type sharedNotesResponse struct {
+	Notes []model.SharedNote `json:"notes"`
+}
+
+func (res sharedNotesResponse) MarshalCSV() ([][]string, error) {
+	records := [][]string{append(noteCSVHeader, "permission")}
+	for _, note := range res.Notes {
+		records = append(records, append(noteCSVRecord(note.Note), string(note.Permission)))
+	}
+	return records, nil
+}
+
+func (res sharedNotesResponse) MarshalRecords() ([]interface{}, error) {
+	records := make([]interface{}, len(res.Notes))
+	for i, note := range res.Notes {
+		records[i] = note
+	}
+	return records, nil
+}
+
+// The response for a search
+type searchResponse struct {
+	Results []model.SearchResult `json:"results"`
+}
+
+func (res searchResponse) MarshalCSV() ([][]string, error) {
+	records := [][]string{append(noteCSVHeader, "snippet", "rank")}
+	for _, result := range res.Results {
+		records = append(records, append(noteCSVRecord(result.Note),
+			result.Snippet,
+			strconv.FormatFloat(float64(result.Rank), 'g', -1, 32),
+		))
+	}
+	return records, nil
+}
+
+func (res searchResponse) MarshalRecords() ([]interface{}, error) {
+	records := make([]interface{}, len(res.Results))
+	for i, result := range res.Results {
+		records[i] = result
+	}
+	return records, nil
+}
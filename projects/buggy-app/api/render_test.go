@@ -0,0 +1,255 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api/model"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestMyNoteByIdMarkdown(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId, content := "xyz789", "# Shopping\n\n- bread #food\n"
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, content, time.Now(), time.Now(), ""))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/1/my/note/%s.md", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "text/markdown; charset=utf-8" {
+		t.Fatalf("expected markdown, got %s", contentType)
+	}
+	if res.Body.String() != content {
+		t.Fatalf("expected %q, got %q", content, res.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNoteByIdHTML(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	noteId := "xyz789"
+	content := "# Shopping\n\n<script>alert(1)</script>\n\n[click](javascript:alert(1)) **now**"
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note n LEFT JOIN public.note_share s (.+)$").
+		WithArgs(noteId, id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow(noteId, id, content, time.Now(), time.Now(), ""))
+
+	// No extension, so the Accept header decides
+	req, err := http.NewRequest("GET", fmt.Sprintf("/1/my/note/%s", noteId), strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	req.Header.Add("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Fatalf("expected html, got %s", contentType)
+	}
+	if vary := res.Header().Get("Vary"); vary != "Accept" {
+		t.Fatalf("expected Vary: Accept, got %q", vary)
+	}
+
+	body := res.Body.String()
+	for _, expected := range []string{"<title>Note xyz789</title>", "<h1>Shopping</h1>", "<strong>now</strong>"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in:\n%s", expected, body)
+		}
+	}
+	for _, unexpected := range []string{"<script", "javascript:"} {
+		if strings.Contains(body, unexpected) {
+			t.Fatalf("unexpected %q in:\n%s", unexpected, body)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNoteByIdNotAcceptable(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+
+	req, err := http.NewRequest("GET", "/1/my/note/xyz789", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	req.Header.Add("Accept", "image/png")
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected status %d, got %d", http.StatusNotAcceptable, res.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMyNotesCSV(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "abc123", "password"
+	created := time.Date(2022, 10, 16, 9, 45, 3, 597524000, time.UTC)
+
+	// One more than the limit, so there's a next page
+	rows := mock.NewRows([]string{"id", "owner", "content", "created", "modified"}).
+		AddRow("xyz789", id, "Shopping, \"bread\" #food #home", created, created).
+		AddRow("xyz790", id, "Next", created, created)
+	expectNotesVersion(mock, 2, created).WithArgs(id)
+	mock.ExpectQuery("^SELECT (.+) FROM public.note WHERE owner = (.+) ORDER BY created, id LIMIT (.+)$").
+		WithArgs(id, 2).
+		WillReturnRows(rows)
+
+	req, err := http.NewRequest("GET", "/1/my/notes.csv?limit=1", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	if contentType := res.Header().Get("Content-Type"); contentType != util.CSV.ContentType {
+		t.Fatalf("expected csv, got %s", contentType)
+	}
+
+	expected := "id,owner,created,modified,tags,content\n" +
+		"xyz789,abc123,2022-10-16T09:45:03.597524Z,2022-10-16T09:45:03.597524Z,food home,\"Shopping, \"\"bread\"\" #food #home\"\n"
+	if res.Body.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, res.Body.String())
+	}
+	if link := res.Header().Get("Link"); !strings.HasPrefix(link, "</1/my/notes.csv?cursor=") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("expected a link to the next page, got %q", link)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSharedNotesNDJSON(t *testing.T) {
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = auth.NewMockClient(&auth.VerifyResult{
+		State: auth.StateAllow,
+	})
+
+	id, password := "def456", "password"
+	created := time.Date(2022, 10, 16, 9, 45, 3, 597524000, time.UTC)
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.note_share s JOIN public.note n (.+)$").
+		WithArgs(id).
+		WillReturnRows(mock.NewRows([]string{"id", "owner", "content", "created", "modified", "permission"}).
+			AddRow("xyz789", "abc123", "One", created, created, "read").
+			AddRow("xyz790", "abc123", "Two", created, created, "write"))
+
+	req, err := http.NewRequest("GET", "/1/shared/notes", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	req.Header.Add("Accept", "application/x-ndjson")
+	res := httptest.NewRecorder()
+	handler := as.Handler()
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(res.Body.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d:\n%s", len(lines), res.Body.String())
+	}
+	assertJSON([]byte(lines[1]), model.SharedNote{
+		Note:       model.Note{Id: "xyz790", Owner: "abc123", Content: "Two", Created: created, Modified: created, Tags: []string{}},
+		Permission: model.PermissionWrite,
+	}, t)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/requestidctx"
+)
+
+// Every request has an ID, so that what happened to it can be followed through the logs of the api
+// and auth services. A client (or a proxy in front of us) can choose the ID by sending it in an
+// X-Request-Id header; otherwise one is generated. Either way, it's sent back in the response's
+// X-Request-Id header, and it's in the body of any problem.
+//
+// The ID is passed to the auth service with Verify, which logs it too, so a line in this service's
+// access log:
+//
+//	HTTP - 172.18.0.1:53814 - - 16/Oct/2022:09:45:03 +0000 "GET /1/my/notes.json HTTP/1.1" 200 162 curl/7.79.1 2.1ms request_id=3f2c0e5d9a8b4c7e1f6a2b3c4d5e6f70
+//
+// goes with these in the auth service's:
+//
+//	[3f2c0e5d9a8b4c7e1f6a2b3c4d5e6f70] verify: id A2RPq6To, start
+//	[3f2c0e5d9a8b4c7e1f6a2b3c4d5e6f70] verify: id A2RPq6To, allow
+
+// wrapRequestId gives every request an ID, adds it to the context using the requestidctx package,
+// and sends it back in the X-Request-Id header.
+func (as *Service) wrapRequestId(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := requestidctx.ValidOrNew(r.Header.Get(requestidctx.HeaderName))
+		w.Header().Set(requestidctx.HeaderName, id)
+		handler(w, r.WithContext(requestidctx.NewRequestIdContext(r.Context(), id)))
+	}
+}
+
+// statusRecorder remembers the status and size of the response written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Export streams its response, so it has to get through to the client
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wrapAccessLog logs a line for every request once it has been handled, in the common log format
+// with the request ID on the end. It goes inside wrapRequestId, so the ID is in the context.
+func (as *Service) wrapAccessLog(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		handler(recorder, r)
+
+		requestId, _ := requestidctx.FromRequestIdContext(r.Context())
+		log.Printf("HTTP - %s - - %s \"%s %s %s\" %d %d %s %s request_id=%s\n",
+			r.RemoteAddr,
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method,
+			r.URL.Path,
+			r.Proto,
+			recorder.status,
+			recorder.size,
+			r.UserAgent(),
+			time.Since(start),
+			requestId,
+		)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/requestidctx"
+)
+
+// requestIdClient is an auth client that remembers the request ID it was asked to verify for
+type requestIdClient struct {
+	auth.MockClient
+	requestId string
+}
+
+func (c *requestIdClient) Verify(ctx context.Context, id, passwd string) (*auth.VerifyResult, error) {
+	c.requestId, _ = requestidctx.FromRequestIdContext(ctx)
+	return &auth.VerifyResult{State: auth.StateDeny}, nil
+}
+
+func TestRequestId(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"from the client", "3f2c0e5d-9a8b-4c7e", "3f2c0e5d-9a8b-4c7e"},
+		{"generated", "", ""},
+		{"replaced when it could break a log line", "abc\n123", ""},
+		{"replaced when too long", strings.Repeat("a", 129), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			as := New(defaultConfig)
+			client := &requestIdClient{}
+			as.authClient = client
+
+			req, err := http.NewRequest("GET", "/1/my/notes.json", strings.NewReader(""))
+			if err != nil {
+				log.Fatal(err)
+			}
+			req.SetBasicAuth("abc123", "password")
+			if test.header != "" {
+				req.Header.Set("X-Request-Id", test.header)
+			}
+			res := httptest.NewRecorder()
+			handler := as.Handler()
+			handler.ServeHTTP(res, req)
+
+			requestId := res.Header().Get("X-Request-Id")
+			if test.expected != "" && requestId != test.expected {
+				t.Fatalf("expected request id %q, got %q", test.expected, requestId)
+			}
+			if !requestidctx.Valid(requestId) || requestId == test.header && test.expected == "" {
+				t.Fatalf("expected a new request id, got %q", requestId)
+			}
+			// The same ID goes to the auth service
+			if client.requestId != requestId {
+				t.Fatalf("expected request id %q passed to verify, got %q", requestId, client.requestId)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/requestidctx"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Every request is traced, starting with a span for the route that handles it. See the tracing
+// package for what's in a trace.
+
+// wrapTracing takes a handler function and wraps it in a span named for the method and route, like
+// GET /1/my/note/. If the request has a traceparent header, the span continues that trace.
+func (as *Service) wrapTracing(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		requestId, _ := requestidctx.FromRequestIdContext(ctx)
+		ctx, span := tracing.Tracer("github.com/CodeYourFuture/immersive-go-course/buggy-app/api").Start(ctx,
+			methodLabel(r.Method)+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPRouteKey.String(route),
+				semconv.HTTPTargetKey.String(r.URL.Path),
+				attribute.String("request_id", requestId),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, r.WithContext(ctx))
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		// Client errors are the client's problem, not a failure of the server
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
+	"github.com/pashagolub/pgxmock/v2"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// allowAuthServer is an auth service that lets everyone in
+type allowAuthServer struct {
+	pb.UnimplementedAuthServer
+}
+
+func (allowAuthServer) Verify(ctx context.Context, in *pb.VerifyRequest) (*pb.VerifyResponse, error) {
+	return &pb.VerifyResponse{State: pb.State_ALLOW, Scopes: auth.AllScopes}, nil
+}
+
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string, kind trace.SpanKind) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name && span.SpanKind == kind {
+			return span
+		}
+	}
+	t.Fatalf("no %s span named %q in %d spans", kind, name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTraceSpanTree(t *testing.T) {
+	tp, exporter := tracing.NewInMemoryProvider("test")
+	tracing.Use(tp)
+	defer tracing.Use(trace.NewNoopTracerProvider())
+
+	// A real client talking to a mock auth server, which traces like the real one
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()))
+	pb.RegisterAuthServer(grpcServer, allowAuthServer{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		grpcServer.Serve(lis)
+	}()
+	defer func() {
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}()
+
+	client, err := auth.NewClient(context.Background(), lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	as := New(defaultConfig)
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mock.Close()
+	as.pool = mock
+	as.authClient = client
+
+	id, password := "abc123", "password"
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT t.tag, count(.+) FROM public.note_tag t (.+) WHERE n.owner = (.+) GROUP BY t.tag").
+			WithArgs(id).
+			WillReturnRows(mock.NewRows([]string{"tag", "count"}))
+	}
+
+	req, err := http.NewRequest("GET", "/1/my/tags.json", strings.NewReader(""))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Authorization", util.BasicAuthHeaderValue(id, password))
+	// The request continues a trace from further out
+	parentTraceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req.Header.Add("traceparent", "00-"+parentTraceId+"-00f067aa0ba902b7-01")
+	res := httptest.NewRecorder()
+	as.Handler().ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	// GET /1/my/tags.json
+	// └── auth.GrpcClient.Verify
+	//     └── service.Auth/Verify (client)
+	//         └── service.Auth/Verify (server)
+	//
+	// pgxmock doesn't call the pgx tracer, so there are no query spans here: see the tracing package
+	spans := tracetest.SpanStubs(exporter.GetSpans())
+	httpSpan := spanNamed(t, spans, "GET /1/my/tags.json", trace.SpanKindServer)
+	verifySpan := spanNamed(t, spans, "auth.GrpcClient.Verify", trace.SpanKindInternal)
+	clientSpan := spanNamed(t, spans, "service.Auth/Verify", trace.SpanKindClient)
+	serverSpan := spanNamed(t, spans, "service.Auth/Verify", trace.SpanKindServer)
+
+	tree := []struct {
+		name          string
+		child, parent tracetest.SpanStub
+	}{
+		{"http", httpSpan, tracetest.SpanStub{SpanContext: httpSpan.Parent}},
+		{"verify", verifySpan, httpSpan},
+		{"client", clientSpan, verifySpan},
+		{"server", serverSpan, clientSpan},
+	}
+	for _, link := range tree {
+		if link.child.SpanContext.TraceID().String() != parentTraceId {
+			t.Errorf("%s: expected trace %s, got %s", link.name, parentTraceId, link.child.SpanContext.TraceID())
+		}
+		if link.child.Parent.SpanID() != link.parent.SpanContext.SpanID() {
+			t.Errorf("%s: expected parent %s, got %s", link.name, link.parent.SpanContext.SpanID(), link.child.Parent.SpanID())
+		}
+	}
+	if !httpSpan.Parent.IsRemote() {
+		t.Errorf("expected the http span's parent to come from the traceparent header")
+	}
+	if !serverSpan.Parent.IsRemote() {
+		t.Errorf("expected the server span's parent to come from the gRPC metadata")
+	}
+
+	if route := spanAttribute(httpSpan, "http.route").AsString(); route != "/1/my/tags.json" {
+		t.Errorf("expected http.route /1/my/tags.json, got %s", route)
+	}
+	if status := spanAttribute(httpSpan, "http.status_code").AsInt64(); status != http.StatusOK {
+		t.Errorf("expected http.status_code 200, got %d", status)
+	}
+	if requestId := spanAttribute(httpSpan, "request_id").AsString(); requestId != res.Header().Get("X-Request-Id") {
+		t.Errorf("expected request_id %s, got %s", res.Header().Get("X-Request-Id"), requestId)
+	}
+	if spanAttribute(verifySpan, "auth.cache_hit").AsBool() {
+		t.Errorf("expected the first verify to miss the cache")
+	}
+
+	// The second time, Verify is answered from the cache, so there's no RPC
+	exporter.Reset()
+	req.Header.Del("traceparent")
+	res = httptest.NewRecorder()
+	as.Handler().ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+
+	spans = exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	httpSpan = spanNamed(t, spans, "GET /1/my/tags.json", trace.SpanKindServer)
+	verifySpan = spanNamed(t, spans, "auth.GrpcClient.Verify", trace.SpanKindInternal)
+	if httpSpan.Parent.IsValid() {
+		t.Errorf("expected a new trace")
+	}
+	if verifySpan.Parent.SpanID() != httpSpan.SpanContext.SpanID() {
+		t.Errorf("expected verify to be a child of the http span")
+	}
+	if !spanAttribute(verifySpan, "auth.cache_hit").AsBool() {
+		t.Errorf("expected the second verify to hit the cache")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The user management RPCs are for admins: people and tools looking after the service, rather than
+// the API. A caller proves it's an admin by sending Config.AdminKey with each call, which
+// WithAdminKey adds to a client's context. Without an AdminKey, nobody can call them.
+
+// The RPCs that need the admin key
+var adminRPCs = []string{"CreateUser", "GetUser", "SetPassword", "SetStatus", "ListUsers", "DeleteUser", "Unlock"}
+
+func isAdminMethod(fullMethod string) bool {
+	prefix := "/" + pb.Auth_ServiceDesc.ServiceName + "/"
+	for _, rpc := range adminRPCs {
+		if fullMethod == prefix+rpc {
+			return true
+		}
+	}
+	return false
+}
+
+// adminInterceptor rejects calls to the admin RPCs that don't have the admin key
+func adminInterceptor(key []byte) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isAdminMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if len(key) == 0 {
+			return nil, status.Error(codes.PermissionDenied, "there is no admin key, so admin RPCs are disabled")
+		}
+		given, ok := adminKeyFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "an admin key is required")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), key) != 1 {
+			logf(ctx, "rpc: %s, wrong admin key\n", info.FullMethod)
+			return nil, status.Error(codes.PermissionDenied, "wrong admin key")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// The key from the call's "authorization: Bearer" metadata
+func adminKeyFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, value := range md.Get("authorization") {
+		scheme, key, ok := strings.Cut(value, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") && key != "" {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// WithAdminKey adds the admin key to the calls made with a context, for the admin RPCs
+func WithAdminKey(ctx context.Context, key []byte) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+string(key))
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// API keys are for scripts and CI jobs, which can't log in and refresh a session the way a person's
+// client can. A key lasts until it expires or is revoked, and its scopes limit what it can be used
+// for, so that a script which only reads notes can have a key that can't change them. Passwords and
+// sessions have every scope. Keys are checked by Introspect, like access tokens: see token.go for
+// what they look like.
+
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+)
+
+// AllScopes are the scopes there are, which passwords and sessions have all of
+var AllScopes = []string{ScopeNotesRead, ScopeNotesWrite}
+
+// The longest name a key can have, in characters
+const MaxApiKeyNameLength = 100
+
+// ValidateApiKey checks the Name, Scopes and Expires of a key that's about to be created
+func ValidateApiKey(key ApiKey, now time.Time) error {
+	name := strings.TrimSpace(key.Name)
+	if name == "" {
+		return errors.New("an API key needs a name")
+	}
+	if utf8.RuneCountInString(name) > MaxApiKeyNameLength {
+		return fmt.Errorf("an API key's name can't be longer than %d characters", MaxApiKeyNameLength)
+	}
+	if len(key.Scopes) == 0 {
+		return errors.New("an API key needs at least one scope")
+	}
+	for _, scope := range key.Scopes {
+		if !validScope(scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if !key.Expires.IsZero() && !key.Expires.After(now) {
+		return errors.New("an API key can't expire in the past")
+	}
+	return nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// The columns of an ApiKey, with the times in Unix seconds. The times are stored without a time
+// zone, so are cast to say they're in the database's.
+const apiKeyColumns = `id, name, scopes, EXTRACT(EPOCH FROM created::timestamptz)::bigint,
+	COALESCE(EXTRACT(EPOCH FROM expires::timestamptz)::bigint, 0),
+	COALESCE(EXTRACT(EPOCH FROM last_used::timestamptz)::bigint, 0)`
+
+func scanApiKey(row pgx.Row) (*pb.ApiKey, error) {
+	var key pb.ApiKey
+	err := row.Scan(&key.Id, &key.Name, &key.Scopes, &key.Created, &key.Expires, &key.LastUsed)
+	return &key, err
+}
+
+// CreateApiKey makes a new API key for a user. The key is only ever in the response: from then on,
+// only a hash of it is kept.
+func (as *grpcAuthService) CreateApiKey(ctx context.Context, in *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	key := ApiKey{
+		Name:   in.Name,
+		Scopes: in.Scopes,
+	}
+	if in.Expires != 0 {
+		key.Expires = time.Unix(in.Expires, 0)
+	}
+	if err := ValidateApiKey(key, as.now()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	secret, hash, err := newSecret()
+	if err != nil {
+		logf(ctx, "create api key: secret error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to create api key")
+	}
+	apiKey, err := scanApiKey(as.pool.QueryRow(ctx,
+		`INSERT INTO public.api_key (user_id, name, key_hash, scopes, expires)
+		VALUES ($1, $2, $3, $4, CASE WHEN $5::bigint = 0 THEN NULL ELSE to_timestamp($5) END)
+		RETURNING `+apiKeyColumns,
+		in.UserId, strings.TrimSpace(in.Name), hash, in.Scopes, in.Expires,
+	))
+	if err != nil {
+		logf(ctx, "create api key: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to create api key")
+	}
+
+	logf(ctx, "create api key: id %v, key %v, scopes %v\n", in.UserId, apiKey.Id, apiKey.Scopes)
+	return &pb.CreateApiKeyResponse{
+		ApiKey: apiKey,
+		Key:    formatApiKey(apiKey.Id, secret),
+	}, nil
+}
+
+// ListApiKeys lists a user's API keys, oldest first
+func (as *grpcAuthService) ListApiKeys(ctx context.Context, in *pb.ListApiKeysRequest) (*pb.ListApiKeysResponse, error) {
+	rows, err := as.pool.Query(ctx,
+		"SELECT "+apiKeyColumns+" FROM public.api_key WHERE user_id = $1 ORDER BY created, id",
+		in.UserId,
+	)
+	if err != nil {
+		logf(ctx, "list api keys: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to list api keys")
+	}
+	defer rows.Close()
+
+	res := &pb.ListApiKeysResponse{}
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			logf(ctx, "list api keys: scan error: %v\n", err)
+			return nil, status.Error(codes.Internal, "failed to list api keys")
+		}
+		res.ApiKeys = append(res.ApiKeys, key)
+	}
+	if err := rows.Err(); err != nil {
+		logf(ctx, "list api keys: rows error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to list api keys")
+	}
+	return res, nil
+}
+
+// RevokeApiKey deletes one of a user's API keys. Clients may still accept the key for up to
+// IntrospectCacheTTL.
+func (as *grpcAuthService) RevokeApiKey(ctx context.Context, in *pb.RevokeApiKeyRequest) (*pb.RevokeApiKeyResponse, error) {
+	tag, err := as.pool.Exec(ctx,
+		"DELETE FROM public.api_key WHERE id = $1 AND user_id = $2",
+		in.Id, in.UserId,
+	)
+	if err != nil {
+		logf(ctx, "revoke api key: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to revoke api key")
+	}
+	if tag.RowsAffected() == 0 {
+		logf(ctx, "revoke api key: id %v, key %v, deny\n", in.UserId, in.Id)
+		return &pb.RevokeApiKeyResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+
+	logf(ctx, "revoke api key: id %v, key %v, revoked\n", in.UserId, in.Id)
+	return &pb.RevokeApiKeyResponse{
+		State: pb.State_ALLOW,
+	}, nil
+}
+
+// introspectApiKey is Introspect for API keys. Each genuine key has its last_used time set, even
+// if its user isn't active, which happens at most once per IntrospectCacheTTL for each client, as
+// they remember the result.
+func (as *grpcAuthService) introspectApiKey(ctx context.Context, key string) (*pb.IntrospectResponse, error) {
+	keyId, hash, err := parseApiKey(key)
+	if err != nil {
+		logf(ctx, "introspect: deny (api key)\n")
+		return &pb.IntrospectResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+
+	res := &pb.IntrospectResponse{
+		State: pb.State_ALLOW,
+		KeyId: keyId,
+	}
+	var userStatus string
+	err = as.pool.QueryRow(ctx,
+		`UPDATE public.api_key k SET last_used = now() FROM public.user u
+		WHERE k.id = $1 AND k.key_hash = $2 AND (k.expires IS NULL OR k.expires > now()) AND u.id = k.user_id
+		RETURNING k.user_id, u.status, k.scopes, COALESCE(CEIL(EXTRACT(EPOCH FROM k.expires::timestamptz - now()))::bigint, 0)`,
+		keyId, hash,
+	).Scan(&res.Id, &userStatus, &res.Scopes, &res.ExpiresIn)
+	if err == pgx.ErrNoRows {
+		logf(ctx, "introspect: key %v, deny (api key)\n", keyId)
+		return &pb.IntrospectResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+	if err != nil {
+		logf(ctx, "introspect: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to check api key")
+	}
+	if reason := statusReason(userStatus); reason != pb.Reason_NO_REASON {
+		logf(ctx, "introspect: id %v, key %v, status %v, deny (%v)\n", res.Id, keyId, userStatus, reason)
+		return &pb.IntrospectResponse{
+			State:  pb.State_DENY,
+			Reason: reason,
+		}, nil
+	}
+	return res, nil
+}
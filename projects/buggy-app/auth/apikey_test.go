@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var apiKeyRow = []string{"id", "name", "scopes", "created", "expires", "last_used"}
+
+func TestValidateApiKey(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		key      ApiKey
+		expected string
+	}{
+		"ok":              {ApiKey{Name: "ci", Scopes: []string{ScopeNotesRead}, Expires: now.Add(time.Hour)}, ""},
+		"never expires":   {ApiKey{Name: "ci", Scopes: AllScopes}, ""},
+		"no name":         {ApiKey{Name: "  ", Scopes: AllScopes}, "needs a name"},
+		"long name":       {ApiKey{Name: strings.Repeat("x", MaxApiKeyNameLength+1), Scopes: AllScopes}, "longer than 100"},
+		"no scopes":       {ApiKey{Name: "ci"}, "at least one scope"},
+		"unknown scope":   {ApiKey{Name: "ci", Scopes: []string{"notes:delete"}}, `unknown scope "notes:delete"`},
+		"already expired": {ApiKey{Name: "ci", Scopes: AllScopes, Expires: now}, "in the past"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateApiKey(test.key, now)
+			if test.expected == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected an error containing %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestCreateApiKey(t *testing.T) {
+	now := time.Now()
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+
+	expires := now.Add(24 * time.Hour).Unix()
+	mock.ExpectQuery("^INSERT INTO public.api_key (.+) RETURNING (.+)$").
+		WithArgs("abc123", "ci", pgxmock.AnyArg(), []string{ScopeNotesRead}, expires).
+		WillReturnRows(mock.NewRows(apiKeyRow).AddRow("key1", "ci", []string{ScopeNotesRead}, now.Unix(), expires, int64(0)))
+
+	res, err := as.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{
+		UserId:  "abc123",
+		Name:    " ci ",
+		Scopes:  []string{ScopeNotesRead},
+		Expires: expires,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ApiKey.Id != "key1" || res.ApiKey.Expires != expires || res.ApiKey.LastUsed != 0 {
+		t.Fatalf("unexpected key %+v", res.ApiKey)
+	}
+	if !strings.HasPrefix(res.Key, "key.key1.") {
+		t.Fatalf("expected the key to have its ID, got %q", res.Key)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateApiKeyInvalid(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	// Nothing reaches the database
+	_, err := as.CreateApiKey(context.Background(), &pb.CreateApiKeyRequest{
+		UserId: "abc123",
+		Name:   "ci",
+		Scopes: []string{"admin"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestListApiKeys(t *testing.T) {
+	now := time.Now()
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.api_key WHERE user_id = \\$1 ORDER BY created, id$").
+		WithArgs("abc123").
+		WillReturnRows(mock.NewRows(apiKeyRow).
+			AddRow("key1", "ci", AllScopes, now.Unix(), int64(0), now.Unix()).
+			AddRow("key2", "backup", []string{ScopeNotesRead}, now.Unix(), now.Add(time.Hour).Unix(), int64(0)))
+
+	res, err := as.ListApiKeys(context.Background(), &pb.ListApiKeysRequest{UserId: "abc123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.ApiKeys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(res.ApiKeys))
+	}
+	if key := res.ApiKeys[1]; key.Id != "key2" || !reflect.DeepEqual(key.Scopes, []string{ScopeNotesRead}) {
+		t.Fatalf("unexpected key %+v", key)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRevokeApiKey(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	deleteQuery := "^DELETE FROM public.api_key WHERE id = \\$1 AND user_id = \\$2$"
+	mock.ExpectExec(deleteQuery).WithArgs("key1", "abc123").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	// Someone else's key, or one that's already gone
+	mock.ExpectExec(deleteQuery).WithArgs("key1", "def456").WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+	for _, test := range []struct {
+		userId   string
+		expected pb.State
+	}{
+		{"abc123", pb.State_ALLOW},
+		{"def456", pb.State_DENY},
+	} {
+		res, err := as.RevokeApiKey(context.Background(), &pb.RevokeApiKeyRequest{UserId: test.userId, Id: "key1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != test.expected {
+			t.Fatalf("%s: expected %v, got %v", test.userId, test.expected, res.State)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIntrospectApiKey(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	secret, hash, err := newSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := formatApiKey("key1", secret)
+
+	keyQuery := "^UPDATE public.api_key k SET last_used = now\\(\\) FROM public.user u (.+) RETURNING (.+)$"
+	keyColumns := []string{"user_id", "status", "scopes", "expires_in"}
+	mock.ExpectQuery(keyQuery).
+		WithArgs("key1", hash).
+		WillReturnRows(mock.NewRows(keyColumns).AddRow("abc123", StatusActive, []string{ScopeNotesRead}, int64(0)))
+	// The user has been made inactive since
+	mock.ExpectQuery(keyQuery).
+		WithArgs("key1", hash).
+		WillReturnRows(mock.NewRows(keyColumns).AddRow("abc123", StatusInactive, []string{ScopeNotesRead}, int64(0)))
+	// Revoked or expired
+	mock.ExpectQuery(keyQuery).
+		WithArgs("key1", hash).
+		WillReturnError(pgx.ErrNoRows)
+
+	res, err := as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_ALLOW || res.Id != "abc123" || res.KeyId != "key1" || !reflect.DeepEqual(res.Scopes, []string{ScopeNotesRead}) {
+		t.Fatalf("expected ALLOW for abc123's read-only key1, got %+v", res)
+	}
+	res, err = as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_DENY || res.Reason != pb.Reason_INACTIVE || res.Id != "" || res.Scopes != nil {
+		t.Fatalf("expected DENY for an inactive user, got %+v", res)
+	}
+
+	// A malformed key doesn't need the database
+	for _, token := range []string{key, "key.key1"} {
+		res, err := as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != pb.State_DENY || res.Id != "" || res.Scopes != nil {
+			t.Fatalf("expected DENY, got %+v", res)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -5,31 +5,95 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"sync"
+	"time"
 
 	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/certs"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Config struct {
-	Port        int
+	Port int
+	// Port to serve Prometheus metrics on over HTTP, or 0 not to
+	MetricsPort int
 	DatabaseUrl string
 	Log         *log.Logger
+	// TLS has the server's certificate and key, and the CA of the clients' certificates. Without a
+	// certificate, the server doesn't use TLS. With a CA, only clients with a certificate signed by it
+	// can call Verify (see clientAuthInterceptor). The files are reloaded when they change.
+	TLS certs.Files
+	// ClientNames, if there are any, are the names a client's certificate must have one of
+	ClientNames []string
+	// TokenKey signs the access tokens of sessions. Without one, a random key is made, and tokens
+	// stop working when the service restarts.
+	TokenKey []byte
+	// How long access tokens and sessions last, or zero for DefaultAccessTokenTTL and
+	// DefaultSessionTTL. See session.go.
+	AccessTokenTTL time.Duration
+	SessionTTL     time.Duration
+	// AdminKey lets callers that send it use the user management RPCs. Without one, nobody can. See
+	// admin.go.
+	AdminKey []byte
+	// ServiceKey lets the API call the API key RPCs, which act for any user. Without one, only
+	// clients with a certificate can, with mutual TLS, and otherwise nobody. See servicekey.go.
+	ServiceKey []byte
+	// Failed Verify and Login calls, as counted per user id and per source: see lockout.go. Zero
+	// means the Default of each. A lockout can take up to VerifyCacheTTL to reach a client that
+	// has already allowed the id's password.
+	MaxFailures       int
+	MaxSourceFailures int
+	FailureWindow     time.Duration
+	LockoutDuration   time.Duration
+	BackoffBase       time.Duration
+	BackoffMax        time.Duration
 }
 
 type Service struct {
 	config      Config
 	grpcService *grpcAuthService
+	metrics     *serverMetrics
+	health      *health.Server
 }
 
 func New(config Config) *Service {
-	return &Service{
+	if len(config.TokenKey) == 0 {
+		key, err := NewTokenKey()
+		if err != nil {
+			// The system's source of randomness is broken, and nothing would be safe
+			panic(err)
+		}
+		config.TokenKey = key
+		if config.Log != nil {
+			config.Log.Printf("auth service: no token key, so sessions won't outlive this process")
+		}
+	}
+	if config.AccessTokenTTL == 0 {
+		config.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if config.SessionTTL == 0 {
+		config.SessionTTL = DefaultSessionTTL
+	}
+	as := &Service{
 		config:      config,
-		grpcService: newGrpcService(),
+		grpcService: newGrpcService(config),
+		metrics:     newServerMetrics(),
+		health:      health.NewServer(),
 	}
+	// Nothing is served until Run has checked the database
+	setHealth(as.health, healthpb.HealthCheckResponse_NOT_SERVING)
+	return as
 }
 
 // Run starts the underlying gRPC server according to the supplied Config
@@ -45,7 +109,13 @@ func New(config Config) *Service {
 //	}
 func (as *Service) Run(ctx context.Context) error {
 	// Connect to the database via a "pool" of connections, allowing concurrency
-	pool, err := pgxpool.New(ctx, as.config.DatabaseUrl)
+	poolConfig, err := pgxpool.ParseConfig(as.config.DatabaseUrl)
+	if err != nil {
+		return fmt.Errorf("unable to parse database url: %w", err)
+	}
+	// Every query gets a span in the trace of the RPC it's for
+	poolConfig.ConnConfig.Tracer = tracing.QueryTracer{}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		return fmt.Errorf("unable to create connection pool: %w", err)
 	}
@@ -53,6 +123,12 @@ func (as *Service) Run(ctx context.Context) error {
 	// Add the pool to the "inner" auth service which implements the gRPC interface
 	// and responds to RPCs
 	as.grpcService.pool = pool
+	as.metrics.registry.MustRegister(util.NewPoolCollector("auth", func() util.PoolStat { return pool.Stat() }))
+
+	opts, err := as.serverOpts()
+	if err != nil {
+		return err
+	}
 
 	// Create a TCP listener for the gRPC server to use
 	listen := fmt.Sprintf(":%d", as.config.Port)
@@ -62,8 +138,9 @@ func (as *Service) Run(ctx context.Context) error {
 	}
 
 	// Set up and register the server
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(opts...)
 	pb.RegisterAuthServer(grpcServer, as.grpcService)
+	healthpb.RegisterHealthServer(grpcServer, as.health)
 
 	// Serve on the supplied listener
 	// This call blocks, so we put it in a goroutine
@@ -77,26 +154,100 @@ func (as *Service) Run(ctx context.Context) error {
 
 	as.config.Log.Printf("auth service: listening: %s", listen)
 
+	// Keep the health status up to date with the database
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		as.watchHealth(ctx, pool, healthCheckInterval)
+	}()
+
+	// Metrics are served over HTTP, separately from the RPCs
+	var metricsServer *http.Server
+	if as.config.MetricsPort != 0 {
+		metricsServer = &http.Server{
+			Addr:    fmt.Sprintf(":%d", as.config.MetricsPort),
+			Handler: as.metrics.handler(),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				as.config.Log.Printf("auth service: metrics: %v", err)
+			}
+		}()
+		as.config.Log.Printf("auth service: metrics listening: %s", metricsServer.Addr)
+	}
+
 	// Wait for the context cancel (e.g. from interrupt signal) before
 	// gracefully shutting down any ongoing RPCs
 	<-ctx.Done()
+	// Tell health checkers straight away, so nothing new is sent our way while we finish up
+	as.health.Shutdown()
 	grpcServer.GracefulStop()
+	if metricsServer != nil {
+		metricsServer.Shutdown(context.Background())
+	}
 
 	// Ensure the Serve goroutine is finished
 	wg.Wait()
 	return runErr
 }
 
+// The interceptors, and the TLS credentials if there's a certificate
+func (as *Service) serverOpts() ([]grpc.ServerOption, error) {
+	interceptors := []grpc.UnaryServerInterceptor{
+		otelgrpc.UnaryServerInterceptor(),
+		requestIdInterceptor,
+		as.metrics.interceptor,
+	}
+	if as.config.TLS.Cert == "" {
+		interceptors = append(interceptors, adminInterceptor(as.config.AdminKey), serviceInterceptor(as.config.ServiceKey, false))
+		return []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}, nil
+	}
+
+	store, err := certs.NewStore(as.config.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificates: %w", err)
+	}
+	if as.config.TLS.CA != "" {
+		interceptors = append(interceptors, clientAuthInterceptor(as.config.ClientNames))
+	}
+	interceptors = append(interceptors, adminInterceptor(as.config.AdminKey), serviceInterceptor(as.config.ServiceKey, as.config.TLS.CA != ""))
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(store.ServerConfig())),
+		grpc.ChainUnaryInterceptor(interceptors...),
+	}, nil
+}
+
+// dbClient is the part of the pool that the RPCs use, so that tests can mock it
+type dbClient interface {
+	QueryRow(context.Context, string, ...interface{}) pgx.Row
+	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
+	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
+}
+
 // Internal grpcAuthService struct that implements the gRPC server interface
 type grpcAuthService struct {
 	pb.UnimplementedAuthServer
 
 	// Pool is a reference to the database that we can use for queries
-	pool *pgxpool.Pool
+	pool dbClient
+
+	tokenKey       []byte
+	accessTokenTTL time.Duration
+	sessionTTL     time.Duration
+	lockout        *lockout
+	now            func() time.Time
 }
 
-func newGrpcService() *grpcAuthService {
-	return &grpcAuthService{}
+func newGrpcService(config Config) *grpcAuthService {
+	return &grpcAuthService{
+		tokenKey:       config.TokenKey,
+		accessTokenTTL: config.AccessTokenTTL,
+		sessionTTL:     config.SessionTTL,
+		lockout:        newLockout(config),
+		now:            time.Now,
+	}
 }
 
 type userRow struct {
@@ -107,44 +258,71 @@ type userRow struct {
 
 // Verify checks a Input for authentication validity
 func (as *grpcAuthService) Verify(ctx context.Context, in *pb.VerifyRequest) (*pb.VerifyResponse, error) {
-	log.Printf("verify: id %v, start\n", in.Id)
+	if ok, reason := as.checkPassword(ctx, "verify", in.Id, in.Password); !ok {
+		return &pb.VerifyResponse{
+			State:  pb.State_DENY,
+			Reason: reason,
+		}, nil
+	}
+	// No errors from the query or the password comparison
+	return &pb.VerifyResponse{
+		State:  pb.State_ALLOW,
+		Scopes: AllScopes,
+	}, nil
+}
+
+// checkPassword says whether a user's password is right and they're active, for the RPC named rpc,
+// which it logs as. If not, it says why. Wrong passwords and unknown ids count as failures, and too
+// many shut the id and source out: see lockout.go.
+func (as *grpcAuthService) checkPassword(ctx context.Context, rpc, id, password string) (bool, pb.Reason) {
+	source := sourceFromContext(ctx)
+	logf(ctx, "%s: id %v, source %q, start\n", rpc, id, source)
+
+	// The password isn't even checked while shut out, so guesses get nowhere
+	if until, locked := as.lockout.check(id, source, as.now()); locked {
+		logf(ctx, "%s: id %v, source %q, locked out until %v, deny (%v)\n", rpc, id, source, until.Format(time.RFC3339), pb.Reason_LOCKED_OUT)
+		return false, pb.Reason_LOCKED_OUT
+	}
 
 	// Look for this user in the database
 	var row userRow
 	err := as.pool.QueryRow(ctx,
 		"SELECT id, password, status FROM public.user WHERE id = $1",
-		in.Id,
+		id,
 	).Scan(&row.id, &row.password, &row.status)
 	// Error can be no rows or a real error...
+	if err == pgx.ErrNoRows {
+		as.lockout.fail(id, source, as.now())
+		logf(ctx, "%s: id %v, deny (%v)\n", rpc, id, pb.Reason_UNKNOWN_USER)
+		return false, pb.Reason_UNKNOWN_USER
+	}
 	if err != nil {
-		// No rows is not an error that needs logging
-		if err != pgx.ErrNoRows {
-			log.Printf("verify: query error: %v\n", err)
-		}
-		log.Printf("verify: id %v, deny (query)\n", in.Id)
+		logf(ctx, "%s: query error: %v\n", rpc, err)
+		logf(ctx, "%s: id %v, deny (query)\n", rpc, id)
 		// ... either way, deny!
-		return &pb.VerifyResponse{
-			State: pb.State_DENY,
-		}, nil
+		return false, pb.Reason_NO_REASON
 	}
 
-	// bcrypt require us to compare the input to the hash directly
-	// https://auth0.com/blog/hashing-in-action-understanding-bcrypt/
-	err = bcrypt.CompareHashAndPassword([]byte(row.password), []byte(in.Password))
+	// bcrypt require us to compare the input to the hash directly: see password.go
+	err = comparePassword(row.password, password)
 	if err != nil {
 		// Mismatched hash and password is OK, but other errors need logging
 		if err != bcrypt.ErrMismatchedHashAndPassword {
-			log.Printf("verify: compare error: %v\n", err)
+			logf(ctx, "%s: compare error: %v\n", rpc, err)
 		}
-		log.Printf("verify: id %v, deny (password)\n", in.Id)
-		return &pb.VerifyResponse{
-			State: pb.State_DENY,
-		}, nil
+		as.lockout.fail(id, source, as.now())
+		logf(ctx, "%s: id %v, deny (%v)\n", rpc, id, pb.Reason_BAD_PASSWORD)
+		return false, pb.Reason_BAD_PASSWORD
 	}
 
-	log.Printf("verify: id %v, allow\n", in.Id)
-	// No errors from the query or the password comparison
-	return &pb.VerifyResponse{
-		State: pb.State_ALLOW,
-	}, nil
+	as.lockout.succeed(id)
+
+	// The status is only checked with the right password, so that it isn't given away to guessers
+	if reason := statusReason(row.status); reason != pb.Reason_NO_REASON {
+		logf(ctx, "%s: id %v, status %v, deny (%v)\n", rpc, id, row.status, reason)
+		return false, reason
+	}
+
+	logf(ctx, "%s: id %v, allow\n", rpc, id)
+	return true, pb.Reason_NO_REASON
 }
@@ -2,28 +2,37 @@ package auth
 
 import (
 	"context"
-	"fmt"
+	"flag"
 	"log"
 	"sync"
 	"testing"
 	"time"
 
 	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
-	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/config"
 	"github.com/jackc/pgx/v5"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// The tests use the same database as the service, configured from the environment
+func testDatabaseUrl() (string, error) {
+	db := config.DefaultDatabase()
+	if err := config.Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, &db); err != nil {
+		return "", err
+	}
+	return db.ConnString()
+}
+
 func TestRun(t *testing.T) {
-	passwd, err := util.ReadPasswd()
+	databaseUrl, err := testDatabaseUrl()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	config := Config{
 		Port:        8010,
-		DatabaseUrl: fmt.Sprintf("postgres://postgres:%s@postgres:5432/app", passwd),
+		DatabaseUrl: databaseUrl,
 		Log:         log.Default(),
 	}
 	as := New(config)
@@ -49,14 +58,14 @@ func TestRun(t *testing.T) {
 
 func TestSimpleVerifyDeny(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
-	passwd, err := util.ReadPasswd()
+	databaseUrl, err := testDatabaseUrl()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	config := Config{
 		Port:        8010,
-		DatabaseUrl: fmt.Sprintf("postgres://postgres:%s@postgres:5432/app", passwd),
+		DatabaseUrl: databaseUrl,
 		Log:         log.Default(),
 	}
 	as := New(config)
@@ -100,14 +109,14 @@ func TestSimpleVerifyDeny(t *testing.T) {
 func TestSimpleVerifyAllow(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	passwd, err := util.ReadPasswd()
+	databaseUrl, err := testDatabaseUrl()
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	config := Config{
 		Port:        8010,
-		DatabaseUrl: fmt.Sprintf("postgres://postgres:%s@postgres:5432/app", passwd),
+		DatabaseUrl: databaseUrl,
 		Log:         log.Default(),
 	}
 	as := New(config)
@@ -3,6 +3,7 @@ package cache
 import (
 	"crypto/md5"
 	"sync"
+	"time"
 )
 
 // This package provides a very simple cache. It's designed to hide the values of the keys because
@@ -15,20 +16,26 @@ import (
 // 	if v, ok := c.Get(k); ok {
 //		...
 // 	}
+//
+// Entries put with PutUntil are forgotten once they expire.
 
 type Key [16]byte
 
 type Entry[Value any] struct {
 	value *Value
+	// Zero for an entry that doesn't expire
+	expires time.Time
 }
 
 type Cache[Value any] struct {
 	entries *sync.Map
+	now     func() time.Time
 }
 
 func New[Value any]() *Cache[Value] {
 	return &Cache[Value]{
 		entries: &sync.Map{},
+		now:     time.Now,
 	}
 }
 
@@ -39,6 +46,10 @@ func (c *Cache[V]) Key(k string) Key {
 func (c *Cache[Value]) Get(k Key) (*Value, bool) {
 	if value, ok := c.entries.Load(k); ok {
 		if entry, ok := value.(Entry[Value]); ok {
+			if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+				c.entries.Delete(k)
+				return nil, false
+			}
 			return entry.value, true
 		}
 	}
@@ -50,3 +61,15 @@ func (c *Cache[Value]) Put(k Key, v *Value) {
 		value: v,
 	})
 }
+
+// PutUntil puts an entry that is forgotten at expires
+func (c *Cache[Value]) PutUntil(k Key, v *Value, expires time.Time) {
+	c.entries.Store(k, Entry[Value]{
+		value:   v,
+		expires: expires,
+	})
+}
+
+func (c *Cache[Value]) Delete(k Key) {
+	c.entries.Delete(k)
+}
@@ -1,6 +1,9 @@
 package cache
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 type TestValue string
 
@@ -28,3 +31,32 @@ func TestGet(t *testing.T) {
 		t.Fatalf("cache: expected %s, got %s", v, *gV)
 	}
 }
+
+func TestPutUntil(t *testing.T) {
+	c := New[TestValue]()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	k := c.Key("foo")
+	v := TestValue("entry")
+	c.PutUntil(k, &v, now.Add(time.Minute))
+
+	now = now.Add(59 * time.Second)
+	if _, ok := c.Get(k); !ok {
+		t.Fatalf("cache: get not ok before expiry")
+	}
+	now = now.Add(time.Second)
+	if _, ok := c.Get(k); ok {
+		t.Fatalf("cache: get ok after expiry")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	c := New[TestValue]()
+	k := c.Key("foo")
+	v := TestValue("entry")
+	c.Put(k, &v)
+	c.Delete(k)
+	if _, ok := c.Get(k); ok {
+		t.Fatalf("cache: get ok after delete")
+	}
+}
@@ -1,35 +1,141 @@
 package auth
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/cache"
 	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/requestidctx"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 type Client interface {
 	Close() error
 	Verify(ctx context.Context, id, passwd string) (*VerifyResult, error)
+	// Login verifies an id and password like Verify, and starts a session if they're allowed
+	Login(ctx context.Context, id, passwd string) (*Session, error)
+	// Refresh swaps a refresh token for a new session
+	Refresh(ctx context.Context, refreshToken string) (*Session, error)
+	// Revoke ends the session of an access or refresh token, and says whether the token was good
+	Revoke(ctx context.Context, token string) (bool, error)
+	// Introspect checks an access token or API key, and says whose it is in the result's Id
+	Introspect(ctx context.Context, accessToken string) (*VerifyResult, error)
+	// CreateApiKey makes an API key for a user with the Name, Scopes and Expires of key. It returns
+	// the new key's details, and the key itself, which can't be found out again.
+	CreateApiKey(ctx context.Context, userId string, key ApiKey) (*ApiKey, string, error)
+	// ListApiKeys lists a user's API keys
+	ListApiKeys(ctx context.Context, userId string) ([]ApiKey, error)
+	// RevokeApiKey deletes one of a user's API keys, and says whether they had it
+	RevokeApiKey(ctx context.Context, userId, keyId string) (bool, error)
+	// State is the state of the connection to the auth service
+	State() connectivity.State
 }
 
 type VerifyResult struct {
 	State string
+	// The user, for Introspect
+	Id string
+	// What the user may do: see AllScopes
+	Scopes []string
+	// The API key, if Introspect was given one
+	KeyId string
+	// Why the State is StateDeny, if the auth service could tell: one of the Reason constants. It's
+	// for logs, not to tell users, as it would help anyone guessing passwords.
+	Reason string
 }
 
+// ApiKey describes one of a user's API keys. Expires and LastUsed are zero for never.
+type ApiKey struct {
+	Id       string
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+// Session is the tokens of a session, from Login or Refresh. They're only set if the State is
+// StateAllow.
+type Session struct {
+	State        string
+	AccessToken  string
+	RefreshToken string
+	// How long until the access token expires
+	ExpiresIn time.Duration
+	// Why the State is StateDeny, like VerifyResult.Reason
+	Reason string
+}
+
+// User is a user's details, from the user management RPCs, which are for admins. Call them with a
+// context from WithAdminKey.
+type User struct {
+	Id       string
+	Status   string
+	Created  time.Time
+	Modified time.Time
+}
+
+// ErrUserNotFound is returned (wrapped) by the user management methods for a user who doesn't exist
+var ErrUserNotFound = errors.New("auth: user not found")
+
+// InvalidApiKeyError is returned (wrapped) by CreateApiKey when the auth service won't make the key,
+// with what was wrong with it, like ValidateApiKey's errors
+type InvalidApiKeyError struct {
+	Reason string
+}
+
+func (e *InvalidApiKeyError) Error() string { return e.Reason }
+
+// VerifyCacheTTL is how long the GrpcClient remembers an id and password that were allowed, so a
+// user who has been made inactive or locked can still use them for up to this long
+const VerifyCacheTTL = 30 * time.Second
+
+// IntrospectCacheTTL is how long the GrpcClient remembers an access token that was allowed, so a
+// revoked session's tokens are still accepted for up to this long
+const IntrospectCacheTTL = 30 * time.Second
+
 var (
 	StateDeny  = pb.State_name[int32(pb.State_DENY)]
 	StateAllow = pb.State_name[int32(pb.State_ALLOW)]
 )
 
+var (
+	ReasonNone        = pb.Reason_name[int32(pb.Reason_NO_REASON)]
+	ReasonUnknownUser = pb.Reason_name[int32(pb.Reason_UNKNOWN_USER)]
+	ReasonBadPassword = pb.Reason_name[int32(pb.Reason_BAD_PASSWORD)]
+	ReasonInactive    = pb.Reason_name[int32(pb.Reason_INACTIVE)]
+	ReasonLocked      = pb.Reason_name[int32(pb.Reason_LOCKED)]
+	ReasonLockedOut   = pb.Reason_name[int32(pb.Reason_LOCKED_OUT)]
+)
+
 // GrpcClient is meant to be used by other services to talk with the Auth service.
 type GrpcClient struct {
-	conn   *grpc.ClientConn
-	cancel context.CancelFunc
-	aC     pb.AuthClient
-	cache  *cache.Cache[VerifyResult]
+	conn    *grpc.ClientConn
+	cancel  context.CancelFunc
+	aC      pb.AuthClient
+	metrics *clientMetrics
+	// Verify results, for up to verifyTTL
+	cache     *cache.Cache[VerifyResult]
+	verifyTTL time.Duration
+	// Introspect results, for up to introspectTTL
+	tokens        *cache.Cache[VerifyResult]
+	introspectTTL time.Duration
 }
 
 // Create a new Client for the auth service.
@@ -38,6 +144,12 @@ func NewClient(ctx context.Context, target string) (*GrpcClient, error) {
 	return newClientWithOpts(ctx, target, defaultOpts()...)
 }
 
+// Create a new Client for an auth service that uses TLS, with a config from certs.Store.ClientConfig.
+// Call Close() to release resources associated with this Client.
+func NewTLSClient(ctx context.Context, target string, config *tls.Config) (*GrpcClient, error) {
+	return newClientWithOpts(ctx, target, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+}
+
 // Call Close() to release resources associated with this Client.
 func (c *GrpcClient) Close() error {
 	// We cancel the context in case the connection is still being formed...
@@ -46,36 +158,328 @@ func (c *GrpcClient) Close() error {
 	return c.conn.Close()
 }
 
+// State is the state of the connection to the auth service. An idle connection is told to connect,
+// so that it's ready by the time it's needed.
+func (c *GrpcClient) State() connectivity.State {
+	state := c.conn.GetState()
+	if state == connectivity.Idle {
+		c.conn.Connect()
+	}
+	return state
+}
+
 func (c *GrpcClient) Verify(ctx context.Context, id, passwd string) (*VerifyResult, error) {
+	ctx, span := tracing.Tracer("github.com/CodeYourFuture/immersive-go-course/buggy-app/auth").Start(ctx,
+		"auth.GrpcClient.Verify",
+		trace.WithAttributes(semconv.EnduserIDKey.String(id)),
+	)
+	defer span.End()
+
 	// Check the cache to see if we have this id/passwd combo already there
-	// If we do, return it so we don't contact the auth service twice
+	// If we do, return it so we don't contact the auth service twice. Only allowed combos are kept:
+	// the auth service needs to see every failure, to count them (see lockout.go).
 	cacheKey := c.cache.Key(fmt.Sprintf("%s:%s", id, passwd))
 	if v, ok := c.cache.Get(cacheKey); ok {
+		c.metrics.cache.WithLabelValues("hit").Inc()
+		span.SetAttributes(attribute.Bool("auth.cache_hit", true), attribute.String("auth.state", v.State))
 		return v, nil
 	}
+	c.metrics.cache.WithLabelValues("miss").Inc()
+	span.SetAttributes(attribute.Bool("auth.cache_hit", false))
 
 	// Call the auth service to check the id/password we've been given
-	res, err := c.aC.Verify(ctx, &pb.VerifyRequest{
+	res, err := c.aC.Verify(withRequestId(ctx), &pb.VerifyRequest{
 		Id:       id,
 		Password: passwd,
 	})
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to verify")
 		return nil, fmt.Errorf("failed to verify: %w", err)
 	}
 
 	// Looking good: turn this gRPC result into our output type
 	vR := &VerifyResult{
-		State: pb.State_name[int32(res.State)],
+		State:  pb.State_name[int32(res.State)],
+		Scopes: res.Scopes,
+		Reason: pb.Reason_name[int32(res.Reason)],
+	}
+	span.SetAttributes(attribute.String("auth.state", vR.State), attribute.String("auth.reason", vR.Reason))
+
+	// Remember this verify result for next time, if it's allowed. It's only for a while, so that
+	// changes to the user, like their status, catch up with them.
+	if res.State == pb.State_ALLOW {
+		c.cache.PutUntil(cacheKey, vR, time.Now().Add(c.verifyTTL))
+	}
+	return vR, nil
+}
+
+func (c *GrpcClient) Login(ctx context.Context, id, passwd string) (*Session, error) {
+	res, err := c.aC.Login(withRequestId(ctx), &pb.LoginRequest{
+		Id:       id,
+		Password: passwd,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to login: %w", err)
+	}
+	return newSession(res), nil
+}
+
+func (c *GrpcClient) Refresh(ctx context.Context, refreshToken string) (*Session, error) {
+	res, err := c.aC.Refresh(withRequestId(ctx), &pb.RefreshRequest{
+		RefreshToken: refreshToken,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh: %w", err)
+	}
+	return newSession(res), nil
+}
+
+func newSession(res *pb.SessionResponse) *Session {
+	return &Session{
+		State:        pb.State_name[int32(res.State)],
+		AccessToken:  res.AccessToken,
+		RefreshToken: res.RefreshToken,
+		ExpiresIn:    time.Duration(res.ExpiresIn) * time.Second,
+		Reason:       pb.Reason_name[int32(res.Reason)],
+	}
+}
+
+// Revoke ends a session. Its access tokens are forgotten by this client straight away, but other
+// clients may still accept them for up to IntrospectCacheTTL.
+func (c *GrpcClient) Revoke(ctx context.Context, token string) (bool, error) {
+	res, err := c.aC.Revoke(withRequestId(ctx), &pb.RevokeRequest{
+		Token: token,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke: %w", err)
 	}
+	c.tokens.Delete(c.tokens.Key(token))
+	return res.State == pb.State_ALLOW, nil
+}
 
-	// Remember this verify result for next time
-	c.cache.Put(cacheKey, vR)
+// Introspect checks an access token or API key with the auth service. Tokens that are allowed are
+// remembered until they expire, or for introspectTTL if that's sooner, so that most requests don't
+// need an RPC. Tokens that are denied aren't remembered, as there's no need to hurry turning them away.
+func (c *GrpcClient) Introspect(ctx context.Context, accessToken string) (*VerifyResult, error) {
+	ctx, span := tracing.Tracer("github.com/CodeYourFuture/immersive-go-course/buggy-app/auth").Start(ctx,
+		"auth.GrpcClient.Introspect",
+	)
+	defer span.End()
+
+	cacheKey := c.tokens.Key(accessToken)
+	if v, ok := c.tokens.Get(cacheKey); ok {
+		c.metrics.introspectCache.WithLabelValues("hit").Inc()
+		span.SetAttributes(attribute.Bool("auth.cache_hit", true), attribute.String("auth.state", v.State), semconv.EnduserIDKey.String(v.Id))
+		return v, nil
+	}
+	c.metrics.introspectCache.WithLabelValues("miss").Inc()
+	span.SetAttributes(attribute.Bool("auth.cache_hit", false))
+
+	res, err := c.aC.Introspect(withRequestId(ctx), &pb.IntrospectRequest{
+		AccessToken: accessToken,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to introspect")
+		return nil, fmt.Errorf("failed to introspect: %w", err)
+	}
+
+	vR := &VerifyResult{
+		State:  pb.State_name[int32(res.State)],
+		Id:     res.Id,
+		Scopes: res.Scopes,
+		KeyId:  res.KeyId,
+		Reason: pb.Reason_name[int32(res.Reason)],
+	}
+	span.SetAttributes(attribute.String("auth.state", vR.State), attribute.String("auth.reason", vR.Reason), semconv.EnduserIDKey.String(vR.Id))
+	// Only API keys can have no expiry, with an ExpiresIn of 0. A session token always has one, so
+	// one without any time left isn't cached at all.
+	ttl := time.Duration(res.ExpiresIn) * time.Second
+	if res.ExpiresIn == 0 && res.KeyId != "" || ttl > c.introspectTTL {
+		ttl = c.introspectTTL
+	}
+	if res.State == pb.State_ALLOW && ttl > 0 {
+		c.tokens.PutUntil(cacheKey, vR, time.Now().Add(ttl))
+	}
 	return vR, nil
 }
 
+func (c *GrpcClient) CreateApiKey(ctx context.Context, userId string, key ApiKey) (*ApiKey, string, error) {
+	req := &pb.CreateApiKeyRequest{
+		UserId: userId,
+		Name:   key.Name,
+		Scopes: key.Scopes,
+	}
+	if !key.Expires.IsZero() {
+		req.Expires = key.Expires.Unix()
+	}
+	res, err := c.aC.CreateApiKey(withRequestId(ctx), req)
+	if grpcstatus.Code(err) == grpccodes.InvalidArgument {
+		return nil, "", fmt.Errorf("failed to create api key: %w", &InvalidApiKeyError{Reason: grpcstatus.Convert(err).Message()})
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create api key: %w", err)
+	}
+	apiKey := newApiKey(res.ApiKey)
+	return &apiKey, res.Key, nil
+}
+
+func (c *GrpcClient) ListApiKeys(ctx context.Context, userId string) ([]ApiKey, error) {
+	res, err := c.aC.ListApiKeys(withRequestId(ctx), &pb.ListApiKeysRequest{
+		UserId: userId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	keys := make([]ApiKey, 0, len(res.ApiKeys))
+	for _, key := range res.ApiKeys {
+		keys = append(keys, newApiKey(key))
+	}
+	return keys, nil
+}
+
+// RevokeApiKey deletes an API key. This client doesn't know the key itself, so may still accept it
+// for up to IntrospectCacheTTL, as may other clients.
+func (c *GrpcClient) RevokeApiKey(ctx context.Context, userId, keyId string) (bool, error) {
+	res, err := c.aC.RevokeApiKey(withRequestId(ctx), &pb.RevokeApiKeyRequest{
+		UserId: userId,
+		Id:     keyId,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	return res.State == pb.State_ALLOW, nil
+}
+
+func newApiKey(key *pb.ApiKey) ApiKey {
+	return ApiKey{
+		Id:       key.Id,
+		Name:     key.Name,
+		Scopes:   key.Scopes,
+		Created:  unixTime(key.Created),
+		Expires:  unixTime(key.Expires),
+		LastUsed: unixTime(key.LastUsed),
+	}
+}
+
+// The time of some Unix seconds, where 0 is the zero time
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func (c *GrpcClient) CreateUser(ctx context.Context, password, status string) (*User, error) {
+	res, err := c.aC.CreateUser(withRequestId(ctx), &pb.CreateUserRequest{
+		Password: password,
+		Status:   status,
+	})
+	return userResult("create user", res, err)
+}
+
+func (c *GrpcClient) GetUser(ctx context.Context, id string) (*User, error) {
+	res, err := c.aC.GetUser(withRequestId(ctx), &pb.GetUserRequest{
+		Id: id,
+	})
+	return userResult("get user", res, err)
+}
+
+// SetPassword changes a user's password, and ends their sessions. Clients that have allowed the old
+// password may still accept it for up to VerifyCacheTTL, and their sessions' access tokens for up to
+// IntrospectCacheTTL.
+func (c *GrpcClient) SetPassword(ctx context.Context, id, password string) (*User, error) {
+	res, err := c.aC.SetPassword(withRequestId(ctx), &pb.SetPasswordRequest{
+		Id:       id,
+		Password: password,
+	})
+	return userResult("set password", res, err)
+}
+
+func (c *GrpcClient) SetStatus(ctx context.Context, id, status string) (*User, error) {
+	res, err := c.aC.SetStatus(withRequestId(ctx), &pb.SetStatusRequest{
+		Id:     id,
+		Status: status,
+	})
+	return userResult("set status", res, err)
+}
+
+// ListUsers lists a page of users. Pass the returned page token back for the next page: it's empty
+// after the last one.
+func (c *GrpcClient) ListUsers(ctx context.Context, pageSize int, pageToken string) ([]User, string, error) {
+	res, err := c.aC.ListUsers(withRequestId(ctx), &pb.ListUsersRequest{
+		PageSize:  int32(pageSize),
+		PageToken: pageToken,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list users: %w", err)
+	}
+	users := make([]User, 0, len(res.Users))
+	for _, user := range res.Users {
+		users = append(users, newUser(user))
+	}
+	return users, res.NextPageToken, nil
+}
+
+func (c *GrpcClient) DeleteUser(ctx context.Context, id string) error {
+	_, err := c.aC.DeleteUser(withRequestId(ctx), &pb.DeleteUserRequest{
+		Id: id,
+	})
+	if grpcstatus.Code(err) == grpccodes.NotFound {
+		return fmt.Errorf("failed to delete user: %w", ErrUserNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+// Unlock lifts the lockout of an id, a source, or both, after too many failures, and says whether
+// either was locked out
+func (c *GrpcClient) Unlock(ctx context.Context, id, source string) (bool, error) {
+	res, err := c.aC.Unlock(withRequestId(ctx), &pb.UnlockRequest{
+		Id:     id,
+		Source: source,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to unlock: %w", err)
+	}
+	return res.Locked, nil
+}
+
+// userResult turns the response of one of the RPCs for a single user into our output type
+func userResult(op string, res *pb.UserResponse, err error) (*User, error) {
+	if grpcstatus.Code(err) == grpccodes.NotFound {
+		return nil, fmt.Errorf("failed to %s: %w", op, ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", op, err)
+	}
+	user := newUser(res.User)
+	return &user, nil
+}
+
+func newUser(user *pb.User) User {
+	return User{
+		Id:       user.Id,
+		Status:   user.Status,
+		Created:  time.Unix(user.Created, 0),
+		Modified: time.Unix(user.Modified, 0),
+	}
+}
+
+// Pass on the ID of the request we're calling for, so it can be found in the auth service's logs
+func withRequestId(ctx context.Context) context.Context {
+	if requestId, ok := requestidctx.FromRequestIdContext(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestidctx.MetadataKey, requestId)
+	}
+	return ctx
+}
+
 func defaultOpts() []grpc.DialOption {
 	return []grpc.DialOption{
-		// TODO: insecure connection should move to TLS
+		// Without TLS: see NewTLSClient
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	}
 }
@@ -85,22 +489,33 @@ func newClientWithOpts(ctx context.Context, target string, opts ...grpc.DialOpti
 	// Wrapping the context WithCancel allows us to cancel the connection if the caller chooses to
 	// immediately Close() the Client.
 	ctx, cancel := context.WithCancel(ctx)
+	metrics := newClientMetrics()
+	opts = append(opts, grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor(), metrics.interceptor))
 	conn, err := grpc.DialContext(ctx, target, opts...)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
 	return &GrpcClient{
-		conn:   conn,
-		cancel: cancel,
-		aC:     pb.NewAuthClient(conn),
-		cache:  cache.New[VerifyResult](),
+		conn:    conn,
+		cancel:  cancel,
+		aC:      pb.NewAuthClient(conn),
+		metrics: metrics,
+
+		cache:     cache.New[VerifyResult](),
+		verifyTTL: VerifyCacheTTL,
+
+		tokens:        cache.New[VerifyResult](),
+		introspectTTL: IntrospectCacheTTL,
 	}, nil
 }
 
 // Use this in tests to Mock out the client
 type MockClient struct {
 	result *VerifyResult
+	// The API keys the mock has made, which it keeps for every user alike
+	keys []ApiKey
 }
 
 func NewMockClient(result *VerifyResult) *MockClient {
@@ -109,7 +524,86 @@ func NewMockClient(result *VerifyResult) *MockClient {
 	}
 }
 
-func (ac *MockClient) Close() error { return nil }
+func (ac *MockClient) Close() error              { return nil }
+func (ac *MockClient) State() connectivity.State { return connectivity.Ready }
 func (ac *MockClient) Verify(ctx context.Context, id, passwd string) (*VerifyResult, error) {
-	return ac.result, nil
+	return ac.verifyResult(), nil
+}
+
+// The result the mock was made with, with all the scopes if it's allowed and none were given
+func (ac *MockClient) verifyResult() *VerifyResult {
+	if ac.result.State != StateAllow || ac.result.Scopes != nil {
+		return ac.result
+	}
+	result := *ac.result
+	result.Scopes = AllScopes
+	return &result
+}
+
+// The mock's sessions always have the same tokens, and belong to MockUserId. Its API keys are all
+// MockApiKey.
+const (
+	MockAccessToken  = "mock-access-token"
+	MockRefreshToken = "mock-refresh-token"
+	MockUserId       = "mock-user"
+	MockApiKey       = "key.mock-key.secret"
+)
+
+func (ac *MockClient) Login(ctx context.Context, id, passwd string) (*Session, error) {
+	return ac.session(), nil
+}
+
+func (ac *MockClient) Refresh(ctx context.Context, refreshToken string) (*Session, error) {
+	return ac.session(), nil
+}
+
+func (ac *MockClient) session() *Session {
+	if ac.result.State != StateAllow {
+		return &Session{State: ac.result.State, Reason: ac.result.Reason}
+	}
+	return &Session{
+		State:        StateAllow,
+		AccessToken:  MockAccessToken,
+		RefreshToken: MockRefreshToken,
+		ExpiresIn:    DefaultAccessTokenTTL,
+	}
+}
+
+func (ac *MockClient) Revoke(ctx context.Context, token string) (bool, error) {
+	return ac.result.State == StateAllow, nil
+}
+
+func (ac *MockClient) Introspect(ctx context.Context, accessToken string) (*VerifyResult, error) {
+	if ac.result.State != StateAllow {
+		return ac.result, nil
+	}
+	result := *ac.verifyResult()
+	result.Id = MockUserId
+	return &result, nil
+}
+
+func (ac *MockClient) CreateApiKey(ctx context.Context, userId string, key ApiKey) (*ApiKey, string, error) {
+	apiKey := ApiKey{
+		Id:      fmt.Sprintf("mock-key-%d", len(ac.keys)+1),
+		Name:    key.Name,
+		Scopes:  key.Scopes,
+		Created: time.Now(),
+		Expires: key.Expires,
+	}
+	ac.keys = append(ac.keys, apiKey)
+	return &apiKey, MockApiKey, nil
+}
+
+func (ac *MockClient) ListApiKeys(ctx context.Context, userId string) ([]ApiKey, error) {
+	return append([]ApiKey{}, ac.keys...), nil
+}
+
+func (ac *MockClient) RevokeApiKey(ctx context.Context, userId, keyId string) (bool, error) {
+	for i, key := range ac.keys {
+		if key.Id == keyId {
+			ac.keys = append(ac.keys[:i], ac.keys[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
 }
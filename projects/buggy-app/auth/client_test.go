@@ -2,6 +2,8 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"sync"
@@ -9,7 +11,11 @@ import (
 	"time"
 
 	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/requestidctx"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // Internal grpcAuthService struct that implements the gRPC server interface
@@ -21,6 +27,15 @@ type mockGrpcAuthService struct {
 	err    error
 
 	Calls int
+	// The request ID in the context of the last call, if the server has the interceptor
+	RequestId string
+
+	// What Introspect says, and how many times it has been called
+	introspect      *pb.IntrospectResponse
+	IntrospectCalls int
+
+	// The users, for the user management RPCs
+	users map[string]*pb.User
 }
 
 func newMockGrpcService(result *pb.VerifyResponse, err error) *mockGrpcAuthService {
@@ -33,9 +48,75 @@ func newMockGrpcService(result *pb.VerifyResponse, err error) *mockGrpcAuthServi
 // Verify checks a Input for authentication validity
 func (as *mockGrpcAuthService) Verify(ctx context.Context, in *pb.VerifyRequest) (*pb.VerifyResponse, error) {
 	as.Calls += 1
+	as.RequestId, _ = requestidctx.FromRequestIdContext(ctx)
 	return as.result, as.err
 }
 
+func (as *mockGrpcAuthService) Introspect(ctx context.Context, in *pb.IntrospectRequest) (*pb.IntrospectResponse, error) {
+	as.IntrospectCalls += 1
+	return as.introspect, as.err
+}
+
+func (as *mockGrpcAuthService) Revoke(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+	return &pb.RevokeResponse{State: pb.State_ALLOW}, as.err
+}
+
+func (as *mockGrpcAuthService) CreateUser(ctx context.Context, in *pb.CreateUserRequest) (*pb.UserResponse, error) {
+	if as.users == nil {
+		as.users = map[string]*pb.User{}
+	}
+	user := &pb.User{Id: fmt.Sprintf("user%d", len(as.users)+1), Status: in.Status, Created: time.Now().Unix()}
+	as.users[user.Id] = user
+	return &pb.UserResponse{User: user}, nil
+}
+
+func (as *mockGrpcAuthService) GetUser(ctx context.Context, in *pb.GetUserRequest) (*pb.UserResponse, error) {
+	user, ok := as.users[in.Id]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return &pb.UserResponse{User: user}, nil
+}
+
+func (as *mockGrpcAuthService) SetStatus(ctx context.Context, in *pb.SetStatusRequest) (*pb.UserResponse, error) {
+	user, ok := as.users[in.Id]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	user.Status = in.Status
+	return &pb.UserResponse{User: user}, nil
+}
+
+func (as *mockGrpcAuthService) ListUsers(ctx context.Context, in *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	res := &pb.ListUsersResponse{}
+	for _, user := range as.users {
+		res.Users = append(res.Users, user)
+	}
+	return res, nil
+}
+
+func (as *mockGrpcAuthService) CreateApiKey(ctx context.Context, in *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	if err := ValidateApiKey(ApiKey{Name: in.Name, Scopes: in.Scopes}, time.Now()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.CreateApiKeyResponse{
+		ApiKey: &pb.ApiKey{Id: "key1", Name: in.Name, Scopes: in.Scopes},
+		Key:    formatApiKey("key1", "secret"),
+	}, nil
+}
+
+func (as *mockGrpcAuthService) Unlock(ctx context.Context, in *pb.UnlockRequest) (*pb.UnlockResponse, error) {
+	return &pb.UnlockResponse{Locked: in.Id != ""}, nil
+}
+
+func (as *mockGrpcAuthService) DeleteUser(ctx context.Context, in *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	if _, ok := as.users[in.Id]; !ok {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	delete(as.users, in.Id)
+	return &pb.DeleteUserResponse{}, nil
+}
+
 func TestClientCreate(t *testing.T) {
 	config := Config{
 		Port: 8010,
@@ -291,3 +372,347 @@ func TestClientVerifyCacheResult(t *testing.T) {
 		t.Fatal(runErr)
 	}
 }
+
+func TestClientVerifyCacheTTL(t *testing.T) {
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	mockService := newMockGrpcService(&pb.VerifyResponse{
+		State: pb.State_ALLOW,
+	}, nil)
+
+	// Set up and register the server
+	grpcServer := grpc.NewServer()
+	pb.RegisterAuthServer(grpcServer, mockService)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+	defer done()
+
+	client, err := NewClient(ctx, listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.verifyTTL = 100 * time.Millisecond
+
+	verify := func(expected string, expectedCalls int) {
+		t.Helper()
+		res, err := client.Verify(ctx, "example", "example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != expected {
+			t.Fatalf("expected %s, got %+v", expected, res)
+		}
+		if mockService.Calls != expectedCalls {
+			t.Fatalf("expected %d calls to the service, got %d", expectedCalls, mockService.Calls)
+		}
+	}
+
+	verify(StateAllow, 1)
+	// The user is made inactive, which the cache doesn't know about...
+	mockService.result = &pb.VerifyResponse{State: pb.State_DENY, Reason: pb.Reason_INACTIVE}
+	verify(StateAllow, 1)
+	// ... until the TTL is up
+	time.Sleep(client.verifyTTL)
+	verify(StateDeny, 2)
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestClientVerifyRequestId(t *testing.T) {
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	mockService := newMockGrpcService(&pb.VerifyResponse{
+		State: pb.State_ALLOW,
+	}, nil)
+
+	// Set up and register the server, with the interceptor the real one has
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(requestIdInterceptor))
+	pb.RegisterAuthServer(grpcServer, mockService)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+
+	client, err := NewClient(ctx, listen)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	// The request ID is passed on from the caller's context
+	requestId := "3f2c0e5d9a8b4c7e1f6a2b3c4d5e6f70"
+	_, err = client.Verify(requestidctx.NewRequestIdContext(ctx, requestId), "example", "example")
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+	if mockService.RequestId != requestId {
+		done()
+		t.Fatalf("request id: expected %s, got %s", requestId, mockService.RequestId)
+	}
+
+	// Without one, the server makes one up, so its log lines can still be matched with each other
+	_, err = client.Verify(ctx, "other", "other")
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+	if mockService.RequestId == "" || mockService.RequestId == requestId {
+		done()
+		t.Fatalf("request id: expected a new one, got %q", mockService.RequestId)
+	}
+
+	err = client.Close()
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestClientIntrospectCache(t *testing.T) {
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	mockService := newMockGrpcService(nil, nil)
+	mockService.introspect = &pb.IntrospectResponse{State: pb.State_ALLOW, Id: "abc123", ExpiresIn: 600}
+
+	// Set up and register the server
+	grpcServer := grpc.NewServer()
+	pb.RegisterAuthServer(grpcServer, mockService)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+	defer done()
+
+	client, err := NewClient(ctx, listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.introspectTTL = 100 * time.Millisecond
+
+	introspect := func(expectedCalls int) {
+		t.Helper()
+		res, err := client.Introspect(ctx, "token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != mockService.introspect.State.String() || res.Id != mockService.introspect.Id {
+			t.Fatalf("expected %v, got %+v", mockService.introspect, res)
+		}
+		if mockService.IntrospectCalls != expectedCalls {
+			t.Fatalf("expected %d calls to the service, got %d", expectedCalls, mockService.IntrospectCalls)
+		}
+	}
+
+	// The second call is answered from the cache...
+	introspect(1)
+	introspect(1)
+	// ... until the TTL is up, however long the token has left, so a revoked session isn't allowed for long
+	time.Sleep(client.introspectTTL)
+	introspect(2)
+
+	// Revoking through this client forgets the token straight away
+	if _, err := client.Revoke(ctx, "token"); err != nil {
+		t.Fatal(err)
+	}
+	introspect(3)
+
+	// A session token without an expiry isn't taken to last forever, so it isn't cached...
+	mockService.introspect = &pb.IntrospectResponse{State: pb.State_ALLOW, Id: "abc123"}
+	client.tokens.Delete(client.tokens.Key("token"))
+	introspect(4)
+	introspect(5)
+	// ... but an API key without one is
+	mockService.introspect = &pb.IntrospectResponse{State: pb.State_ALLOW, Id: "abc123", KeyId: "key1"}
+	introspect(6)
+	introspect(6)
+
+	// Denials aren't cached
+	mockService.introspect = &pb.IntrospectResponse{State: pb.State_DENY}
+	client.tokens.Delete(client.tokens.Key("token"))
+	introspect(7)
+	introspect(8)
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestClientUsers(t *testing.T) {
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	mockService := newMockGrpcService(&pb.VerifyResponse{
+		State: pb.State_ALLOW,
+	}, nil)
+	adminKey := []byte("0123456789abcdef0123456789abcdef")
+
+	// Set up and register the server, with the interceptor the real one has
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(adminInterceptor(adminKey)))
+	pb.RegisterAuthServer(grpcServer, mockService)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+	defer done()
+
+	client, err := NewClient(ctx, listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Only callers with the admin key can manage users...
+	if _, err := client.CreateUser(ctx, "banana", StatusActive); status.Code(errors.Unwrap(err)) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a key, got %v", err)
+	}
+	wrongCtx := WithAdminKey(ctx, []byte("fedcba9876543210fedcba9876543210"))
+	if _, err := client.CreateUser(wrongCtx, "banana", StatusActive); status.Code(errors.Unwrap(err)) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied with the wrong key, got %v", err)
+	}
+	if len(mockService.users) != 0 {
+		t.Fatalf("expected no users to be created, got %d", len(mockService.users))
+	}
+	// ... but anyone can Verify
+	if _, err := client.Verify(ctx, "example", "example"); err != nil {
+		t.Fatal(err)
+	}
+
+	adminCtx := WithAdminKey(ctx, adminKey)
+	user, err := client.CreateUser(adminCtx, "banana", StatusActive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id == "" || user.Status != StatusActive {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	user, err = client.SetStatus(adminCtx, user.Id, StatusInactive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Status != StatusInactive {
+		t.Fatalf("expected the user to be inactive, got %+v", user)
+	}
+	users, next, err := client.ListUsers(adminCtx, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Id != user.Id || next != "" {
+		t.Fatalf("expected just %s, got %+v and next page %q", user.Id, users, next)
+	}
+	if locked, err := client.Unlock(adminCtx, user.Id, ""); err != nil || !locked {
+		t.Fatalf("expected to unlock %s, got %v, %v", user.Id, locked, err)
+	}
+	if _, err := client.Unlock(ctx, user.Id, ""); status.Code(errors.Unwrap(err)) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated unlocking without a key, got %v", err)
+	}
+	if err := client.DeleteUser(adminCtx, user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing users are an error callers can check for
+	if _, err := client.GetUser(adminCtx, user.Id); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if err := client.DeleteUser(adminCtx, user.Id); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestAdminInterceptorWithoutKey(t *testing.T) {
+	interceptor := adminInterceptor(nil)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer anything"))
+
+	// Without an admin key, nobody can manage users
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/service.Auth/CreateUser"}, handler)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	if res, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/service.Auth/Verify"}, handler); err != nil || res != "ok" {
+		t.Fatalf("expected Verify to be let through, got %v, %v", res, err)
+	}
+}
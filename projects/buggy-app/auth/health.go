@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// The auth service implements the standard gRPC health service
+// (https://github.com/grpc/grpc/blob/master/doc/health-checking.md), for the server as a whole ("")
+// and for the Auth service. Both are SERVING while the database answers pings, and NOT_SERVING
+// before it first does, whenever it stops, and once the server starts shutting down.
+
+// How often the database is pinged
+const healthCheckInterval = 5 * time.Second
+
+// How long the database has to answer a ping
+const healthCheckTimeout = 2 * time.Second
+
+// pinger is anything that can be pinged, like a *pgxpool.Pool
+type pinger interface {
+	Ping(context.Context) error
+}
+
+// Every service the health server reports on
+var healthServices = []string{"", pb.Auth_ServiceDesc.ServiceName}
+
+func setHealth(hs *health.Server, status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range healthServices {
+		hs.SetServingStatus(service, status)
+	}
+}
+
+// watchHealth pings the database every interval until the context is done, and sets the health
+// status to match
+func (as *Service) watchHealth(ctx context.Context, db pinger, interval time.Duration) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	setHealth(as.health, status)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := db.Ping(pingCtx)
+		cancel()
+
+		next := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		// Only log changes, or there'd be a line every interval
+		if next != status {
+			if err != nil {
+				as.config.Log.Printf("auth service: health: %v: %v", next, err)
+			} else {
+				as.config.Log.Printf("auth service: health: %v", next)
+			}
+			status = next
+			setHealth(as.health, status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakePinger answers pings with whatever err is at the time
+type fakePinger struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (p *fakePinger) Ping(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *fakePinger) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// Wait for the health of every service to become status, or fail
+func waitForHealth(t *testing.T, as *Service, status healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		ok := true
+		for _, service := range healthServices {
+			res, err := as.health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok = ok && res.Status == status
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health did not become %v", status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchHealth(t *testing.T) {
+	as := New(Config{
+		Log: log.Default(),
+	})
+	// Nothing is served before the database has been checked
+	waitForHealth(t, as, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	db := &fakePinger{}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		as.watchHealth(ctx, db, 10*time.Millisecond)
+	}()
+
+	waitForHealth(t, as, healthpb.HealthCheckResponse_SERVING)
+
+	db.setErr(errors.New("dial tcp 10.0.0.5:5432: connection refused"))
+	waitForHealth(t, as, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	db.setErr(nil)
+	waitForHealth(t, as, healthpb.HealthCheckResponse_SERVING)
+
+	// Shutting down wins, even though the database is still there
+	as.health.Shutdown()
+	waitForHealth(t, as, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestRunHealthWithoutDatabase(t *testing.T) {
+	as := New(Config{
+		Port: 8010,
+		// Nothing is listening here
+		DatabaseUrl: "postgres://postgres@localhost:1/app?connect_timeout=1",
+		Log:         log.Default(),
+	})
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = as.Run(ctx)
+	}()
+
+	// The server is up, but says it isn't serving
+	dialCtx, dialCancel := context.WithTimeout(ctx, 3*time.Second)
+	defer dialCancel()
+	conn, err := grpc.DialContext(dialCtx, "localhost:8010",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		cancel()
+		wg.Wait()
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "service.Auth"})
+	if err != nil {
+		cancel()
+		wg.Wait()
+		t.Fatal(err)
+	}
+	if res.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		cancel()
+		wg.Wait()
+		t.Fatalf("expected NOT_SERVING, got %v", res.Status)
+	}
+
+	cancel()
+	wg.Wait()
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	waitForHealth(t, as, healthpb.HealthCheckResponse_NOT_SERVING)
+}
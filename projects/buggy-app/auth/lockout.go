@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Guessing passwords is slowed down, then stopped, by counting failures: wrong passwords and unknown
+// ids. They're counted per user id, and per source, which is where the attempt came from: the API
+// passes on the client's IP with WithSource. A failure shuts out its id and source for a back-off
+// delay that doubles with each failure in the window, and MaxFailures of them in the window lock
+// the id out for LockoutDuration (MaxSourceFailures for a source, which may be shared by many
+// users). While shut out, Verify and Login deny with LOCKED_OUT without looking at the password, so
+// guessing gets nowhere. An admin can lift it early with Unlock.
+//
+// The failures are only kept in memory, so each auth server counts its own, and forgets them when
+// it restarts. Clients only ask again about a password they've allowed once it drops out of their
+// cache, so a lockout takes up to VerifyCacheTTL to reach them. Denials aren't cached, so Unlock
+// takes effect straight away.
+
+const (
+	// How many failures for an id in FailureWindow lock it out, unless Config.MaxFailures says
+	// otherwise
+	DefaultMaxFailures = 10
+	// How many failures from a source in FailureWindow lock it out, unless
+	// Config.MaxSourceFailures says otherwise
+	DefaultMaxSourceFailures = 50
+	// How long failures are counted for, unless Config.FailureWindow says otherwise
+	DefaultFailureWindow = 15 * time.Minute
+	// How long a lockout lasts, unless Config.LockoutDuration says otherwise
+	DefaultLockoutDuration = 15 * time.Minute
+	// The back-off after the first failure, and the longest one, unless Config.BackoffBase and
+	// Config.BackoffMax say otherwise
+	DefaultBackoffBase = time.Second
+	DefaultBackoffMax  = time.Minute
+)
+
+// The metadata that carries the source of a Verify or Login
+const sourceMetadataKey = "auth-source"
+
+// WithSource adds where a Verify or Login comes from, like the client's IP, to the calls made with a
+// context, so that failures from it are counted together
+func WithSource(ctx context.Context, source string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, sourceMetadataKey, source)
+}
+
+// The source from the call's metadata, or empty if there isn't one, in which case only the id's
+// failures are counted
+func sourceFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(sourceMetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// The failures of an id or a source in the current window
+type failures struct {
+	count int
+	// When the window started, with the first failure
+	first time.Time
+	// When the back-off or lockout of the last failure ends
+	until time.Time
+}
+
+// lockout counts failures by key, which is an id or a source with a prefix to tell them apart.
+// Failures are kept until their window is over and they aren't shutting anything out, and swept
+// away once in a while.
+type lockout struct {
+	maxFailures       int
+	maxSourceFailures int
+	window            time.Duration
+	duration          time.Duration
+	backoffBase       time.Duration
+	backoffMax        time.Duration
+
+	mu        sync.Mutex
+	failures  map[string]*failures
+	lastSweep time.Time
+}
+
+func newLockout(config Config) *lockout {
+	l := &lockout{
+		maxFailures:       config.MaxFailures,
+		maxSourceFailures: config.MaxSourceFailures,
+		window:            config.FailureWindow,
+		duration:          config.LockoutDuration,
+		backoffBase:       config.BackoffBase,
+		backoffMax:        config.BackoffMax,
+		failures:          map[string]*failures{},
+	}
+	if l.maxFailures == 0 {
+		l.maxFailures = DefaultMaxFailures
+	}
+	if l.maxSourceFailures == 0 {
+		l.maxSourceFailures = DefaultMaxSourceFailures
+	}
+	if l.window == 0 {
+		l.window = DefaultFailureWindow
+	}
+	if l.duration == 0 {
+		l.duration = DefaultLockoutDuration
+	}
+	if l.backoffBase == 0 {
+		l.backoffBase = DefaultBackoffBase
+	}
+	if l.backoffMax == 0 {
+		l.backoffMax = DefaultBackoffMax
+	}
+	return l
+}
+
+func idKey(id string) string         { return "id:" + id }
+func sourceKey(source string) string { return "source:" + source }
+
+// check says whether the id or the source is shut out at now, and if so until when
+func (l *lockout) check(id, source string, now time.Time) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var until time.Time
+	for _, key := range l.keys(id, source) {
+		if f, ok := l.failures[key]; ok && now.Before(f.until) && f.until.After(until) {
+			until = f.until
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// fail counts a failure for the id and the source
+func (l *lockout) fail(id, source string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	l.failKey(idKey(id), l.maxFailures, now)
+	if source != "" {
+		l.failKey(sourceKey(source), l.maxSourceFailures, now)
+	}
+}
+
+func (l *lockout) failKey(key string, max int, now time.Time) {
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.first) >= l.window {
+		f = &failures{first: now}
+		l.failures[key] = f
+	}
+	f.count++
+	if f.count >= max {
+		f.until = now.Add(l.duration)
+	} else {
+		f.until = now.Add(l.backoff(f.count))
+	}
+}
+
+// The back-off after count failures: the base, doubled for each failure after the first
+func (l *lockout) backoff(count int) time.Duration {
+	delay := l.backoffBase
+	for i := 1; i < count && delay < l.backoffMax; i++ {
+		delay *= 2
+	}
+	if delay > l.backoffMax {
+		delay = l.backoffMax
+	}
+	return delay
+}
+
+// succeed forgets the id's failures, once its password has been right. The source's are kept, as a
+// guesser may know one password and be after others.
+func (l *lockout) succeed(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, idKey(id))
+}
+
+// unlock forgets the failures of the id and the source, whichever are given, and says whether
+// either was shut out
+func (l *lockout) unlock(id, source string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	locked := false
+	for _, key := range l.keys(id, source) {
+		if f, ok := l.failures[key]; ok {
+			locked = locked || now.Before(f.until)
+			delete(l.failures, key)
+		}
+	}
+	return locked
+}
+
+// The keys of the id and the source, leaving out either that's empty
+func (l *lockout) keys(id, source string) []string {
+	var keys []string
+	if id != "" {
+		keys = append(keys, idKey(id))
+	}
+	if source != "" {
+		keys = append(keys, sourceKey(source))
+	}
+	return keys
+}
+
+// Forget the failures whose window is over and that aren't shutting anything out. This runs at most
+// once per window.
+func (l *lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for key, f := range l.failures {
+		if now.Sub(f.first) >= l.window && !now.Before(f.until) {
+			delete(l.failures, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Unlock lifts the back-off or lockout of an id, a source, or both, and forgets their failures. It
+// doesn't change a user's status: a user whose status is locked stays locked.
+func (as *grpcAuthService) Unlock(ctx context.Context, in *pb.UnlockRequest) (*pb.UnlockResponse, error) {
+	if in.Id == "" && in.Source == "" {
+		return nil, status.Error(codes.InvalidArgument, "an id or a source is required")
+	}
+	locked := as.lockout.unlock(in.Id, in.Source, as.now())
+	logf(ctx, "unlock: id %q, source %q, locked %v\n", in.Id, in.Source, locked)
+	return &pb.UnlockResponse{
+		Locked: locked,
+	}, nil
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	l := newLockout(Config{
+		MaxFailures: 5,
+		BackoffBase: time.Second,
+		BackoffMax:  4 * time.Second,
+	})
+
+	// The back-off doubles with each failure, up to the max
+	now := time.Now()
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		l.fail("abc123", "", now)
+		until, locked := l.check("abc123", "", now)
+		if !locked || until.Sub(now) != expected {
+			t.Fatalf("expected a back-off of %v, got %v", expected, until.Sub(now))
+		}
+		now = until
+		if _, locked := l.check("abc123", "", now); locked {
+			t.Fatalf("expected the back-off to be over at %v", now)
+		}
+	}
+
+	// The fifth failure locks the id out
+	l.fail("abc123", "", now)
+	if until, locked := l.check("abc123", "", now); !locked || until.Sub(now) != DefaultLockoutDuration {
+		t.Fatalf("expected a lockout of %v, got %v", DefaultLockoutDuration, until.Sub(now))
+	}
+	// Other ids are fine
+	if _, locked := l.check("def456", "", now); locked {
+		t.Fatalf("expected def456 not to be locked out")
+	}
+}
+
+func TestLockoutWindow(t *testing.T) {
+	l := newLockout(Config{
+		MaxFailures:   2,
+		FailureWindow: time.Minute,
+	})
+
+	// Failures a window apart aren't counted together
+	now := time.Now()
+	l.fail("abc123", "", now)
+	now = now.Add(time.Minute)
+	l.fail("abc123", "", now)
+	if until, _ := l.check("abc123", "", now); until.Sub(now) != DefaultBackoffBase {
+		t.Fatalf("expected a back-off of %v, got %v", DefaultBackoffBase, until.Sub(now))
+	}
+	l.fail("abc123", "", now)
+	if until, _ := l.check("abc123", "", now); until.Sub(now) != DefaultLockoutDuration {
+		t.Fatalf("expected a lockout of %v, got %v", DefaultLockoutDuration, until.Sub(now))
+	}
+
+	// Once the window and the lockout are over, the failures are swept away
+	now = now.Add(DefaultLockoutDuration)
+	l.fail("def456", "", now)
+	if _, ok := l.failures[idKey("abc123")]; ok {
+		t.Fatalf("expected abc123's failures to be swept away")
+	}
+}
+
+func TestLockoutSource(t *testing.T) {
+	l := newLockout(Config{
+		MaxFailures:       10,
+		MaxSourceFailures: 3,
+	})
+
+	// Guessing a different id each time is still counted against the source...
+	now := time.Now()
+	for _, id := range []string{"a", "b", "c"} {
+		l.fail(id, "10.0.0.1", now)
+	}
+	if until, locked := l.check("d", "10.0.0.1", now); !locked || until.Sub(now) != DefaultLockoutDuration {
+		t.Fatalf("expected the source to be locked out for %v, got %v", DefaultLockoutDuration, until.Sub(now))
+	}
+	// ... but not other sources
+	if _, locked := l.check("d", "10.0.0.2", now); locked {
+		t.Fatalf("expected another source not to be locked out")
+	}
+
+	// Getting a password right forgets the id's failures, not the source's
+	l.succeed("a")
+	if _, locked := l.check("a", "", now); locked {
+		t.Fatalf("expected a to be forgiven")
+	}
+	if _, locked := l.check("", "10.0.0.1", now); !locked {
+		t.Fatalf("expected the source to still be locked out")
+	}
+
+	if !l.unlock("", "10.0.0.1", now) {
+		t.Fatalf("expected the source to have been locked out")
+	}
+	if _, locked := l.check("", "10.0.0.1", now); locked {
+		t.Fatalf("expected the source to be unlocked")
+	}
+	if l.unlock("", "10.0.0.1", now) {
+		t.Fatalf("expected the source not to be locked out any more")
+	}
+}
+
+func TestVerifyLockedOut(t *testing.T) {
+	now := time.Now()
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+	as.lockout = newLockout(Config{MaxFailures: 2})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(sourceMetadataKey, "10.0.0.1"))
+	verify := func(password string, expected pb.Reason) {
+		t.Helper()
+		res, err := as.Verify(ctx, &pb.VerifyRequest{Id: "abc123", Password: password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Reason != expected {
+			t.Fatalf("expected %v, got %+v", expected, res)
+		}
+	}
+
+	// The first wrong password backs off, and the second locks the id out...
+	expectUser(mock, "abc123", "banana")
+	verify("apple", pb.Reason_BAD_PASSWORD)
+	verify("banana", pb.Reason_LOCKED_OUT)
+	now = now.Add(DefaultBackoffBase)
+	as.now = func() time.Time { return now }
+	expectUser(mock, "abc123", "banana")
+	verify("cherry", pb.Reason_BAD_PASSWORD)
+	// ... so the right password is turned away without a query
+	verify("banana", pb.Reason_LOCKED_OUT)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// The source is backing off too, so it needs unlocking as well
+	res, err := as.Unlock(context.Background(), &pb.UnlockRequest{Id: "abc123", Source: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Locked {
+		t.Fatalf("expected abc123 to have been locked out")
+	}
+	expectUser(mock, "abc123", "banana")
+	verify("banana", pb.Reason_NO_REASON)
+
+	if _, err := as.Unlock(context.Background(), &pb.UnlockRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument without an id or source, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithSource(t *testing.T) {
+	// What the client sends is what the server sees
+	md, _ := metadata.FromOutgoingContext(WithSource(context.Background(), "10.0.0.1"))
+	if source := sourceFromContext(metadata.NewIncomingContext(context.Background(), md)); source != "10.0.0.1" {
+		t.Fatalf("expected the source 10.0.0.1, got %q", source)
+	}
+	if source := sourceFromContext(context.Background()); source != "" {
+		t.Fatalf("expected no source, got %q", source)
+	}
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Both ends of the auth service have Prometheus metrics. The server's are served in the text format
+// on Config.MetricsPort:
+//
+//	grpc_server_handled_total{grpc_service, grpc_method, grpc_code}  RPCs handled
+//	grpc_server_handling_seconds{grpc_service, grpc_method}          how long they took
+//	auth_verify_total{state}                                          Verify results, ALLOW or DENY
+//	auth_denied_total{grpc_method, reason}                            denials with a reason, like BAD_PASSWORD
+//	auth_db_pool_*                                                    the database connection pool
+//
+// The GrpcClient is a prometheus.Collector, so a service using it can register it with its own
+// metrics:
+//
+//	grpc_client_handled_total{grpc_service, grpc_method, grpc_code}  RPCs made
+//	grpc_client_handling_seconds{grpc_service, grpc_method}          how long they took
+//	auth_client_verify_total{state}                                   Verify results from the service
+//	auth_client_cache_total{result}                                   Verify calls answered from the cache (hit) or not (miss)
+//	auth_client_introspect_cache_total{result}                        Introspect calls answered from the cache (hit) or not (miss)
+
+// rpcMetrics count and time RPCs, from one end or the other
+type rpcMetrics struct {
+	handled  *prometheus.CounterVec
+	handling *prometheus.HistogramVec
+	verify   *prometheus.CounterVec
+}
+
+func newRpcMetrics(side string, verify prometheus.CounterOpts) *rpcMetrics {
+	return &rpcMetrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_" + side + "_handled_total",
+			Help: "RPCs completed, by service, method and status code.",
+		}, []string{"grpc_service", "grpc_method", "grpc_code"}),
+		handling: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_" + side + "_handling_seconds",
+			Help:    "How long RPCs took to complete, by service and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_service", "grpc_method"}),
+		verify: prometheus.NewCounterVec(verify, []string{"state"}),
+	}
+}
+
+func (m *rpcMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.handled, m.handling, m.verify}
+}
+
+// Record an RPC that has finished. res is the response, which for Verify says whether it allowed.
+func (m *rpcMetrics) observe(fullMethod string, res interface{}, err error, start time.Time) {
+	service, method := splitMethod(fullMethod)
+	m.handled.WithLabelValues(service, method, status.Code(err).String()).Inc()
+	m.handling.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+	if res, ok := res.(*pb.VerifyResponse); ok && err == nil {
+		m.verify.WithLabelValues(res.State.String()).Inc()
+	}
+}
+
+// Split a full gRPC method name, like /service.Auth/Verify, into its service and method
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+// serverMetrics are the auth service's own metrics, in a registry of their own
+type serverMetrics struct {
+	*rpcMetrics
+	denied   *prometheus.CounterVec
+	registry *prometheus.Registry
+}
+
+// A response that can deny, and say why
+type reasonResponse interface {
+	GetState() pb.State
+	GetReason() pb.Reason
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		rpcMetrics: newRpcMetrics("server", prometheus.CounterOpts{
+			Name: "auth_verify_total",
+			Help: "Verify results, by state.",
+		}),
+		denied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_denied_total",
+			Help: "Requests denied, by method and reason. Denials without a reason aren't counted.",
+		}, []string{"grpc_method", "reason"}),
+		registry: prometheus.NewRegistry(),
+	}
+	m.registry.MustRegister(m.collectors()...)
+	m.registry.MustRegister(m.denied)
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// interceptor runs around every RPC the server handles, to record it
+func (m *serverMetrics) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	res, err := handler(ctx, req)
+	m.observe(info.FullMethod, res, err, start)
+	if res, ok := res.(reasonResponse); ok && err == nil && res.GetState() == pb.State_DENY && res.GetReason() != pb.Reason_NO_REASON {
+		_, method := splitMethod(info.FullMethod)
+		m.denied.WithLabelValues(method, res.GetReason().String()).Inc()
+	}
+	return res, err
+}
+
+func (m *serverMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// clientMetrics are the GrpcClient's metrics, collected through it
+type clientMetrics struct {
+	*rpcMetrics
+	cache           *prometheus.CounterVec
+	introspectCache *prometheus.CounterVec
+}
+
+func newClientMetrics() *clientMetrics {
+	return &clientMetrics{
+		rpcMetrics: newRpcMetrics("client", prometheus.CounterOpts{
+			Name: "auth_client_verify_total",
+			Help: "Verify results from the auth service, by state. Results from the cache aren't counted.",
+		}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_client_cache_total",
+			Help: "Verify calls answered from the cache (hit) or by the auth service (miss).",
+		}, []string{"result"}),
+		introspectCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_client_introspect_cache_total",
+			Help: "Introspect calls answered from the cache (hit) or by the auth service (miss).",
+		}, []string{"result"}),
+	}
+}
+
+func (m *clientMetrics) collectors() []prometheus.Collector {
+	return append(m.rpcMetrics.collectors(), m.cache, m.introspectCache)
+}
+
+// interceptor runs around every RPC the client makes, to record it
+func (m *clientMetrics) interceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	m.observe(method, reply, err, start)
+	return err
+}
+
+func (c *GrpcClient) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.metrics.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+func (c *GrpcClient) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.metrics.collectors() {
+		collector.Collect(ch)
+	}
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestSplitMethod(t *testing.T) {
+	service, method := splitMethod("/service.Auth/Verify")
+	if service != "service.Auth" || method != "Verify" {
+		t.Fatalf("expected service.Auth and Verify, got %s and %s", service, method)
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	mockService := newMockGrpcService(&pb.VerifyResponse{
+		State: pb.State_DENY,
+	}, nil)
+
+	// Set up and register the server
+	grpcServer := grpc.NewServer()
+	pb.RegisterAuthServer(grpcServer, mockService)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+
+	client, err := NewClient(ctx, listen)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	// Denials aren't cached, so both calls are RPCs
+	for i := 0; i < 2; i++ {
+		if _, err := client.Verify(ctx, "example", "example"); err != nil {
+			done()
+			t.Fatal(err)
+		}
+	}
+
+	expected := `
+# HELP auth_client_cache_total Verify calls answered from the cache (hit) or by the auth service (miss).
+# TYPE auth_client_cache_total counter
+auth_client_cache_total{result="miss"} 2
+# HELP auth_client_verify_total Verify results from the auth service, by state. Results from the cache aren't counted.
+# TYPE auth_client_verify_total counter
+auth_client_verify_total{state="DENY"} 2
+# HELP grpc_client_handled_total RPCs completed, by service, method and status code.
+# TYPE grpc_client_handled_total counter
+grpc_client_handled_total{grpc_code="OK",grpc_method="Verify",grpc_service="service.Auth"} 2
+`
+	err = testutil.CollectAndCompare(client, strings.NewReader(expected),
+		"auth_client_cache_total", "auth_client_verify_total", "grpc_client_handled_total")
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	err = client.Close()
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	as := New(Config{
+		Log: log.Default(),
+	})
+	mockService := newMockGrpcService(&pb.VerifyResponse{
+		State: pb.State_ALLOW,
+	}, nil)
+
+	// Set up and register the server, with the interceptor the real one has
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(as.metrics.interceptor))
+	pb.RegisterAuthServer(grpcServer, mockService)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+
+	client, err := NewClient(ctx, listen)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+	for _, id := range []string{"example", "other"} {
+		if _, err := client.Verify(ctx, id, id); err != nil {
+			done()
+			t.Fatal(err)
+		}
+	}
+	err = client.Close()
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	as.metrics.handler().ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`auth_verify_total{state="ALLOW"} 2`,
+		`grpc_server_handled_total{grpc_code="OK",grpc_method="Verify",grpc_service="service.Auth"} 2`,
+		`grpc_server_handling_seconds_count{grpc_method="Verify",grpc_service="service.Auth"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line+"\n") {
+			done()
+			t.Fatalf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestServerMetricsDenied(t *testing.T) {
+	m := newServerMetrics()
+	info := &grpc.UnaryServerInfo{FullMethod: "/service.Auth/Login"}
+	for _, res := range []*pb.SessionResponse{
+		{State: pb.State_DENY, Reason: pb.Reason_INACTIVE},
+		{State: pb.State_DENY, Reason: pb.Reason_INACTIVE},
+		{State: pb.State_DENY, Reason: pb.Reason_BAD_PASSWORD},
+		// Neither of these is counted
+		{State: pb.State_DENY},
+		{State: pb.State_ALLOW},
+	} {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) { return res, nil }
+		if _, err := m.interceptor(context.Background(), &pb.LoginRequest{}, info, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := `
+# HELP auth_denied_total Requests denied, by method and reason. Denials without a reason aren't counted.
+# TYPE auth_denied_total counter
+auth_denied_total{grpc_method="Login",reason="BAD_PASSWORD"} 1
+auth_denied_total{grpc_method="Login",reason="INACTIVE"} 2
+`
+	if err := testutil.CollectAndCompare(m.denied, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
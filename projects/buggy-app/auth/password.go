@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords are stored as bcrypt hashes (https://auth0.com/blog/hashing-in-action-understanding-bcrypt/).
+// Everything that sets a password hashes it with HashPassword, so that they're all hashed alike.
+
+// PasswordCost is the bcrypt cost of new password hashes. Each step up doubles the time it takes to
+// check a password, for us and for anyone trying to guess one.
+const PasswordCost = 10
+
+var (
+	ErrEmptyPassword = errors.New("auth: password must not be empty")
+	// bcrypt only uses the first 72 bytes, so anything after them would be ignored
+	ErrLongPassword = errors.New("auth: password must not be longer than 72 bytes")
+)
+
+// ValidatePassword checks that a password can be hashed
+func ValidatePassword(password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	if len(password) > 72 {
+		return ErrLongPassword
+	}
+	return nil
+}
+
+// HashPassword makes the hash of a password to store in the user table
+func HashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// comparePassword checks a password against its hash. A wrong password is
+// bcrypt.ErrMismatchedHashAndPassword; any other error means the hash is broken.
+func comparePassword(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
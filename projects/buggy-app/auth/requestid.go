@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/requestidctx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Clients send the ID of the request they are handling in the x-request-id metadata (see
+// GrpcClient.Verify), so that the log lines for an RPC can be matched up with theirs.
+
+// requestIdInterceptor runs around every RPC. It takes the request ID from the incoming metadata,
+// or makes one up if there isn't one, and adds it to the context using the requestidctx package.
+// Once the RPC is done, it's logged.
+func requestIdInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestidctx.MetadataKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	id = requestidctx.ValidOrNew(id)
+	ctx = requestidctx.NewRequestIdContext(ctx, id)
+	// The ID is on the RPC's span too, so a trace can be found from a log line
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("request_id", id))
+
+	start := time.Now()
+	res, err := handler(ctx, req)
+	logf(ctx, "rpc: %s, %s, %s\n", info.FullMethod, status.Code(err), time.Since(start))
+	return res, err
+}
+
+// Log a line for an RPC, starting with its request ID
+func logf(ctx context.Context, format string, v ...interface{}) {
+	if id, ok := requestidctx.FromRequestIdContext(ctx); ok {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, v...)
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The API key RPCs act for whichever user the caller names, so only the API may call them. It proves
+// it's the API by sending Config.ServiceKey with each call, which WithServiceKey adds to a client's
+// context. Without a ServiceKey, the client certificates of mutual TLS are enough (see tls.go), and
+// with neither, nobody can call them.
+
+// The RPCs that need the service key
+var serviceRPCs = []string{"CreateApiKey", "ListApiKeys", "RevokeApiKey"}
+
+func isServiceMethod(fullMethod string) bool {
+	prefix := "/" + pb.Auth_ServiceDesc.ServiceName + "/"
+	for _, rpc := range serviceRPCs {
+		if fullMethod == prefix+rpc {
+			return true
+		}
+	}
+	return false
+}
+
+// The metadata that carries the service key
+const serviceKeyMetadataKey = "auth-service-key"
+
+// serviceInterceptor rejects calls to the service RPCs that don't have the service key. mutualTLS
+// says whether clientAuthInterceptor has already checked the client's certificate.
+func serviceInterceptor(key []byte, mutualTLS bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isServiceMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if len(key) == 0 {
+			if mutualTLS {
+				return handler(ctx, req)
+			}
+			return nil, status.Error(codes.PermissionDenied, "there is no service key or client CA, so API key RPCs are disabled")
+		}
+		given, ok := serviceKeyFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "a service key is required")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), key) != 1 {
+			logf(ctx, "rpc: %s, wrong service key\n", info.FullMethod)
+			return nil, status.Error(codes.PermissionDenied, "wrong service key")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// The key from the call's metadata
+func serviceKeyFromContext(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	if values := md.Get(serviceKeyMetadataKey); len(values) > 0 && values[0] != "" {
+		return values[0], true
+	}
+	return "", false
+}
+
+// WithServiceKey adds the service key to the calls made with a context, for the API key RPCs. An
+// empty key adds nothing, for an auth service that relies on mutual TLS.
+func WithServiceKey(ctx context.Context, key []byte) context.Context {
+	if len(key) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, serviceKeyMetadataKey, string(key))
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientApiKeysNeedServiceKey(t *testing.T) {
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	mockService := newMockGrpcService(&pb.VerifyResponse{
+		State: pb.State_ALLOW,
+	}, nil)
+	serviceKey := []byte("0123456789abcdef0123456789abcdef")
+
+	// Set up and register the server, with the interceptor the real one has
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(serviceInterceptor(serviceKey, false)))
+	pb.RegisterAuthServer(grpcServer, mockService)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+	defer done()
+
+	client, err := NewClient(ctx, listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Anyone who can reach the service can't make keys for whoever they like...
+	key := ApiKey{Name: "ci", Scopes: AllScopes}
+	if _, _, err := client.CreateApiKey(ctx, "abc123", key); status.Code(errors.Unwrap(err)) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a key, got %v", err)
+	}
+	wrongCtx := WithServiceKey(ctx, []byte("fedcba9876543210fedcba9876543210"))
+	if _, _, err := client.CreateApiKey(wrongCtx, "abc123", key); status.Code(errors.Unwrap(err)) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied with the wrong key, got %v", err)
+	}
+	// ... but the API can
+	created, secret, err := client.CreateApiKey(WithServiceKey(ctx, serviceKey), "abc123", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id != "key1" || secret == "" {
+		t.Fatalf("unexpected key %+v, %q", created, secret)
+	}
+	// A key the service won't make says why
+	var invalid *InvalidApiKeyError
+	_, _, err = client.CreateApiKey(WithServiceKey(ctx, serviceKey), "abc123", ApiKey{Name: "ci", Scopes: []string{"notes:admin"}})
+	if !errors.As(err, &invalid) || invalid.Reason != `unknown scope "notes:admin"` {
+		t.Fatalf("expected InvalidApiKeyError for an unknown scope, got %v", err)
+	}
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestServiceInterceptorWithoutKey(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	createApiKey := &grpc.UnaryServerInfo{FullMethod: "/service.Auth/CreateApiKey"}
+
+	// Without a service key or mutual TLS, nobody can manage API keys...
+	_, err := serviceInterceptor(nil, false)(context.Background(), nil, createApiKey, handler)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied, got %v", err)
+	}
+	// ... while with mutual TLS, the client's certificate has already been checked
+	if res, err := serviceInterceptor(nil, true)(context.Background(), nil, createApiKey, handler); err != nil || res != "ok" {
+		t.Fatalf("expected a client with a certificate to be let through, got %v, %v", res, err)
+	}
+	if res, err := serviceInterceptor(nil, false)(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/service.Auth/Verify"}, handler); err != nil || res != "ok" {
+		t.Fatalf("expected Verify to be let through, got %v, %v", res, err)
+	}
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sessions save the API from sending every request's password here, to be checked with bcrypt.
+// Login checks the password once and starts a session, and from then on the API only needs the
+// session's access token, which Introspect can check cheaply. See token.go for what the tokens are.
+
+const (
+	// How long an access token is good for, unless Config.AccessTokenTTL says otherwise
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// How long a session lasts without being refreshed, unless Config.SessionTTL says otherwise
+	DefaultSessionTTL = 30 * 24 * time.Hour
+)
+
+// Login checks an id and password like Verify, and if they're allowed starts a session
+func (as *grpcAuthService) Login(ctx context.Context, in *pb.LoginRequest) (*pb.SessionResponse, error) {
+	if ok, reason := as.checkPassword(ctx, "login", in.Id, in.Password); !ok {
+		return &pb.SessionResponse{
+			State:  pb.State_DENY,
+			Reason: reason,
+		}, nil
+	}
+
+	secret, hash, err := newSecret()
+	if err != nil {
+		logf(ctx, "login: refresh token error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to start session")
+	}
+	var sessionId string
+	err = as.pool.QueryRow(ctx,
+		"INSERT INTO public.session (user_id, refresh_hash, expires) VALUES ($1, $2, now() + make_interval(secs => $3)) RETURNING id",
+		in.Id, hash, as.sessionTTL.Seconds(),
+	).Scan(&sessionId)
+	if err != nil {
+		logf(ctx, "login: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to start session")
+	}
+
+	logf(ctx, "login: id %v, session %v\n", in.Id, sessionId)
+	return as.sessionResponse(sessionId, in.Id, secret), nil
+}
+
+// Refresh swaps a refresh token for new tokens, as long as its session hasn't expired or been revoked.
+// The session lasts another SessionTTL from now.
+func (as *grpcAuthService) Refresh(ctx context.Context, in *pb.RefreshRequest) (*pb.SessionResponse, error) {
+	sessionId, hash, err := parseRefreshToken(in.RefreshToken)
+	if err != nil {
+		logf(ctx, "refresh: deny (token)\n")
+		return &pb.SessionResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+
+	secret, newHash, err := newSecret()
+	if err != nil {
+		logf(ctx, "refresh: refresh token error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to refresh session")
+	}
+	// The old refresh token stops working here, as its hash is replaced
+	var userId string
+	err = as.pool.QueryRow(ctx,
+		`UPDATE public.session SET refresh_hash = $3, expires = now() + make_interval(secs => $4)
+		WHERE id = $1 AND refresh_hash = $2 AND revoked IS NULL AND expires > now()
+		RETURNING user_id`,
+		sessionId, hash, newHash, as.sessionTTL.Seconds(),
+	).Scan(&userId)
+	if err == pgx.ErrNoRows {
+		logf(ctx, "refresh: session %v, deny\n", sessionId)
+		return &pb.SessionResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+	if err != nil {
+		logf(ctx, "refresh: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to refresh session")
+	}
+
+	logf(ctx, "refresh: id %v, session %v\n", userId, sessionId)
+	return as.sessionResponse(sessionId, userId, secret), nil
+}
+
+// Revoke ends the session of an access token or a refresh token. An access token doesn't have to
+// still be current, as long as it's genuine. Revoking a session twice is fine.
+func (as *grpcAuthService) Revoke(ctx context.Context, in *pb.RevokeRequest) (*pb.RevokeResponse, error) {
+	// An empty hash matches any session, for access tokens
+	var sessionId, hash string
+	if token, err := parseAccessToken(as.tokenKey, in.Token); err == nil {
+		sessionId = token.sessionId
+	} else if sessionId, hash, err = parseRefreshToken(in.Token); err != nil {
+		logf(ctx, "revoke: deny (token)\n")
+		return &pb.RevokeResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+
+	tag, err := as.pool.Exec(ctx,
+		"UPDATE public.session SET revoked = COALESCE(revoked, now()) WHERE id = $1 AND ($2 = '' OR refresh_hash = $2)",
+		sessionId, hash,
+	)
+	if err != nil {
+		logf(ctx, "revoke: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to revoke session")
+	}
+	if tag.RowsAffected() == 0 {
+		logf(ctx, "revoke: session %v, deny\n", sessionId)
+		return &pb.RevokeResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+
+	logf(ctx, "revoke: session %v, revoked\n", sessionId)
+	return &pb.RevokeResponse{
+		State: pb.State_ALLOW,
+	}, nil
+}
+
+// Introspect checks an access token: that it's genuine, it hasn't expired, its session hasn't
+// been revoked, and its user is still active. It checks API keys too: see introspectApiKey.
+func (as *grpcAuthService) Introspect(ctx context.Context, in *pb.IntrospectRequest) (*pb.IntrospectResponse, error) {
+	if strings.HasPrefix(in.AccessToken, apiKeyPrefix) {
+		return as.introspectApiKey(ctx, in.AccessToken)
+	}
+
+	token, err := parseAccessToken(as.tokenKey, in.AccessToken)
+	if err != nil {
+		logf(ctx, "introspect: deny (token)\n")
+		return &pb.IntrospectResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+	expiresIn := token.expires.Sub(as.now())
+	if expiresIn <= 0 {
+		logf(ctx, "introspect: session %v, deny (expired)\n", token.sessionId)
+		return &pb.IntrospectResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+
+	var userId, userStatus string
+	err = as.pool.QueryRow(ctx,
+		`SELECT s.user_id, u.status FROM public.session s JOIN public.user u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.revoked IS NULL AND s.expires > now()`,
+		token.sessionId,
+	).Scan(&userId, &userStatus)
+	if err == pgx.ErrNoRows {
+		logf(ctx, "introspect: session %v, deny (session)\n", token.sessionId)
+		return &pb.IntrospectResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+	if err != nil {
+		logf(ctx, "introspect: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to check session")
+	}
+	// Only we can sign tokens, so this would mean the key has got out
+	if userId != token.userId {
+		logf(ctx, "introspect: session %v, deny (token is for %v, session for %v)\n", token.sessionId, token.userId, userId)
+		return &pb.IntrospectResponse{
+			State: pb.State_DENY,
+		}, nil
+	}
+	if reason := statusReason(userStatus); reason != pb.Reason_NO_REASON {
+		logf(ctx, "introspect: id %v, session %v, status %v, deny (%v)\n", userId, token.sessionId, userStatus, reason)
+		return &pb.IntrospectResponse{
+			State:  pb.State_DENY,
+			Reason: reason,
+		}, nil
+	}
+
+	// Rounded up, so a token with less than a second left doesn't look like one that never expires
+	return &pb.IntrospectResponse{
+		State:     pb.State_ALLOW,
+		Id:        userId,
+		ExpiresIn: int64((expiresIn + time.Second - 1) / time.Second),
+		Scopes:    AllScopes,
+	}, nil
+}
+
+// The response with a session's tokens, with a new access token
+func (as *grpcAuthService) sessionResponse(sessionId, userId, secret string) *pb.SessionResponse {
+	token := accessToken{
+		sessionId: sessionId,
+		userId:    userId,
+		expires:   as.now().Add(as.accessTokenTTL),
+	}
+	return &pb.SessionResponse{
+		State:        pb.State_ALLOW,
+		AccessToken:  token.sign(as.tokenKey),
+		RefreshToken: sessionId + "." + secret,
+		ExpiresIn:    int64(as.accessTokenTTL / time.Second),
+	}
+}
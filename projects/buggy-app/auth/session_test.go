@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v2"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A service with a mock database, whose clock is stopped at now
+func newTestSessionService(t *testing.T, now time.Time) (*grpcAuthService, pgxmock.PgxPoolIface) {
+	t.Helper()
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	as := newGrpcService(Config{
+		TokenKey:       []byte("0123456789abcdef0123456789abcdef"),
+		AccessTokenTTL: DefaultAccessTokenTTL,
+		SessionTTL:     DefaultSessionTTL,
+	})
+	as.pool = mock
+	as.now = func() time.Time { return now }
+	return as, mock
+}
+
+func expectUser(mock pgxmock.PgxPoolIface, id, password string) {
+	expectUserStatus(mock, id, password, StatusActive)
+}
+
+func expectUserStatus(mock pgxmock.PgxPoolIface, id, password, userStatus string) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	mock.ExpectQuery("^SELECT id, password, status FROM public.user WHERE id = \\$1$").
+		WithArgs(id).
+		WillReturnRows(mock.NewRows([]string{"id", "password", "status"}).AddRow(id, string(hash), userStatus))
+}
+
+func TestLogin(t *testing.T) {
+	now := time.Now()
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+
+	expectUser(mock, "abc123", "banana")
+	mock.ExpectQuery("^INSERT INTO public.session (.+) RETURNING id$").
+		WithArgs("abc123", pgxmock.AnyArg(), DefaultSessionTTL.Seconds()).
+		WillReturnRows(mock.NewRows([]string{"id"}).AddRow("sess1"))
+
+	res, err := as.Login(context.Background(), &pb.LoginRequest{Id: "abc123", Password: "banana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_ALLOW {
+		t.Fatalf("expected ALLOW, got %v", res.State)
+	}
+	if res.ExpiresIn != int64(DefaultAccessTokenTTL/time.Second) {
+		t.Fatalf("expected the token to expire in %v, got %ds", DefaultAccessTokenTTL, res.ExpiresIn)
+	}
+	token, err := parseAccessToken(as.tokenKey, res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.sessionId != "sess1" || token.userId != "abc123" || token.expires.Unix() != now.Add(DefaultAccessTokenTTL).Unix() {
+		t.Fatalf("unexpected access token %+v", token)
+	}
+	if !strings.HasPrefix(res.RefreshToken, "sess1.") {
+		t.Fatalf("expected a refresh token for the session, got %q", res.RefreshToken)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLoginDeny(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	// No session is started
+	expectUser(mock, "abc123", "banana")
+	res, err := as.Login(context.Background(), &pb.LoginRequest{Id: "abc123", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_DENY || res.Reason != pb.Reason_BAD_PASSWORD || res.AccessToken != "" || res.RefreshToken != "" {
+		t.Fatalf("expected DENY for a bad password with no tokens, got %+v", res)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestVerifyReasons(t *testing.T) {
+	now := time.Now()
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+	as.now = func() time.Time { return now }
+
+	tests := []struct {
+		name       string
+		userStatus string
+		password   string
+		expected   pb.Reason
+	}{
+		{"active", StatusActive, "banana", pb.Reason_NO_REASON},
+		{"wrong password", StatusActive, "apple", pb.Reason_BAD_PASSWORD},
+		{"inactive", StatusInactive, "banana", pb.Reason_INACTIVE},
+		{"pending", StatusPending, "banana", pb.Reason_INACTIVE},
+		{"locked", StatusLocked, "banana", pb.Reason_LOCKED},
+		// The status isn't given away without the password
+		{"locked with wrong password", StatusLocked, "apple", pb.Reason_BAD_PASSWORD},
+	}
+	for _, test := range tests {
+		// Long enough after the last that its failure doesn't shut this one out
+		now = now.Add(DefaultBackoffMax)
+		expectUserStatus(mock, "abc123", "banana", test.userStatus)
+		res, err := as.Verify(context.Background(), &pb.VerifyRequest{Id: "abc123", Password: test.password})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (res.State == pb.State_ALLOW) != (test.expected == pb.Reason_NO_REASON) || res.Reason != test.expected {
+			t.Errorf("%s: expected %v, got %+v", test.name, test.expected, res)
+		}
+	}
+
+	mock.ExpectQuery("^SELECT id, password, status FROM public.user WHERE id = \\$1$").
+		WithArgs("nobody").
+		WillReturnError(pgx.ErrNoRows)
+	res, err := as.Verify(context.Background(), &pb.VerifyRequest{Id: "nobody", Password: "banana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_DENY || res.Reason != pb.Reason_UNKNOWN_USER {
+		t.Fatalf("expected DENY for an unknown user, got %+v", res)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	_, hash, _ := parseRefreshToken("sess1.secret")
+	mock.ExpectQuery("^UPDATE public.session SET refresh_hash = \\$3, (.+) RETURNING user_id$").
+		WithArgs("sess1", hash, pgxmock.AnyArg(), DefaultSessionTTL.Seconds()).
+		WillReturnRows(mock.NewRows([]string{"user_id"}).AddRow("abc123"))
+
+	res, err := as.Refresh(context.Background(), &pb.RefreshRequest{RefreshToken: "sess1.secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_ALLOW {
+		t.Fatalf("expected ALLOW, got %v", res.State)
+	}
+	if !strings.HasPrefix(res.RefreshToken, "sess1.") || res.RefreshToken == "sess1.secret" {
+		t.Fatalf("expected a new refresh token for the session, got %q", res.RefreshToken)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefreshDeny(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	// Used already, revoked or expired
+	mock.ExpectQuery("^UPDATE public.session (.+)$").WillReturnError(pgx.ErrNoRows)
+
+	for _, token := range []string{"sess1.secret", "not a token"} {
+		res, err := as.Refresh(context.Background(), &pb.RefreshRequest{RefreshToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != pb.State_DENY || res.AccessToken != "" {
+			t.Fatalf("%q: expected DENY with no tokens, got %+v", token, res)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	now := time.Now()
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+
+	// An expired access token can still end its session
+	access := accessToken{sessionId: "sess1", userId: "abc123", expires: now.Add(-time.Minute)}.sign(as.tokenKey)
+	_, hash, _ := parseRefreshToken("sess2.secret")
+	mock.ExpectExec("^UPDATE public.session SET revoked (.+)$").
+		WithArgs("sess1", "").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("^UPDATE public.session SET revoked (.+)$").
+		WithArgs("sess2", hash).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	// The wrong refresh token for the session
+	mock.ExpectExec("^UPDATE public.session SET revoked (.+)$").
+		WithArgs("sess2", pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	tests := []struct {
+		token    string
+		expected pb.State
+	}{
+		{access, pb.State_ALLOW},
+		{"sess2.secret", pb.State_ALLOW},
+		{"sess2.guess", pb.State_DENY},
+		{"not a token", pb.State_DENY},
+	}
+	for _, test := range tests {
+		res, err := as.Revoke(context.Background(), &pb.RevokeRequest{Token: test.token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != test.expected {
+			t.Fatalf("%q: expected %v, got %v", test.token, test.expected, res.State)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	// Tokens expire on the second
+	now := time.Now().Truncate(time.Second)
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+
+	current := accessToken{sessionId: "sess1", userId: "abc123", expires: now.Add(10 * time.Minute)}.sign(as.tokenKey)
+	expired := accessToken{sessionId: "sess1", userId: "abc123", expires: now}.sign(as.tokenKey)
+	forged := accessToken{sessionId: "sess1", userId: "abc123", expires: now.Add(10 * time.Minute)}.sign([]byte("guess"))
+
+	sessionQuery := "^SELECT s.user_id, u.status FROM public.session s JOIN public.user u ON u.id = s.user_id\\s+WHERE s.id = \\$1 AND s.revoked IS NULL AND s.expires > now\\(\\)$"
+	mock.ExpectQuery(sessionQuery).
+		WithArgs("sess1").
+		WillReturnRows(mock.NewRows([]string{"user_id", "status"}).AddRow("abc123", StatusActive))
+	mock.ExpectQuery(sessionQuery).
+		WithArgs("sess1").
+		WillReturnRows(mock.NewRows([]string{"user_id", "status"}).AddRow("abc123", StatusActive))
+	// The user has been locked since
+	mock.ExpectQuery(sessionQuery).
+		WithArgs("sess1").
+		WillReturnRows(mock.NewRows([]string{"user_id", "status"}).AddRow("abc123", StatusLocked))
+	// Revoked
+	mock.ExpectQuery(sessionQuery).
+		WithArgs("sess1").
+		WillReturnError(pgx.ErrNoRows)
+
+	res, err := as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_ALLOW || res.Id != "abc123" || res.ExpiresIn != 600 {
+		t.Fatalf("expected ALLOW for abc123 for 600s, got %+v", res)
+	}
+	// Sessions can do anything
+	if !reflect.DeepEqual(res.Scopes, AllScopes) || res.KeyId != "" {
+		t.Fatalf("expected all scopes and no key, got %+v", res)
+	}
+
+	// Less than a second left still counts as a second
+	as.now = func() time.Time { return now.Add(10*time.Minute - 500*time.Millisecond) }
+	res, err = as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_ALLOW || res.ExpiresIn != 1 {
+		t.Fatalf("expected ALLOW for 1s, got %+v", res)
+	}
+	as.now = func() time.Time { return now }
+
+	res, err = as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != pb.State_DENY || res.Reason != pb.Reason_LOCKED || res.Id != "" {
+		t.Fatalf("expected DENY for a locked user, got %+v", res)
+	}
+
+	// Expired and forged tokens don't need the database
+	for _, token := range []string{current, expired, forged} {
+		res, err := as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != pb.State_DENY || res.Id != "" {
+			t.Fatalf("expected DENY, got %+v", res)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestIntrospectError(t *testing.T) {
+	now := time.Now()
+	as, mock := newTestSessionService(t, now)
+	defer mock.Close()
+
+	// Failing to check isn't a DENY, as that could be taken to mean the session is over
+	mock.ExpectQuery("^SELECT s.user_id, u.status FROM public.session (.+)$").WillReturnError(errors.New("connection reset"))
+	token := accessToken{sessionId: "sess1", userId: "abc123", expires: now.Add(time.Minute)}.sign(as.tokenKey)
+	_, err := as.Introspect(context.Background(), &pb.IntrospectRequest{AccessToken: token})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// With a CA in Config.TLS, the server asks clients for a certificate signed by it (see
+// certs.Store.ServerConfig) but lets them connect without one, so that anyone can check its health.
+// The auth service's own RPCs are only for clients that sent one: the API, and admins.
+
+// clientAuthInterceptor rejects calls to the auth service from clients without a verified
+// certificate, or, if there are any names, without a certificate for one of them
+func clientAuthInterceptor(names []string) grpc.UnaryServerInterceptor {
+	prefix := "/" + pb.Auth_ServiceDesc.ServiceName + "/"
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, prefix) {
+			return handler(ctx, req)
+		}
+		cert := clientCert(ctx)
+		if cert == nil {
+			return nil, status.Error(codes.Unauthenticated, "a client certificate is required")
+		}
+		if len(names) > 0 && !certHasName(cert, names) {
+			logf(ctx, "rpc: %s, client %q is not allowed\n", info.FullMethod, cert.Subject.CommonName)
+			return nil, status.Error(codes.PermissionDenied, "client is not allowed")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// clientCert is the client's certificate, if it sent one and it was verified
+func clientCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// A certificate's names are its common name and DNS names
+func certHasName(cert *x509.Certificate, names []string) bool {
+	for _, name := range names {
+		if cert.Subject.CommonName == name {
+			return true
+		}
+		for _, dnsName := range cert.DNSNames {
+			if dnsName == name {
+				return true
+			}
+		}
+	}
+	return false
+}
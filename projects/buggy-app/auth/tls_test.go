@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/certs"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/certs/certstest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Throwaway certificates: one CA for the server and another for its clients, like in
+// docker-compose
+type testCerts struct {
+	dir      string
+	serverCA *certstest.CA
+	clientCA *certstest.CA
+}
+
+func newTestCerts(t *testing.T) *testCerts {
+	return &testCerts{
+		dir:      t.TempDir(),
+		serverCA: certstest.NewCA(t, "server-ca"),
+		clientCA: certstest.NewCA(t, "client-ca"),
+	}
+}
+
+// The server's files
+func (c *testCerts) server(t *testing.T) certs.Files {
+	cert, key, ca := c.serverCA.WriteFiles(t, c.dir, "localhost", c.clientCA)
+	return certs.Files{Cert: cert, Key: key, CA: ca}
+}
+
+// A client's files, with a certificate for name, or none if name is empty
+func (c *testCerts) client(t *testing.T, name string) certs.Files {
+	if name == "" {
+		ca := filepath.Join(c.dir, "server-ca.crt")
+		certstest.Write(t, ca, c.serverCA.PEM)
+		return certs.Files{CA: ca}
+	}
+	cert, key, ca := c.clientCA.WriteFiles(t, c.dir, name, c.serverCA)
+	return certs.Files{Cert: cert, Key: key, CA: ca}
+}
+
+func newTestTLSClient(t *testing.T, ctx context.Context, target string, files certs.Files) *GrpcClient {
+	t.Helper()
+	store, err := certs.NewStore(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewTLSClient(ctx, target, store.ClientConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClientVerifyTLS(t *testing.T) {
+	testCerts := newTestCerts(t)
+	as := New(Config{
+		Log:         log.Default(),
+		TLS:         testCerts.server(t),
+		ClientNames: []string{"api"},
+	})
+	opts, err := as.serverOpts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listen := "localhost:8010"
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	// The real server's options, with a mock service
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterAuthServer(grpcServer, newMockGrpcService(&pb.VerifyResponse{
+		State: pb.State_ALLOW,
+	}, nil))
+	setHealth(as.health, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, as.health)
+
+	var runErr error
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = grpcServer.Serve(lis)
+	}()
+
+	done := func() {
+		cancel()
+		grpcServer.GracefulStop()
+		wg.Wait()
+	}
+	defer done()
+
+	t.Run("api", func(t *testing.T) {
+		client := newTestTLSClient(t, ctx, listen, testCerts.client(t, "api"))
+		defer client.Close()
+		res, err := client.Verify(ctx, "example", "example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.State != StateAllow {
+			t.Fatalf("verify state: expected %s, got %s\n", StateAllow, res.State)
+		}
+	})
+
+	tests := map[string]struct {
+		name     string
+		expected codes.Code
+	}{
+		"no certificate": {"", codes.Unauthenticated},
+		"someone else":   {"intruder", codes.PermissionDenied},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := newTestTLSClient(t, ctx, listen, testCerts.client(t, test.name))
+			defer client.Close()
+			_, err := client.Verify(ctx, "example", "example")
+			if code := status.Code(errors.Unwrap(err)); code != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+
+	t.Run("insecure", func(t *testing.T) {
+		client, err := NewClient(ctx, listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		_, err = client.Verify(ctx, "example", "example")
+		if code := status.Code(errors.Unwrap(err)); code != codes.Unavailable {
+			t.Fatalf("expected %v, got %v", codes.Unavailable, err)
+		}
+	})
+
+	t.Run("health without certificate", func(t *testing.T) {
+		client := newTestTLSClient(t, ctx, listen, testCerts.client(t, ""))
+		defer client.Close()
+		res, err := healthpb.NewHealthClient(client.conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("expected SERVING, got %v", res.Status)
+		}
+	})
+
+	done()
+	if runErr != nil && runErr != grpc.ErrServerStopped {
+		t.Fatal(runErr)
+	}
+}
+
+func TestRunBadCertificates(t *testing.T) {
+	dir := t.TempDir()
+	as := New(Config{
+		Port:        8010,
+		DatabaseUrl: "postgres://postgres@localhost:1/app?connect_timeout=1",
+		Log:         log.Default(),
+		TLS:         certs.Files{Cert: filepath.Join(dir, "missing.crt"), Key: filepath.Join(dir, "missing.key")},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := as.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "unable to load certificates") {
+		t.Fatalf("expected an error loading the certificates, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A session has two tokens:
+//
+//   - The access token is sent with each request to the API, as Authorization: Bearer. It's signed
+//     with the service's TokenKey, so it can be checked without the database, and it expires soon
+//     after it's issued. It looks like <session id>.<user id>.<expiry>.<signature>, where the expiry
+//     is in Unix seconds.
+//   - The refresh token is swapped for new tokens before the access token expires. It looks like
+//     <session id>.<secret>, and only a hash of the secret is kept, in the session table. Each
+//     refresh replaces it, so a refresh token can only be used once.
+//
+// An API key is sent as Authorization: Bearer too, in place of an access token. It looks like
+// key.<key id>.<secret>, and like a refresh token only a hash of its secret is kept, in the api_key
+// table. See apikey.go.
+//
+// The IDs are made by gen_id in the database, and never contain a dot.
+
+var errBadToken = errors.New("auth: bad token")
+
+type accessToken struct {
+	sessionId string
+	userId    string
+	expires   time.Time
+}
+
+// sign makes the access token for a session, with its signature
+func (t accessToken) sign(key []byte) string {
+	payload := strings.Join([]string{t.sessionId, t.userId, strconv.FormatInt(t.expires.Unix(), 10)}, ".")
+	return payload + "." + tokenSignature(key, payload)
+}
+
+func tokenSignature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseAccessToken checks a token's signature and returns what's in it. It's up to the caller to
+// check whether it has expired.
+func parseAccessToken(key []byte, token string) (accessToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" {
+		return accessToken{}, errBadToken
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(tokenSignature(key, payload))) {
+		return accessToken{}, errBadToken
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return accessToken{}, errBadToken
+	}
+	return accessToken{
+		sessionId: parts[0],
+		userId:    parts[1],
+		expires:   time.Unix(expires, 0),
+	}, nil
+}
+
+// newSecret makes the secret part of a refresh token or API key, and the hash of it to store
+func newSecret() (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	return secret, secretHash(secret), nil
+}
+
+func secretHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseRefreshToken splits a refresh token into its session ID and the hash of its secret
+func parseRefreshToken(token string) (sessionId, hash string, err error) {
+	sessionId, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionId == "" || secret == "" || strings.Contains(secret, ".") {
+		return "", "", errBadToken
+	}
+	return sessionId, secretHash(secret), nil
+}
+
+// apiKeyPrefix starts every API key, so they can be told apart from access tokens
+const apiKeyPrefix = "key."
+
+func formatApiKey(keyId, secret string) string {
+	return apiKeyPrefix + keyId + "." + secret
+}
+
+// parseApiKey splits an API key into its ID and the hash of its secret
+func parseApiKey(key string) (keyId, hash string, err error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", "", errBadToken
+	}
+	keyId, secret, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), ".")
+	if !ok || keyId == "" || secret == "" || strings.Contains(secret, ".") {
+		return "", "", errBadToken
+	}
+	return keyId, secretHash(secret), nil
+}
+
+// NewTokenKey makes a random key to sign access tokens with, for when one isn't configured
+func NewTokenKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
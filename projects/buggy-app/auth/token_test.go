@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessToken(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	token := accessToken{
+		sessionId: "Xy_9-abc",
+		userId:    "A2RPq6To",
+		expires:   time.Unix(1700000000, 0),
+	}
+	signed := token.sign(key)
+	if !strings.HasPrefix(signed, "Xy_9-abc.A2RPq6To.1700000000.") {
+		t.Fatalf("unexpected token %q", signed)
+	}
+
+	parsed, err := parseAccessToken(key, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != token {
+		t.Fatalf("expected %+v, got %+v", token, parsed)
+	}
+
+	// Anything changed, or signed with another key, is rejected
+	bad := []string{
+		"",
+		strings.Replace(signed, "A2RPq6To", "someone", 1),
+		strings.Replace(signed, "1700000000", "1800000000", 1),
+		signed[:len(signed)-2],
+		signed + ".more",
+		accessToken{sessionId: "Xy_9-abc", userId: "A2RPq6To", expires: token.expires}.sign([]byte("another key")),
+	}
+	for _, b := range bad {
+		if _, err := parseAccessToken(key, b); err != errBadToken {
+			t.Errorf("%q: expected errBadToken, got %v", b, err)
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	secret, hash, err := newSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionId, parsedHash, err := parseRefreshToken("Xy_9-abc." + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionId != "Xy_9-abc" || parsedHash != hash {
+		t.Fatalf("expected session Xy_9-abc with hash %s, got %s with %s", hash, sessionId, parsedHash)
+	}
+
+	for _, bad := range []string{"", "Xy_9-abc", "Xy_9-abc.", ".secret", "a.b.c"} {
+		if _, _, err := parseRefreshToken(bad); err != errBadToken {
+			t.Errorf("%q: expected errBadToken, got %v", bad, err)
+		}
+	}
+}
+
+func TestApiKey(t *testing.T) {
+	secret, hash, err := newSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyId, parsedHash, err := parseApiKey(formatApiKey("Xy_9-abc", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyId != "Xy_9-abc" || parsedHash != hash {
+		t.Fatalf("expected key Xy_9-abc with hash %s, got %s with %s", hash, keyId, parsedHash)
+	}
+
+	// Refresh and access tokens aren't API keys
+	for _, bad := range []string{"", "key.", "key.Xy_9-abc", "key..secret", "key.a.b.c", "Xy_9-abc." + secret, "a.b.c.d"} {
+		if _, _, err := parseApiKey(bad); err != errBadToken {
+			t.Errorf("%q: expected errBadToken, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The user management RPCs, which only admins can call: see admin.go
+
+// The statuses a user can have. Only active users can log in or use their sessions and API keys:
+// see statusReason.
+const (
+	StatusActive = "active"
+	// Turned off, by the user or an admin
+	StatusInactive = "inactive"
+	// Shut out by an admin, say because the account has been taken over
+	StatusLocked = "locked"
+	// Made, but not yet set up for use
+	StatusPending = "pending"
+)
+
+var UserStatuses = []string{StatusActive, StatusInactive, StatusLocked, StatusPending}
+
+func ValidStatus(status string) bool {
+	for _, s := range UserStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// statusReason is why a user with a status is denied, or NO_REASON if they aren't
+func statusReason(status string) pb.Reason {
+	switch status {
+	case StatusActive:
+		return pb.Reason_NO_REASON
+	case StatusLocked:
+		return pb.Reason_LOCKED
+	default:
+		return pb.Reason_INACTIVE
+	}
+}
+
+const (
+	// How many users ListUsers returns, unless asked for another number
+	DefaultUserPageSize = 100
+	// The most users ListUsers returns at once
+	MaxUserPageSize = 1000
+)
+
+// The foreign key violation error code from Postgres
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const foreignKeyViolation = "23503"
+
+// The columns of a User, with the times in Unix seconds
+const userColumns = `id, status, EXTRACT(EPOCH FROM created::timestamptz)::bigint,
+	EXTRACT(EPOCH FROM modified::timestamptz)::bigint`
+
+func scanUser(row pgx.Row) (*pb.User, error) {
+	var user pb.User
+	err := row.Scan(&user.Id, &user.Status, &user.Created, &user.Modified)
+	return &user, err
+}
+
+// userResponse turns the result of a query for one user into a response. rpc is the name the RPC
+// logs as.
+func userResponse(ctx context.Context, rpc string, row pgx.Row) (*pb.UserResponse, error) {
+	user, err := scanUser(row)
+	if err == pgx.ErrNoRows {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		logf(ctx, "%s: query error: %v\n", rpc, err)
+		return nil, status.Errorf(codes.Internal, "failed to %s", rpc)
+	}
+	return &pb.UserResponse{
+		User: user,
+	}, nil
+}
+
+// hashPassword is HashPassword for the RPCs, with errors they can return
+func hashPassword(ctx context.Context, rpc, password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		logf(ctx, "%s: hash error: %v\n", rpc, err)
+		return "", status.Errorf(codes.Internal, "failed to %s", rpc)
+	}
+	return hash, nil
+}
+
+// CreateUser makes a new user, who is active unless another status is given
+func (as *grpcAuthService) CreateUser(ctx context.Context, in *pb.CreateUserRequest) (*pb.UserResponse, error) {
+	userStatus := in.Status
+	if userStatus == "" {
+		userStatus = StatusActive
+	}
+	if !ValidStatus(userStatus) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown status %q", userStatus)
+	}
+	hash, err := hashPassword(ctx, "create user", in.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := userResponse(ctx, "create user", as.pool.QueryRow(ctx,
+		"INSERT INTO public.user (status, password) VALUES ($1, $2) RETURNING "+userColumns,
+		userStatus, hash,
+	))
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "create user: id %v, status %v\n", res.User.Id, res.User.Status)
+	return res, nil
+}
+
+func (as *grpcAuthService) GetUser(ctx context.Context, in *pb.GetUserRequest) (*pb.UserResponse, error) {
+	return userResponse(ctx, "get user", as.pool.QueryRow(ctx,
+		"SELECT "+userColumns+" FROM public.user WHERE id = $1",
+		in.Id,
+	))
+}
+
+// SetPassword changes a user's password. Their sessions are ended, as whoever started them may
+// only have known the old password. API keys are left alone.
+func (as *grpcAuthService) SetPassword(ctx context.Context, in *pb.SetPasswordRequest) (*pb.UserResponse, error) {
+	hash, err := hashPassword(ctx, "set password", in.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := userResponse(ctx, "set password", as.pool.QueryRow(ctx,
+		`WITH revoked AS (
+			UPDATE public.session SET revoked = COALESCE(revoked, now()) WHERE user_id = $1
+		)
+		UPDATE public.user SET password = $2 WHERE id = $1 RETURNING `+userColumns,
+		in.Id, hash,
+	))
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "set password: id %v\n", in.Id)
+	return res, nil
+}
+
+func (as *grpcAuthService) SetStatus(ctx context.Context, in *pb.SetStatusRequest) (*pb.UserResponse, error) {
+	if !ValidStatus(in.Status) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown status %q", in.Status)
+	}
+
+	res, err := userResponse(ctx, "set status", as.pool.QueryRow(ctx,
+		"UPDATE public.user SET status = $2 WHERE id = $1 RETURNING "+userColumns,
+		in.Id, in.Status,
+	))
+	if err != nil {
+		return nil, err
+	}
+	logf(ctx, "set status: id %v, status %v\n", in.Id, in.Status)
+	return res, nil
+}
+
+// ListUsers lists users in order of their ID. The page token is the ID of the last user of the
+// previous page.
+func (as *grpcAuthService) ListUsers(ctx context.Context, in *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	pageSize := int(in.PageSize)
+	if pageSize < 0 || pageSize > MaxUserPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page size must be between 0 and %d", MaxUserPageSize)
+	}
+	if pageSize == 0 {
+		pageSize = DefaultUserPageSize
+	}
+
+	// One more than a page says whether there's another page
+	rows, err := as.pool.Query(ctx,
+		"SELECT "+userColumns+" FROM public.user WHERE id > $1 ORDER BY id LIMIT $2",
+		in.PageToken, pageSize+1,
+	)
+	if err != nil {
+		logf(ctx, "list users: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to list users")
+	}
+	defer rows.Close()
+
+	res := &pb.ListUsersResponse{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			logf(ctx, "list users: scan error: %v\n", err)
+			return nil, status.Error(codes.Internal, "failed to list users")
+		}
+		res.Users = append(res.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		logf(ctx, "list users: rows error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to list users")
+	}
+
+	if len(res.Users) > pageSize {
+		res.Users = res.Users[:pageSize]
+		res.NextPageToken = res.Users[pageSize-1].Id
+	}
+	return res, nil
+}
+
+// DeleteUser deletes a user, with their sessions, API keys and the shares of other users' notes
+// with them. A user who still owns notes can't be deleted, so that notes aren't lost by accident.
+func (as *grpcAuthService) DeleteUser(ctx context.Context, in *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	tag, err := as.pool.Exec(ctx, "DELETE FROM public.user WHERE id = $1", in.Id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return nil, status.Error(codes.FailedPrecondition, "the user still owns notes")
+	}
+	if err != nil {
+		logf(ctx, "delete user: query error: %v\n", err)
+		return nil, status.Error(codes.Internal, "failed to delete user")
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	logf(ctx, "delete user: id %v\n", in.Id)
+	return &pb.DeleteUserResponse{}, nil
+}
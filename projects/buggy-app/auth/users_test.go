@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/CodeYourFuture/immersive-go-course/buggy-app/auth/service"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v2"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var userRowColumns = []string{"id", "status", "created", "modified"}
+
+// passwordHash matches an argument that's a bcrypt hash of password
+type passwordHash string
+
+func (p passwordHash) Match(v interface{}) bool {
+	hash, ok := v.(string)
+	return ok && bcrypt.CompareHashAndPassword([]byte(hash), []byte(p)) == nil
+}
+
+func TestCreateUser(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	created := time.Now().Unix()
+	mock.ExpectQuery("^INSERT INTO public.user \\(status, password\\) VALUES \\(\\$1, \\$2\\) RETURNING (.+)$").
+		WithArgs(StatusActive, passwordHash("banana")).
+		WillReturnRows(mock.NewRows(userRowColumns).AddRow("abc123", StatusActive, created, created))
+
+	res, err := as.CreateUser(context.Background(), &pb.CreateUserRequest{Password: "banana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.User.Id != "abc123" || res.User.Status != StatusActive || res.User.Created != created {
+		t.Fatalf("unexpected user %+v", res.User)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateUserInvalid(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	// Nothing reaches the database
+	for _, in := range []*pb.CreateUserRequest{
+		{Password: ""},
+		{Password: strings.Repeat("x", 73)},
+		{Password: "banana", Status: "sleeping"},
+	} {
+		if _, err := as.CreateUser(context.Background(), in); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%+v: expected InvalidArgument, got %v", in, err)
+		}
+	}
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	mock.ExpectQuery("^SELECT (.+) FROM public.user WHERE id = \\$1$").
+		WithArgs("nobody").
+		WillReturnError(pgx.ErrNoRows)
+
+	if _, err := as.GetUser(context.Background(), &pb.GetUserRequest{Id: "nobody"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	// The user's sessions are revoked in the same query
+	now := time.Now().Unix()
+	mock.ExpectQuery("^WITH revoked AS \\(\\s+UPDATE public.session SET revoked (.+) WHERE user_id = \\$1\\s+\\)\\s+UPDATE public.user SET password = \\$2 WHERE id = \\$1 RETURNING (.+)$").
+		WithArgs("abc123", passwordHash("apple")).
+		WillReturnRows(mock.NewRows(userRowColumns).AddRow("abc123", StatusActive, now, now))
+
+	if _, err := as.SetPassword(context.Background(), &pb.SetPasswordRequest{Id: "abc123", Password: "apple"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	now := time.Now().Unix()
+	mock.ExpectQuery("^UPDATE public.user SET status = \\$2 WHERE id = \\$1 RETURNING (.+)$").
+		WithArgs("abc123", StatusInactive).
+		WillReturnRows(mock.NewRows(userRowColumns).AddRow("abc123", StatusInactive, now, now))
+
+	res, err := as.SetStatus(context.Background(), &pb.SetStatusRequest{Id: "abc123", Status: StatusInactive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.User.Status != StatusInactive {
+		t.Fatalf("expected the user to be inactive, got %+v", res.User)
+	}
+	if _, err := as.SetStatus(context.Background(), &pb.SetStatusRequest{Id: "abc123", Status: ""}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for no status, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	now := time.Now().Unix()
+	listQuery := "^SELECT (.+) FROM public.user WHERE id > \\$1 ORDER BY id LIMIT \\$2$"
+	// A page of two, and one more to say there's another page
+	mock.ExpectQuery(listQuery).
+		WithArgs("", 3).
+		WillReturnRows(mock.NewRows(userRowColumns).
+			AddRow("a", StatusActive, now, now).
+			AddRow("b", StatusActive, now, now).
+			AddRow("c", StatusInactive, now, now))
+	mock.ExpectQuery(listQuery).
+		WithArgs("b", 3).
+		WillReturnRows(mock.NewRows(userRowColumns).
+			AddRow("c", StatusInactive, now, now))
+
+	res, err := as.ListUsers(context.Background(), &pb.ListUsersRequest{PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 2 || res.NextPageToken != "b" {
+		t.Fatalf("expected two users and a next page after b, got %+v", res)
+	}
+	res, err = as.ListUsers(context.Background(), &pb.ListUsersRequest{PageSize: 2, PageToken: res.NextPageToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 1 || res.Users[0].Id != "c" || res.NextPageToken != "" {
+		t.Fatalf("expected the last page with c, got %+v", res)
+	}
+
+	if _, err := as.ListUsers(context.Background(), &pb.ListUsersRequest{PageSize: MaxUserPageSize + 1}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a big page, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	as, mock := newTestSessionService(t, time.Now())
+	defer mock.Close()
+
+	deleteQuery := "^DELETE FROM public.user WHERE id = \\$1$"
+	mock.ExpectExec(deleteQuery).WithArgs("abc123").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectExec(deleteQuery).WithArgs("nobody").WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectExec(deleteQuery).WithArgs("writer").WillReturnError(&pgconn.PgError{Code: foreignKeyViolation})
+	mock.ExpectExec(deleteQuery).WithArgs("def456").WillReturnError(errors.New("connection reset"))
+
+	tests := []struct {
+		id       string
+		expected codes.Code
+	}{
+		{"abc123", codes.OK},
+		{"nobody", codes.NotFound},
+		// Still owns notes
+		{"writer", codes.FailedPrecondition},
+		{"def456", codes.Internal},
+	}
+	for _, test := range tests {
+		_, err := as.DeleteUser(context.Background(), &pb.DeleteUserRequest{Id: test.id})
+		if status.Code(err) != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.id, test.expected, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -1,25 +1,35 @@
 package main
 
 import (
+	"bytes"
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
 
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/api"
-	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/config"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
 	"golang.org/x/net/context"
 )
 
 func main() {
-	port := flag.Int("port", 80, "port the server will listen on")
-	flag.Parse()
+	// Settings come from the defaults, a config file, the environment and flags: see the config package
+	cfg := config.DefaultAPI()
+	if err := config.Load(flag.CommandLine, os.Args[1:], &cfg); err != nil {
+		log.Fatal(err)
+	}
+	var printed bytes.Buffer
+	if err := config.Print(&printed, &cfg); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("api service: config:\n%s", printed.String())
 
-	// Get the postgres password from a file supplied in an environment variable
-	// TODO: it would be better for this to come from DATABASE_URL or to "figure out"
-	// the best auth params from environment variables
-	passwd, err := util.ReadPasswd()
+	databaseUrl, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serviceKey, err := cfg.AuthServiceKey()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -29,11 +39,28 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer stop()
 
+	tp, err := tracing.NewProvider(ctx, "api", cfg.Tracing.Config())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Send the spans that haven't been yet before exiting
+	defer tp.Shutdown(context.Background())
+	tracing.Use(tp)
+
 	as := api.New(api.Config{
-		Port:           *port,
+		Port:           cfg.Port,
 		Log:            log.Default(),
-		AuthServiceUrl: "auth:80",
-		DatabaseUrl:    fmt.Sprintf("postgres://postgres:%s@postgres:5432/app", passwd),
+		AuthServiceUrl: cfg.AuthServiceUrl,
+		DatabaseUrl:    databaseUrl,
+		AuthTLS:        cfg.TLS.Files(),
+		AuthServerName: cfg.AuthServerName,
+		AuthServiceKey: serviceKey,
+
+		TrashRetention:     cfg.TrashRetention,
+		TrashPurgeInterval: cfg.TrashPurgeInterval,
+
+		RateLimit:          api.RateLimit{Rate: cfg.RateLimit, Burst: cfg.RateLimitBurst},
+		AnonymousRateLimit: api.RateLimit{Rate: cfg.AnonymousRateLimit, Burst: cfg.AnonymousRateLimitBurst},
 	})
 	if err := as.Run(ctx); err != nil {
 		log.Fatal(err)
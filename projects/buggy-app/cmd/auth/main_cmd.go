@@ -1,25 +1,43 @@
 package main
 
 import (
+	"bytes"
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
 
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
-	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/config"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
 	"golang.org/x/net/context"
 )
 
 func main() {
-	port := flag.Int("port", 80, "port the server will listen on")
-	flag.Parse()
+	// Settings come from the defaults, a config file, the environment and flags: see the config package
+	cfg := config.DefaultAuth()
+	if err := config.Load(flag.CommandLine, os.Args[1:], &cfg); err != nil {
+		log.Fatal(err)
+	}
+	var printed bytes.Buffer
+	if err := config.Print(&printed, &cfg); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("auth service: config:\n%s", printed.String())
 
-	// Get the postgres password from a file supplied in an environment variable
-	// TODO: it would be better for this to come from DATABASE_URL or to "figure out"
-	// the best auth params from environment variables
-	passwd, err := util.ReadPasswd()
+	databaseUrl, err := cfg.Database.ConnString()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenKey, err := cfg.TokenKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	adminKey, err := cfg.AdminKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serviceKey, err := cfg.ServiceKey()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -29,10 +47,34 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer stop()
 
+	tp, err := tracing.NewProvider(ctx, "auth", cfg.Tracing.Config())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Send the spans that haven't been yet before exiting
+	defer tp.Shutdown(context.Background())
+	tracing.Use(tp)
+
 	as := auth.New(auth.Config{
-		Port:        *port,
-		DatabaseUrl: fmt.Sprintf("postgres://postgres:%s@postgres:5432/app", passwd),
+		Port:        cfg.Port,
+		MetricsPort: cfg.MetricsPort,
+		DatabaseUrl: databaseUrl,
 		Log:         log.Default(),
+		TLS:         cfg.TLS.Files(),
+		ClientNames: cfg.AllowedClients(),
+
+		TokenKey:       tokenKey,
+		AccessTokenTTL: cfg.AccessTokenTTL,
+		SessionTTL:     cfg.SessionTTL,
+		AdminKey:       adminKey,
+		ServiceKey:     serviceKey,
+
+		MaxFailures:       cfg.MaxFailures,
+		MaxSourceFailures: cfg.MaxSourceFailures,
+		FailureWindow:     cfg.FailureWindow,
+		LockoutDuration:   cfg.LockoutDuration,
+		BackoffBase:       cfg.BackoffBase,
+		BackoffMax:        cfg.BackoffMax,
 	})
 	if err := as.Run(ctx); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/certs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// The healthcheck command checks the health of one of the services, for docker-compose's
+// healthcheck. It exits with 0 if the service is healthy and 1 if it isn't.
+//
+// The auth service implements the gRPC health service:
+//
+//	healthcheck -grpc localhost:80
+//
+// If it uses TLS, give the CA to check its certificate with. Health checks don't need a client
+// certificate:
+//
+//	healthcheck -grpc localhost:80 -tls-ca /certs/ca.crt -server-name auth
+//
+// The api service has a readiness endpoint:
+//
+//	healthcheck -http http://localhost:80/readyz
+
+func main() {
+	grpcTarget := flag.String("grpc", "", "host:port of a gRPC server to check")
+	service := flag.String("service", "", "gRPC service to check; empty for the whole server")
+	httpUrl := flag.String("http", "", "URL to GET, which must respond with 200 OK")
+	timeout := flag.Duration("timeout", 3*time.Second, "how long the check can take")
+	caFile := flag.String("tls-ca", "", "CA certificate file (PEM) to check the gRPC server's certificate with, if it uses TLS")
+	serverName := flag.String("server-name", "", "name the gRPC server's certificate must have, if not the host of -grpc")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case *grpcTarget != "":
+		err = checkGrpc(ctx, *grpcTarget, *service, *caFile, *serverName)
+	case *httpUrl != "":
+		err = checkHttp(ctx, *httpUrl)
+	default:
+		err = fmt.Errorf("one of -grpc or -http is required")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func checkGrpc(ctx context.Context, target, service, caFile, serverName string) error {
+	creds := insecure.NewCredentials()
+	if caFile != "" {
+		store, err := certs.NewStore(certs.Files{CA: caFile})
+		if err != nil {
+			return err
+		}
+		creds = credentials.NewTLS(store.ClientConfig(serverName))
+	}
+	conn, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
+
+func checkHttp(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return nil
+}
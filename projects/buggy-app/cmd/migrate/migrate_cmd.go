@@ -12,7 +12,7 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 
-	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/config"
 )
 
 // The migrate command reads a directory (-path) for sub-directories containing .sql files.
@@ -37,7 +37,7 @@ import (
 //  - Try the migration again
 //
 // Before running this tool, make sure the password for the postgres user is available at
-// $POSTGRES_PASSWORD or $POSTGRES_PASSWORD_FILE.
+// $POSTGRES_PASSWORD or $POSTGRES_PASSWORD_FILE, or set $DATABASE_URL.
 
 func readDir(path string) ([]os.DirEntry, error) {
 	// Stat the file so we can check if it's a directory or not before
@@ -66,9 +66,14 @@ func readDir(path string) ([]os.DirEntry, error) {
 
 func main() {
 	path := flag.String("path", "", "Path to migrations directory")
-	hostport := flag.String("hostport", "postgres:5432", "Host:port of Postgres")
 
-	flag.Parse()
+	// The database to connect to comes from the config package. The name of the database is
+	// ignored, as it comes from the directories being migrated.
+	db := config.DefaultDatabase()
+	db.SSLMode = "disable"
+	if err := config.Load(flag.CommandLine, os.Args[1:], &db); err != nil {
+		log.Fatal(err)
+	}
 	if len(flag.Args()) == 0 {
 		log.Println("missing command, expected one of [up, down]")
 		log.Println("migrate up -path [...]")
@@ -80,12 +85,6 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Get the Postgres password from the environment
-	passwd, err := util.ReadPasswd()
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	// Read the migrations directory
 	contents, err := readDir(*path)
 	if err != nil {
@@ -108,7 +107,10 @@ func main() {
 		}
 		// Build a file:// and a postres:// URL to migrate into
 		dir := fmt.Sprintf("file://%s/%s", *path, entry.Name())
-		url := fmt.Sprintf("postgres://postgres:%s@%s/%s?sslmode=disable", passwd, *hostport, entry.Name())
+		url, err := db.ConnStringFor(entry.Name())
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		log.Printf("migrate: %q into %q database", dir, entry.Name())
 
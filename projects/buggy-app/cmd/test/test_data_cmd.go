@@ -9,9 +9,10 @@ import (
 	"os"
 	"os/signal"
 
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/auth"
 	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/config"
 	"github.com/jackc/pgx/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // This package is a CLI tool for interacting with the database to create/update/delete data for testing. It
@@ -35,9 +36,6 @@ import (
 type Flags struct {
 	cmd string
 
-	hostport string
-	db       string
-
 	// Number of entities to generate
 	n int
 
@@ -78,21 +76,22 @@ func main() {
 		usage()
 	}
 
+	// The database is outside docker, and the password is where we know it's likely to be, unless
+	// the config says otherwise
+	db := config.DefaultDatabase()
+	db.HostPort = "localhost:5432"
+	db.PasswordFile = "volumes/secrets/postgres-passwd"
+	db.SSLMode = "disable"
+
 	// Attach base flags to this flag and parse
 	baseFlags(f, fs)
-	err = fs.Parse(os.Args[2:])
+	err = config.Load(fs, os.Args[2:], &db)
 	if err != nil {
-		log.Println("error: could not parse flags")
+		log.Printf("error: %v\n", err)
 		fmt.Fprintf(fs.Output(), "Usage of %s:\n", f.cmd)
 		usage()
 	}
-
-	// Set up a default POSTGRES_PASSWORD_FILE because we know where it's likely to be...
-	if os.Getenv("POSTGRES_PASSWORD_FILE") == "" {
-		os.Setenv("POSTGRES_PASSWORD_FILE", "volumes/secrets/postgres-passwd")
-	}
-	// ... and the read it. $POSTGRES_USER will still take precedence.
-	dbPasswd, err := util.ReadPasswd()
+	connString, err := db.ConnString()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -102,7 +101,6 @@ func main() {
 	defer stop()
 
 	// Connect to the database
-	connString := fmt.Sprintf("postgres://postgres:%s@%s/%s?sslmode=disable", dbPasswd, f.hostport, f.db)
 	conn, err := pgx.Connect(ctx, connString)
 	if err != nil {
 		log.Fatalf("error connecting to database: %v", err)
@@ -127,10 +125,9 @@ func main() {
 	}
 }
 
-// Base flags apply to all commands
+// Base flags apply to all commands. The database flags, like -hostport and -db, come from the
+// config package.
 func baseFlags(f *Flags, fs *flag.FlagSet) {
-	fs.StringVar(&f.hostport, "hostport", "localhost:5432", "host:port of Postgres")
-	fs.StringVar(&f.db, "db", "app", "target database")
 	fs.IntVar(&f.n, "n", 1, "number of entities to generate")
 }
 
@@ -144,12 +141,13 @@ func userFlags(f *Flags) *flag.FlagSet {
 
 // Create a user from command-line configuration
 func userCmd(ctx context.Context, f *Flags, conn *pgx.Conn) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(f.passwd), 10)
+	// Hashed the same way as the auth service's CreateUser
+	hash, err := auth.HashPassword(f.passwd)
 	if err != nil {
 		return fmt.Errorf("user: could not hash password, %w", err)
 	}
 
-	if f.status != "active" && f.status != "inactive" {
+	if !auth.ValidStatus(f.status) {
 		return fmt.Errorf("user: invalid status, %s", f.status)
 	}
 
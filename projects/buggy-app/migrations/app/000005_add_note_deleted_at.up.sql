@@ -0,0 +1,3 @@
+-- Notes are "soft" deleted: deleted_at is set when a note is moved to the trash,
+-- and the row is only removed once it has been in the trash for long enough.
+ALTER TABLE public.note ADD deleted_at timestamp;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS note_owner_created_idx;
\ No newline at end of file
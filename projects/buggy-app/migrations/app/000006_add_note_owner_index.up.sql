@@ -0,0 +1,3 @@
+-- Notes are listed per owner, oldest first, a page at a time. Including id
+-- makes the index match the (created, id) ordering used by the page cursor.
+CREATE INDEX IF NOT EXISTS note_owner_created_idx ON public.note (owner, created, id);
\ No newline at end of file
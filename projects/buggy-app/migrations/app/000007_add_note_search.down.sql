@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS note_search_idx;
+
+ALTER TABLE public.note DROP COLUMN IF EXISTS search;
\ No newline at end of file
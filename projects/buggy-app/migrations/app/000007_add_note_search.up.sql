@@ -0,0 +1,6 @@
+-- Full-text search over note content. The tsvector is generated by Postgres
+-- whenever content changes, and indexed with GIN for @@ queries.
+ALTER TABLE public.note ADD search tsvector
+   GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX IF NOT EXISTS note_search_idx ON public.note USING GIN (search);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public.note_tag;
\ No newline at end of file
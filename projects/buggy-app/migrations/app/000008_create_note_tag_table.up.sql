@@ -0,0 +1,18 @@
+-- Tags are extracted from note content by the API, and stored here so that
+-- notes can be found by tag.
+CREATE TABLE IF NOT EXISTS public.note_tag(
+   note_id VARCHAR (20) NOT NULL REFERENCES public.note (id) ON DELETE CASCADE,
+   tag TEXT NOT NULL,
+   PRIMARY KEY (note_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS note_tag_tag_idx ON public.note_tag (tag);
+
+-- Backfill tags for existing notes: everything after a # up to the next #, with
+-- whitespace trimmed. This was the API's extractTags when this was written, but
+-- it has changed since: see 000013, which has the API re-extract them.
+INSERT INTO public.note_tag (note_id, tag)
+SELECT DISTINCT note.id, btrim(match[1], E' \t\n\r\v\f')
+FROM public.note, regexp_matches(note.content, '#([^#]+)', 'g') AS match
+WHERE btrim(match[1], E' \t\n\r\v\f') <> ''
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,11 @@
+-- Notes shared by their owner with other users
+CREATE TABLE IF NOT EXISTS public.note_share(
+   note_id VARCHAR (20) NOT NULL REFERENCES public.note (id) ON DELETE CASCADE,
+   grantee VARCHAR (20) NOT NULL REFERENCES public.user (id) ON DELETE CASCADE,
+   permission VARCHAR (20) NOT NULL CHECK (permission IN ('read', 'write')),
+   created timestamp default current_timestamp,
+   PRIMARY KEY (note_id, grantee)
+);
+
+-- For listing the notes shared with a user
+CREATE INDEX IF NOT EXISTS note_share_grantee_idx ON public.note_share (grantee);
\ No newline at end of file
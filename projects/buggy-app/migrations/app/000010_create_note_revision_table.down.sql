@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS note_record_revision ON public.note;
+
+DROP FUNCTION IF EXISTS record_note_revision;
+
+DROP TABLE IF EXISTS public.note_revision;
\ No newline at end of file
@@ -0,0 +1,29 @@
+-- Previous versions of each note's content. Revisions are numbered from 1 for
+-- each note; created is when that content was written.
+CREATE TABLE IF NOT EXISTS public.note_revision(
+   note_id VARCHAR (20) NOT NULL REFERENCES public.note (id) ON DELETE CASCADE,
+   revision INTEGER NOT NULL,
+   content TEXT NOT NULL,
+   created timestamp NOT NULL,
+   PRIMARY KEY (note_id, revision)
+);
+
+-- Function to keep the previous content whenever a note's content changes
+CREATE OR REPLACE FUNCTION record_note_revision()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO public.note_revision (note_id, revision, content, created)
+    SELECT OLD.id, COALESCE(max(revision), 0) + 1, OLD.content, OLD.modified
+    FROM public.note_revision
+    WHERE note_id = OLD.id;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+-- Add "revision" trigger to note. Moving a note to the trash doesn't change its
+-- content, so it doesn't make a revision.
+CREATE TRIGGER note_record_revision
+AFTER UPDATE OF content ON public.note
+FOR EACH ROW
+WHEN (OLD.content IS DISTINCT FROM NEW.content)
+EXECUTE PROCEDURE record_note_revision();
\ No newline at end of file
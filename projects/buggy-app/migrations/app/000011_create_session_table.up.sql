@@ -0,0 +1,26 @@
+-- Sessions started by logging in to the auth service. The access tokens of a
+-- session are signed, so only need checking here to see if it has been revoked.
+-- The refresh token is only stored as a SHA-256 hash: it's long and random, so
+-- it doesn't need a slow hash like a password does.
+CREATE TABLE IF NOT EXISTS public.session(
+   id VARCHAR (20) PRIMARY KEY,
+   user_id VARCHAR (20) NOT NULL REFERENCES public.user (id) ON DELETE CASCADE,
+   refresh_hash VARCHAR (64) NOT NULL,
+   expires timestamp NOT NULL,
+   revoked timestamp,
+   created timestamp default current_timestamp,
+   modified timestamp default current_timestamp
+);
+
+-- For finding a user's sessions
+CREATE INDEX IF NOT EXISTS session_user_id_idx ON public.session (user_id);
+
+-- Add "modifier" trigger to session
+CREATE TRIGGER session_update_modified
+BEFORE UPDATE ON public.session
+FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+
+-- Add short ID trigger to session
+CREATE TRIGGER session_gen_id
+BEFORE INSERT ON public.session
+FOR EACH ROW EXECUTE PROCEDURE gen_id();
\ No newline at end of file
@@ -0,0 +1,28 @@
+-- Long-lived keys for scripts and CI jobs to use instead of a password. Like a
+-- refresh token, only a SHA-256 hash of a key's secret is stored. Scopes limit
+-- what a key can do: see auth/apikey.go. A key without an expiry lasts until
+-- it's revoked, which deletes it.
+CREATE TABLE IF NOT EXISTS public.api_key(
+   id VARCHAR (20) PRIMARY KEY,
+   user_id VARCHAR (20) NOT NULL REFERENCES public.user (id) ON DELETE CASCADE,
+   name VARCHAR (100) NOT NULL,
+   key_hash VARCHAR (64) NOT NULL,
+   scopes TEXT[] NOT NULL,
+   expires timestamp,
+   last_used timestamp,
+   created timestamp default current_timestamp,
+   modified timestamp default current_timestamp
+);
+
+-- For listing a user's keys
+CREATE INDEX IF NOT EXISTS api_key_user_id_idx ON public.api_key (user_id);
+
+-- Add "modifier" trigger to api_key
+CREATE TRIGGER api_key_update_modified
+BEFORE UPDATE ON public.api_key
+FOR EACH ROW EXECUTE PROCEDURE update_modified_column();
+
+-- Add short ID trigger to api_key
+CREATE TRIGGER api_key_gen_id
+BEFORE INSERT ON public.api_key
+FOR EACH ROW EXECUTE PROCEDURE gen_id();
\ No newline at end of file
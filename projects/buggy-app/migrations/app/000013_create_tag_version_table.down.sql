@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public.tag_version;
\ No newline at end of file
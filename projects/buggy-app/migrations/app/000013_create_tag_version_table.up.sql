@@ -0,0 +1,9 @@
+-- Which version of the API's extractTags the tags in note_tag were extracted
+-- with. When it's older than the API's (model.TagVersion), the API re-extracts
+-- every note's tags as it starts, then updates it. Version 0 is the regex that
+-- 000008 backfilled with.
+CREATE TABLE IF NOT EXISTS public.tag_version(
+   version INT NOT NULL
+);
+
+INSERT INTO public.tag_version (version) VALUES (0);
\ No newline at end of file
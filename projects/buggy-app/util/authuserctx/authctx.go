@@ -14,6 +14,12 @@ type key int
 // another value.
 const authenticatedIdKey key = 0
 
+// The scopes the user authenticated with, and the API key, if that's how they did
+const (
+	scopesKey key = 1
+	apiKeyKey key = 2
+)
+
 func NewAuthenticatedContext(ctx context.Context, id string) context.Context {
 	return context.WithValue(ctx, authenticatedIdKey, id)
 }
@@ -22,3 +28,30 @@ func FromAuthenticatedContext(ctx context.Context) (string, bool) {
 	id, ok := ctx.Value(authenticatedIdKey).(string)
 	return id, ok
 }
+
+// NewScopedContext adds the scopes the request may use, which are all of them unless the request
+// was made with an API key
+func NewScopedContext(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// HasScope says whether the request may use a scope. Without any scopes in the context, it may not.
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, _ := ctx.Value(scopesKey).([]string)
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func NewApiKeyContext(ctx context.Context, keyId string) context.Context {
+	return context.WithValue(ctx, apiKeyKey, keyId)
+}
+
+// FromApiKeyContext says which API key the request was made with, if it was made with one
+func FromApiKeyContext(ctx context.Context) (string, bool) {
+	keyId, ok := ctx.Value(apiKeyKey).(string)
+	return keyId, ok
+}
@@ -0,0 +1,183 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// This package loads TLS certificates from PEM files, for the connection between the API and the
+// auth service. The files are checked for changes when they're used, at most once every
+// CheckInterval, so a certificate can be replaced without restarting anything. If a changed file
+// can't be loaded (say it's half-written), the old certificate is kept until it can.
+
+// How often the files are checked for changes
+const CheckInterval = time.Second
+
+// Files are the paths of the PEM files for one end of a connection. Any of them can be empty.
+type Files struct {
+	// Cert and Key are this end's certificate and its private key
+	Cert string
+	Key  string
+	// CA is the certificate of the authority that signed the other end's certificate
+	CA string
+}
+
+// Store holds the certificate and CA loaded from Files, and reloads them when the files change
+type Store struct {
+	files    Files
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	// The contents of the files the certificates were loaded from, to see when they change
+	certPEM, keyPEM, caPEM []byte
+	cert                   *tls.Certificate
+	pool                   *x509.CertPool
+}
+
+// NewStore loads the files, which must be valid to start with
+func NewStore(files Files) (*Store, error) {
+	if (files.Cert == "") != (files.Key == "") {
+		return nil, errors.New("certs: a certificate and a key are needed together")
+	}
+	s := &Store{
+		files:    files,
+		interval: CheckInterval,
+		now:      time.Now,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.checked = s.now()
+	return s, nil
+}
+
+// Read the files and, if they have changed, parse them. Call with mu held.
+func (s *Store) load() error {
+	read := func(path string) ([]byte, error) {
+		if path == "" {
+			return nil, nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("certs: %w", err)
+		}
+		return b, nil
+	}
+	certPEM, err := read(s.files.Cert)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := read(s.files.Key)
+	if err != nil {
+		return err
+	}
+	caPEM, err := read(s.files.CA)
+	if err != nil {
+		return err
+	}
+
+	if certPEM != nil && (!bytes.Equal(certPEM, s.certPEM) || !bytes.Equal(keyPEM, s.keyPEM)) {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("certs: %s: %w", s.files.Cert, err)
+		}
+		s.cert, s.certPEM, s.keyPEM = &cert, certPEM, keyPEM
+	}
+	if caPEM != nil && !bytes.Equal(caPEM, s.caPEM) {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("certs: %s: no certificates found", s.files.CA)
+		}
+		s.pool, s.caPEM = pool, caPEM
+	}
+	return nil
+}
+
+// current returns the certificate and CA pool, reloading them first if it's time to check
+func (s *Store) current() (*tls.Certificate, *x509.CertPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.Sub(s.checked) >= s.interval {
+		s.checked = now
+		if err := s.load(); err != nil {
+			log.Printf("certs: reload failed, keeping the old certificates: %v\n", err)
+		}
+	}
+	return s.cert, s.pool
+}
+
+// ServerConfig is the TLS config for a server with the certificate. If there's a CA, clients can
+// present a certificate signed by it, which is verified; whether a client must present one is up to
+// the server (see the auth service's clientAuthInterceptor).
+func (s *Store) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The config is made for each connection, so that it has the latest certificates
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := s.current()
+			if cert == nil {
+				return nil, errors.New("certs: no server certificate")
+			}
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				// gRPC is HTTP/2
+				NextProtos: []string{"h2"},
+			}
+			if pool != nil {
+				config.ClientCAs = pool
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
+
+// ClientConfig is the TLS config for a client, which verifies the server's certificate against the
+// CA. If there's a certificate, it's presented to servers that ask for one. serverName is the name
+// the server's certificate must have, or empty for the host being connected to.
+func (s *Store) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := s.current()
+			if cert == nil {
+				// No certificate is sent
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+		// The CA can change, which the standard verification can't allow for, so it's turned off
+		// and done in VerifyConnection instead, with whichever CA is current
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, pool := s.current()
+			if pool == nil {
+				return errors.New("certs: no CA to verify the server with")
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("certs: server sent no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         pool,
+				Intermediates: intermediates,
+				DNSName:       cs.ServerName,
+			})
+			return err
+		},
+	}
+}
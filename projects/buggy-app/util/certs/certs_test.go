@@ -0,0 +1,237 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/certs/certstest"
+)
+
+// pipe is a connected pair of TCP connections. net.Pipe won't do, as it has no buffer: a server
+// sending an alert after a TLS 1.3 client has finished its side of the handshake would block.
+func pipe(t *testing.T) (server, client net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	return server, client
+}
+
+// writeFiles writes a certificate for name, signed by ca, to dir: see certstest.CA.WriteFiles
+func writeFiles(t *testing.T, ca *certstest.CA, dir, name string, other *certstest.CA) Files {
+	t.Helper()
+	cert, key, caFile := ca.WriteFiles(t, dir, name, other)
+	return Files{Cert: cert, Key: key, CA: caFile}
+}
+
+// handshake connects a client to a server over a pipe, and returns the certificates each end saw
+func handshake(t *testing.T, server, client *tls.Config) (clientSaw, serverSaw []*x509.Certificate, err error) {
+	t.Helper()
+	serverConn, clientConn := pipe(t)
+	s := tls.Server(serverConn, server)
+	c := tls.Client(clientConn, client)
+	defer s.Close()
+	defer c.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- s.Handshake()
+	}()
+	err = c.Handshake()
+	if sErr := <-serverErr; err == nil {
+		err = sErr
+	}
+	return c.ConnectionState().PeerCertificates, s.ConnectionState().PeerCertificates, err
+}
+
+func newStore(t *testing.T, files Files) *Store {
+	t.Helper()
+	store, err := NewStore(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := certstest.NewCA(t, "server-ca")
+	clientCA := certstest.NewCA(t, "client-ca")
+	server := newStore(t, writeFiles(t, serverCA, dir, "localhost", clientCA))
+	client := newStore(t, writeFiles(t, clientCA, dir, "api", serverCA))
+
+	clientSaw, serverSaw, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	if len(clientSaw) == 0 || clientSaw[0].Subject.CommonName != "localhost" {
+		t.Fatalf("expected the client to see the server's certificate, got %v", clientSaw)
+	}
+	if len(serverSaw) == 0 || serverSaw[0].Subject.CommonName != "api" {
+		t.Fatalf("expected the server to see the client's certificate, got %v", serverSaw)
+	}
+}
+
+func TestClientWithoutCert(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := certstest.NewCA(t, "server-ca")
+	clientCA := certstest.NewCA(t, "client-ca")
+	server := newStore(t, writeFiles(t, serverCA, dir, "localhost", clientCA))
+	caFile := filepath.Join(dir, "server-ca.crt")
+	certstest.Write(t, caFile, serverCA.PEM)
+	client := newStore(t, Files{CA: caFile})
+
+	// It's up to the server whether to let the client in
+	_, serverSaw, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	if len(serverSaw) != 0 {
+		t.Fatalf("expected no client certificate, got %v", serverSaw)
+	}
+}
+
+func TestUntrustedCerts(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := certstest.NewCA(t, "server-ca")
+	clientCA := certstest.NewCA(t, "client-ca")
+	otherCA := certstest.NewCA(t, "other-ca")
+	server := newStore(t, writeFiles(t, serverCA, dir, "localhost", clientCA))
+
+	t.Run("client cert from another CA", func(t *testing.T) {
+		client := newStore(t, writeFiles(t, otherCA, dir, "api", serverCA))
+		_, _, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+		if err == nil {
+			t.Fatal("expected the handshake to fail")
+		}
+	})
+
+	t.Run("server cert from another CA", func(t *testing.T) {
+		client := newStore(t, writeFiles(t, clientCA, dir, "api", otherCA))
+		_, _, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+		if err == nil {
+			t.Fatal("expected the handshake to fail")
+		}
+	})
+
+	t.Run("wrong server name", func(t *testing.T) {
+		client := newStore(t, writeFiles(t, clientCA, dir, "api", serverCA))
+		_, _, err := handshake(t, server.ServerConfig(), client.ClientConfig("auth"))
+		if err == nil {
+			t.Fatal("expected the handshake to fail")
+		}
+	})
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	serverCA := certstest.NewCA(t, "server-ca")
+	clientCA := certstest.NewCA(t, "client-ca")
+	files := writeFiles(t, serverCA, dir, "localhost", clientCA)
+	server := newStore(t, files)
+	client := newStore(t, writeFiles(t, clientCA, dir, "api", serverCA))
+	now := time.Now()
+	server.now = func() time.Time { return now }
+
+	serial := func() string {
+		t.Helper()
+		clientSaw, _, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost"))
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		return clientSaw[0].SerialNumber.String()
+	}
+	before := serial()
+
+	// A new certificate for the same name
+	certPEM, keyPEM := serverCA.Issue(t, "localhost")
+	certstest.Write(t, files.Cert, certPEM)
+	certstest.Write(t, files.Key, keyPEM)
+	if got := serial(); got != before {
+		t.Fatalf("expected the files not to be checked again so soon")
+	}
+	now = now.Add(CheckInterval)
+	after := serial()
+	if after == before {
+		t.Fatalf("expected the new certificate after %v", CheckInterval)
+	}
+
+	// A broken file is ignored, and the last good certificate kept
+	certstest.Write(t, files.Cert, []byte("not a certificate"))
+	now = now.Add(CheckInterval)
+	if got := serial(); got != after {
+		t.Fatalf("expected the last good certificate, got serial %s", got)
+	}
+}
+
+func TestReloadCA(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := certstest.NewCA(t, "old-ca")
+	newCA := certstest.NewCA(t, "new-ca")
+	clientCA := certstest.NewCA(t, "client-ca")
+	files := writeFiles(t, clientCA, dir, "api", oldCA)
+	client := newStore(t, files)
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	// The server moves to a certificate from the new CA, and the client's CA file is updated
+	server := newStore(t, writeFiles(t, newCA, dir, "localhost", clientCA))
+	if _, _, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost")); err == nil {
+		t.Fatal("expected the handshake to fail with the old CA")
+	}
+	certstest.Write(t, files.CA, newCA.PEM)
+	now = now.Add(CheckInterval)
+	if _, _, err := handshake(t, server.ServerConfig(), client.ClientConfig("localhost")); err != nil {
+		t.Fatalf("expected the handshake to work with the new CA: %v", err)
+	}
+}
+
+func TestNewStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "ca")
+	files := writeFiles(t, ca, dir, "localhost", ca)
+	notPEM := filepath.Join(dir, "not.pem")
+	certstest.Write(t, notPEM, []byte("hello"))
+
+	tests := map[string]struct {
+		files    Files
+		expected string
+	}{
+		"cert without key": {Files{Cert: files.Cert}, "a certificate and a key are needed together"},
+		"missing file":     {Files{CA: filepath.Join(dir, "missing.crt")}, "no such file"},
+		"bad cert":         {Files{Cert: notPEM, Key: files.Key}, "not.pem"},
+		"bad CA":           {Files{CA: notPEM}, "no certificates found"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewStore(test.files)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected an error containing %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
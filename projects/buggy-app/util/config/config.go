@@ -0,0 +1,274 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// This package loads the configuration of the commands. Each setting can come from, in order of
+// precedence (later wins):
+//
+//  1. the default, which is whatever the struct holds before Load
+//  2. a YAML or TOML file, named with -config or $CONFIG_FILE
+//  3. an environment variable
+//  4. a flag
+//
+// Settings are fields of a struct, and struct tags say where they come from:
+//
+//	type Example struct {
+//		Port     int      `yaml:"port" toml:"port" env:"PORT" flag:"port" usage:"port to listen on"`
+//		Database Database `yaml:"database" toml:"database"`
+//	}
+//
+// Nested structs are read from nested tables in the file, but their environment variables and
+// flags aren't prefixed, so they must be unique across the whole struct. Fields can be strings,
+// ints, float64s, bools or time.Durations (written like 30s or 24h).
+//
+// Secrets are tagged secret:"true", and are replaced with [redacted] when the config is printed.
+// URLs tagged secret:"url" have only their password redacted.
+
+// ConfigFileEnv is the environment variable that names the config file, if -config isn't given
+const ConfigFileEnv = "CONFIG_FILE"
+
+const redacted = "[redacted]"
+
+// Validator is a config that can check itself once it has been loaded
+type Validator interface {
+	Validate() error
+}
+
+// Load reads the config into cfg, a pointer to a struct holding the defaults, from args (usually
+// os.Args[1:]). The flags are added to fs, which may already have some of its own. If cfg is a
+// Validator, it's validated.
+func Load(fs *flag.FlagSet, args []string, cfg interface{}) error {
+	root := reflect.ValueOf(cfg)
+	if root.Kind() != reflect.Pointer || root.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: expected a pointer to a struct, got %T", cfg)
+	}
+
+	// Flags are parsed first, as one of them might be the file, but they're applied last
+	configFile := fs.String("config", "", "YAML or TOML file to read the config from (or $"+ConfigFileEnv+")")
+	flags := map[string]*fieldFlag{}
+	var err error
+	walk(root.Elem(), func(field reflect.StructField, value reflect.Value) {
+		name := field.Tag.Get("flag")
+		if name == "" || err != nil {
+			return
+		}
+		if _, ok := flags[name]; ok {
+			err = fmt.Errorf("config: flag -%s is used twice", name)
+			return
+		}
+		flags[name] = &fieldFlag{value: value, defaultText: fmt.Sprint(value.Interface())}
+		usage := field.Tag.Get("usage")
+		if env := field.Tag.Get("env"); env != "" {
+			usage += " (or $" + env + ")"
+		}
+		fs.Var(flags[name], name, usage)
+	})
+	if err != nil {
+		return err
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// The file
+	path := *configFile
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		if err := readFile(path, cfg); err != nil {
+			return err
+		}
+	}
+
+	// The environment
+	walk(root.Elem(), func(field reflect.StructField, value reflect.Value) {
+		name := field.Tag.Get("env")
+		if name == "" || err != nil {
+			return
+		}
+		if s, ok := os.LookupEnv(name); ok {
+			if setErr := set(value, s); setErr != nil {
+				err = fmt.Errorf("config: $%s: %w", name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// The flags that were given
+	fs.Visit(func(f *flag.Flag) {
+		if flag, ok := flags[f.Name]; ok && err == nil {
+			if setErr := set(flag.value, flag.raw); setErr != nil {
+				err = fmt.Errorf("config: -%s: %w", f.Name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if v, ok := cfg.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read a config file into cfg, as YAML or TOML depending on its extension
+func readFile(path string, cfg interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, cfg)
+	case ".toml":
+		err = toml.Unmarshal(b, cfg)
+	default:
+		return fmt.Errorf("config: %s: unknown file type %q, expected .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// Print writes cfg as YAML, with its secrets redacted
+func Print(w io.Writer, cfg interface{}) error {
+	// Redact a copy, so the original keeps its secrets
+	v := reflect.New(reflect.Indirect(reflect.ValueOf(cfg)).Type()).Elem()
+	v.Set(reflect.Indirect(reflect.ValueOf(cfg)))
+	walk(v, func(field reflect.StructField, value reflect.Value) {
+		if value.Kind() != reflect.String || value.String() == "" {
+			return
+		}
+		switch field.Tag.Get("secret") {
+		case "true":
+			value.SetString(redacted)
+		case "url":
+			value.SetString(redactURL(value.String()))
+		}
+	})
+
+	b, err := yaml.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Hide the password in a URL. If it can't be parsed, there's no telling where the password is, so
+// all of it is hidden.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+// walk calls fn for every settable field of the struct v, and of the structs inside it
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if value.Kind() == reflect.Struct {
+			walk(value, fn)
+			continue
+		}
+		fn(field, value)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Set a field from a string, from the environment or a flag
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// fieldFlag is the flag for a field. It holds onto what it's given, until the file and environment
+// have been read and it's the flag's turn.
+type fieldFlag struct {
+	value       reflect.Value
+	defaultText string
+	raw         string
+}
+
+func (f *fieldFlag) String() string {
+	return f.defaultText
+}
+
+func (f *fieldFlag) Set(s string) error {
+	// Check it now, so the flag package can report it with the usage
+	if f.value.IsValid() {
+		if err := set(reflect.New(f.value.Type()).Elem(), s); err != nil {
+			return err
+		}
+	}
+	f.raw = s
+	return nil
+}
+
+// Bool flags can be given without a value
+func (f *fieldFlag) IsBoolFlag() bool {
+	return f.value.IsValid() && f.value.Kind() == reflect.Bool
+}
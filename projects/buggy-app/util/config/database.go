@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Database is how to connect to Postgres. $DATABASE_URL is used as it is, if it's set. Otherwise
+// the URL is built from the other settings, with the password from $POSTGRES_PASSWORD or, as the
+// services run in docker-compose, from the file named by $POSTGRES_PASSWORD_FILE.
+type Database struct {
+	Url          string `yaml:"url,omitempty" toml:"url" env:"DATABASE_URL" flag:"database-url" secret:"url" usage:"Postgres URL, used instead of the other database settings"`
+	HostPort     string `yaml:"hostport" toml:"hostport" env:"POSTGRES_HOSTPORT" flag:"hostport" usage:"host:port of Postgres"`
+	Name         string `yaml:"name" toml:"name" env:"POSTGRES_DB" flag:"db" usage:"target database"`
+	User         string `yaml:"user" toml:"user" env:"POSTGRES_USER" flag:"db-user" usage:"Postgres user"`
+	Password     string `yaml:"password,omitempty" toml:"password" env:"POSTGRES_PASSWORD" secret:"true"`
+	PasswordFile string `yaml:"password_file,omitempty" toml:"password_file" env:"POSTGRES_PASSWORD_FILE" flag:"db-password-file" usage:"file containing the Postgres password"`
+	SSLMode      string `yaml:"sslmode,omitempty" toml:"sslmode" env:"PGSSLMODE" flag:"db-sslmode" usage:"Postgres sslmode, like disable or require"`
+}
+
+// DefaultDatabase is the database in docker-compose
+func DefaultDatabase() Database {
+	return Database{
+		HostPort: "postgres:5432",
+		Name:     "app",
+		User:     "postgres",
+	}
+}
+
+func (d Database) Validate() error {
+	if d.Url != "" {
+		u, err := url.Parse(d.Url)
+		if err != nil {
+			// The error has the URL in it, password and all
+			return errors.New("database url is not a valid URL")
+		}
+		if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			return fmt.Errorf("database url must start with postgres://, not %s://", u.Scheme)
+		}
+		return nil
+	}
+
+	var problems []string
+	if d.HostPort == "" {
+		problems = append(problems, "database hostport is required")
+	}
+	if d.Name == "" {
+		problems = append(problems, "database name is required")
+	}
+	if d.User == "" {
+		problems = append(problems, "database user is required")
+	}
+	if d.Password == "" && d.PasswordFile == "" {
+		problems = append(problems, "please set POSTGRES_PASSWORD_FILE environment variable, or DATABASE_URL")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ConnString is the URL to connect to the database with
+func (d Database) ConnString() (string, error) {
+	if d.Url != "" {
+		return d.Url, nil
+	}
+	return d.ConnStringFor(d.Name)
+}
+
+// ConnStringFor is the URL to connect to another database on the same server, for commands that
+// work with more than one
+func (d Database) ConnStringFor(name string) (string, error) {
+	var u *url.URL
+	if d.Url != "" {
+		var err error
+		u, err = url.Parse(d.Url)
+		if err != nil {
+			return "", errors.New("config: database url is not a valid URL")
+		}
+	} else {
+		passwd, err := d.password()
+		if err != nil {
+			return "", err
+		}
+		u = &url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(d.User, passwd),
+			Host:   d.HostPort,
+		}
+		if d.SSLMode != "" {
+			u.RawQuery = url.Values{"sslmode": {d.SSLMode}}.Encode()
+		}
+	}
+	u.Path = "/" + name
+	return u.String(), nil
+}
+
+// The password comes from $POSTGRES_PASSWORD or $POSTGRES_PASSWORD_FILE, in that order
+func (d Database) password() (string, error) {
+	if d.Password != "" {
+		return d.Password, nil
+	}
+	if d.PasswordFile == "" {
+		return "", errors.New("config: please set POSTGRES_PASSWORD_FILE environment variable")
+	}
+	passwd, err := os.ReadFile(d.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("config: %w", err)
+	}
+	return string(passwd), nil
+}
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/certs"
+	"github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing"
+)
+
+// The settings for each service. The commands turn these into the api.Config or auth.Config that
+// the service is created with.
+
+type API struct {
+	Port           int      `yaml:"port" toml:"port" env:"PORT" flag:"port" usage:"port the server will listen on"`
+	AuthServiceUrl string   `yaml:"auth_service_url" toml:"auth_service_url" env:"AUTH_SERVICE_URL" flag:"auth-service-url" usage:"host:port of the auth service"`
+	Database       Database `yaml:"database" toml:"database"`
+	Tracing        Tracing  `yaml:"tracing" toml:"tracing"`
+
+	// With a CA, the auth service is called with TLS, and with a certificate too, mutual TLS
+	TLS            TLS    `yaml:"tls" toml:"tls"`
+	AuthServerName string `yaml:"auth_server_name,omitempty" toml:"auth_server_name" env:"AUTH_SERVER_NAME" flag:"auth-server-name" usage:"name the auth service's certificate must have, if not the host of -auth-service-url"`
+	// The auth service's -service-key-file, for managing API keys
+	AuthServiceKeyFile string `yaml:"auth_service_key_file,omitempty" toml:"auth_service_key_file" env:"SERVICE_KEY_FILE" flag:"auth-service-key-file" usage:"file containing the key the auth service wants for managing API keys, at least 32 bytes"`
+
+	TrashRetention     time.Duration `yaml:"trash_retention" toml:"trash_retention" env:"TRASH_RETENTION" flag:"trash-retention" usage:"how long deleted notes are kept in the trash"`
+	TrashPurgeInterval time.Duration `yaml:"trash_purge_interval" toml:"trash_purge_interval" env:"TRASH_PURGE_INTERVAL" flag:"trash-purge-interval" usage:"how often the trash is checked for notes to delete"`
+
+	RateLimit               float64 `yaml:"rate_limit" toml:"rate_limit" env:"RATE_LIMIT" flag:"rate-limit" usage:"requests per second each user can make, on average (0 for no limit)"`
+	RateLimitBurst          int     `yaml:"rate_limit_burst" toml:"rate_limit_burst" env:"RATE_LIMIT_BURST" flag:"rate-limit-burst" usage:"requests each user can make at once"`
+	AnonymousRateLimit      float64 `yaml:"anonymous_rate_limit" toml:"anonymous_rate_limit" env:"ANONYMOUS_RATE_LIMIT" flag:"anonymous-rate-limit" usage:"failed authentications per second from each IP, on average (0 for no limit)"`
+	AnonymousRateLimitBurst int     `yaml:"anonymous_rate_limit_burst" toml:"anonymous_rate_limit_burst" env:"ANONYMOUS_RATE_LIMIT_BURST" flag:"anonymous-rate-limit-burst" usage:"failed authentications from each IP at once"`
+}
+
+func DefaultAPI() API {
+	return API{
+		Port:           80,
+		AuthServiceUrl: "auth:80",
+		Database:       DefaultDatabase(),
+		Tracing:        DefaultTracing(),
+
+		TrashRetention:     30 * 24 * time.Hour,
+		TrashPurgeInterval: time.Hour,
+
+		RateLimit:               10,
+		RateLimitBurst:          50,
+		AnonymousRateLimit:      1,
+		AnonymousRateLimitBurst: 10,
+	}
+}
+
+func (c *API) Validate() error {
+	var problems []string
+	problems = appendPort(problems, "port", c.Port, false)
+	if c.AuthServiceUrl == "" {
+		problems = append(problems, "auth service url is required")
+	}
+	problems = appendErr(problems, c.Database.Validate())
+	problems = appendErr(problems, c.Tracing.Validate())
+	problems = appendErr(problems, c.TLS.Validate())
+	if c.TLS.CertFile != "" && c.TLS.CAFile == "" {
+		problems = append(problems, "tls ca is required to call the auth service with a certificate")
+	}
+	if c.TrashRetention < 0 || c.TrashPurgeInterval < 0 {
+		problems = append(problems, "trash retention and purge interval must not be negative")
+	}
+	problems = appendRateLimit(problems, "rate limit", c.RateLimit, c.RateLimitBurst)
+	problems = appendRateLimit(problems, "anonymous rate limit", c.AnonymousRateLimit, c.AnonymousRateLimitBurst)
+	return invalid(problems)
+}
+
+type Auth struct {
+	Port        int      `yaml:"port" toml:"port" env:"PORT" flag:"port" usage:"port the server will listen on"`
+	MetricsPort int      `yaml:"metrics_port" toml:"metrics_port" env:"METRICS_PORT" flag:"metrics-port" usage:"port to serve Prometheus metrics on, or 0 for none"`
+	Database    Database `yaml:"database" toml:"database"`
+	Tracing     Tracing  `yaml:"tracing" toml:"tracing"`
+
+	// With a certificate, the server uses TLS, and with a CA, only clients with a certificate signed
+	// by it can call Verify
+	TLS         TLS    `yaml:"tls" toml:"tls"`
+	ClientNames string `yaml:"client_names,omitempty" toml:"client_names" env:"TLS_CLIENT_NAMES" flag:"tls-client-names" usage:"comma-separated names of the client certificates allowed to call Verify (empty for any signed by -tls-ca)"`
+
+	// Sessions: see the auth package's session.go
+	TokenKeyFile   string        `yaml:"token_key_file,omitempty" toml:"token_key_file" env:"TOKEN_KEY_FILE" flag:"token-key-file" usage:"file containing the key that signs session tokens, at least 32 bytes (a random key if none)"`
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl" env:"ACCESS_TOKEN_TTL" flag:"access-token-ttl" usage:"how long session access tokens last"`
+	SessionTTL     time.Duration `yaml:"session_ttl" toml:"session_ttl" env:"SESSION_TTL" flag:"session-ttl" usage:"how long sessions last without being refreshed"`
+
+	// User management: see the auth package's admin.go
+	AdminKeyFile string `yaml:"admin_key_file,omitempty" toml:"admin_key_file" env:"ADMIN_KEY_FILE" flag:"admin-key-file" usage:"file containing the key admins send to manage users, at least 32 bytes (user management is off if none)"`
+	// API keys: see the auth package's servicekey.go
+	ServiceKeyFile string `yaml:"service_key_file,omitempty" toml:"service_key_file" env:"SERVICE_KEY_FILE" flag:"service-key-file" usage:"file containing the key the API sends to manage API keys, at least 32 bytes (without one, only mutual TLS clients can)"`
+
+	// Password guessing: see the auth package's lockout.go. The API caches passwords that were
+	// allowed for up to auth.VerifyCacheTTL, so a lockout can take that long to reach it.
+	MaxFailures       int           `yaml:"max_failures" toml:"max_failures" env:"MAX_FAILURES" flag:"max-failures" usage:"failed logins for a user id within -failure-window that lock it out"`
+	MaxSourceFailures int           `yaml:"max_source_failures" toml:"max_source_failures" env:"MAX_SOURCE_FAILURES" flag:"max-source-failures" usage:"failed logins from a client IP within -failure-window that lock it out"`
+	FailureWindow     time.Duration `yaml:"failure_window" toml:"failure_window" env:"FAILURE_WINDOW" flag:"failure-window" usage:"how long failed logins are counted for"`
+	LockoutDuration   time.Duration `yaml:"lockout_duration" toml:"lockout_duration" env:"LOCKOUT_DURATION" flag:"lockout-duration" usage:"how long a user id or client IP is locked out for"`
+	BackoffBase       time.Duration `yaml:"backoff_base" toml:"backoff_base" env:"BACKOFF_BASE" flag:"backoff-base" usage:"how long a user id or client IP is shut out after a failed login, doubling with each one"`
+	BackoffMax        time.Duration `yaml:"backoff_max" toml:"backoff_max" env:"BACKOFF_MAX" flag:"backoff-max" usage:"the longest a failed login shuts out a user id or client IP, short of a lockout"`
+}
+
+func DefaultAuth() Auth {
+	return Auth{
+		Port:        80,
+		MetricsPort: 9090,
+		Database:    DefaultDatabase(),
+		Tracing:     DefaultTracing(),
+
+		AccessTokenTTL: 15 * time.Minute,
+		SessionTTL:     30 * 24 * time.Hour,
+
+		MaxFailures:       10,
+		MaxSourceFailures: 50,
+		FailureWindow:     15 * time.Minute,
+		LockoutDuration:   15 * time.Minute,
+		BackoffBase:       time.Second,
+		BackoffMax:        time.Minute,
+	}
+}
+
+func (c *Auth) Validate() error {
+	var problems []string
+	problems = appendPort(problems, "port", c.Port, false)
+	problems = appendPort(problems, "metrics port", c.MetricsPort, true)
+	if c.MetricsPort != 0 && c.MetricsPort == c.Port {
+		problems = append(problems, "metrics port must be different from port")
+	}
+	problems = appendErr(problems, c.Database.Validate())
+	problems = appendErr(problems, c.Tracing.Validate())
+	problems = appendErr(problems, c.TLS.Validate())
+	if c.TLS.CAFile != "" && c.TLS.CertFile == "" {
+		problems = append(problems, "tls cert is required to verify clients with a ca")
+	}
+	if c.ClientNames != "" && c.TLS.CAFile == "" {
+		problems = append(problems, "tls ca is required to check client names")
+	}
+	if c.AccessTokenTTL <= 0 || c.SessionTTL <= 0 {
+		problems = append(problems, "access token and session ttls must be positive")
+	} else if c.AccessTokenTTL > c.SessionTTL {
+		problems = append(problems, "access token ttl must not be longer than session ttl")
+	}
+	if c.MaxFailures < 1 || c.MaxSourceFailures < 1 {
+		problems = append(problems, "max failures and max source failures must be at least 1")
+	}
+	if c.FailureWindow <= 0 || c.LockoutDuration <= 0 || c.BackoffBase <= 0 || c.BackoffMax <= 0 {
+		problems = append(problems, "failure window, lockout duration and backoffs must be positive")
+	} else if c.BackoffBase > c.BackoffMax {
+		problems = append(problems, "backoff base must not be longer than backoff max")
+	}
+	return invalid(problems)
+}
+
+// minTokenKeySize is the size of the SHA-256 HMAC's hash: a shorter key is easier to guess
+const minTokenKeySize = 32
+
+// TokenKey reads the key that signs session tokens, or returns nil if there's no file
+func (c *Auth) TokenKey() ([]byte, error) {
+	return readKeyFile(c.TokenKeyFile, "token key")
+}
+
+// AdminKey reads the key for the user management RPCs, or returns nil if there's no file
+func (c *Auth) AdminKey() ([]byte, error) {
+	return readKeyFile(c.AdminKeyFile, "admin key")
+}
+
+// ServiceKey reads the key for the API key RPCs, or returns nil if there's no file
+func (c *Auth) ServiceKey() ([]byte, error) {
+	return readKeyFile(c.ServiceKeyFile, "service key")
+}
+
+// AuthServiceKey reads the key the API sends to manage API keys, or returns nil if there's no file
+func (c *API) AuthServiceKey() ([]byte, error) {
+	return readKeyFile(c.AuthServiceKeyFile, "service key")
+}
+
+// readKeyFile reads a key of at least minTokenKeySize bytes, without the whitespace around it
+func readKeyFile(file, name string) ([]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) < minTokenKeySize {
+		return nil, fmt.Errorf("config: %s: %s must be at least %d bytes, not %d", file, name, minTokenKeySize, len(key))
+	}
+	return key, nil
+}
+
+// AllowedClients is ClientNames as a list
+func (c *Auth) AllowedClients() []string {
+	var names []string
+	for _, name := range strings.Split(c.ClientNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// TLS is a certificate, its key and a CA, as PEM files: see the certs package. Each service uses
+// them as the end of the connection it's on.
+type TLS struct {
+	CertFile string `yaml:"cert_file,omitempty" toml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"certificate file (PEM)"`
+	KeyFile  string `yaml:"key_file,omitempty" toml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"private key file for -tls-cert (PEM)"`
+	CAFile   string `yaml:"ca_file,omitempty" toml:"ca_file" env:"TLS_CA_FILE" flag:"tls-ca" usage:"CA certificate file (PEM) to verify the other end's certificate with"`
+}
+
+func (t TLS) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls cert and key are needed together")
+	}
+	return nil
+}
+
+func (t TLS) Files() certs.Files {
+	return certs.Files{
+		Cert: t.CertFile,
+		Key:  t.KeyFile,
+		CA:   t.CAFile,
+	}
+}
+
+// Tracing is where the spans of a service go: see the tracing package
+type Tracing struct {
+	Exporter     string `yaml:"exporter" toml:"exporter" env:"TRACE_EXPORTER" flag:"trace-exporter" usage:"where to send traces: none, stdout or otlp"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"OTLP_ENDPOINT" flag:"otlp-endpoint" usage:"host:port of the OpenTelemetry collector, for -trace-exporter otlp"`
+}
+
+func DefaultTracing() Tracing {
+	return Tracing{
+		Exporter:     tracing.ExporterNone,
+		OTLPEndpoint: "otel-collector:4317",
+	}
+}
+
+func (t Tracing) Validate() error {
+	switch t.Exporter {
+	case "", tracing.ExporterNone, tracing.ExporterStdout:
+		return nil
+	case tracing.ExporterOTLP:
+		if t.OTLPEndpoint == "" {
+			return errors.New("otlp endpoint is required to export traces with otlp")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown trace exporter %q", t.Exporter)
+	}
+}
+
+func (t Tracing) Config() tracing.Config {
+	return tracing.Config{
+		Exporter:     t.Exporter,
+		OTLPEndpoint: t.OTLPEndpoint,
+	}
+}
+
+func appendPort(problems []string, name string, port int, zeroOk bool) []string {
+	if (port == 0 && !zeroOk) || port < 0 || port > 65535 {
+		return append(problems, fmt.Sprintf("%s must be between 1 and 65535, not %d", name, port))
+	}
+	return problems
+}
+
+func appendRateLimit(problems []string, name string, rate float64, burst int) []string {
+	if rate < 0 {
+		return append(problems, fmt.Sprintf("%s must not be negative", name))
+	}
+	if rate > 0 && burst < 1 {
+		return append(problems, fmt.Sprintf("%s burst must be at least 1", name))
+	}
+	return problems
+}
+
+func appendErr(problems []string, err error) []string {
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	return problems
+}
+
+// All the problems with a config are reported together, so they can all be fixed at once
+func invalid(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("config: invalid: %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,235 @@
+package util
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// An Encoding is one way of writing a response body, like JSON or CSV. Handlers pick one with a
+// Registry, based on the path extension or Accept header of the request.
+//
+// JSON can encode anything. The other encodings only work for values that know how to write
+// themselves that way, by implementing the matching Marshaler interface below.
+type Encoding struct {
+	// The media type matched against Accept headers, like "text/csv"
+	MediaType string
+	// The value for the Content-Type header
+	ContentType string
+	// The path extension that selects this encoding, like ".csv"
+	Extension string
+	// Encode a value into a response body
+	Encode func(data interface{}) ([]byte, error)
+}
+
+// ErrNotEncodable is returned (wrapped) when a value can't be written in an encoding
+var ErrNotEncodable = errors.New("util: value can't be encoded")
+
+// Values that can be written as Markdown
+type MarkdownMarshaler interface {
+	MarshalMarkdown() ([]byte, error)
+}
+
+// Values that can be written as plain text
+type PlainTextMarshaler interface {
+	MarshalPlainText() ([]byte, error)
+}
+
+// Values that can be written as an HTML document
+type HTMLMarshaler interface {
+	MarshalHTML() ([]byte, error)
+}
+
+// Values that can be written as CSV. The first record is the header.
+type CSVMarshaler interface {
+	MarshalCSV() ([][]string, error)
+}
+
+// Values that are a list of records, each of which can be written as JSON
+type RecordsMarshaler interface {
+	MarshalRecords() ([]interface{}, error)
+}
+
+var (
+	JSON = Encoding{
+		MediaType:   "application/json",
+		ContentType: "application/json; charset=utf-8",
+		Extension:   ".json",
+		Encode: func(data interface{}) ([]byte, error) {
+			return MarshalWithIndent(data, "")
+		},
+	}
+
+	// Newline-delimited JSON: one record per line (http://ndjson.org/)
+	NDJSON = Encoding{
+		MediaType:   "application/x-ndjson",
+		ContentType: "application/x-ndjson; charset=utf-8",
+		Extension:   ".ndjson",
+		Encode: func(data interface{}) ([]byte, error) {
+			m, ok := data.(RecordsMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("%w: %T as NDJSON", ErrNotEncodable, data)
+			}
+			records, err := m.MarshalRecords()
+			if err != nil {
+				return nil, err
+			}
+			var b bytes.Buffer
+			encoder := json.NewEncoder(&b)
+			for _, record := range records {
+				// Encode writes a newline after each record
+				if err := encoder.Encode(record); err != nil {
+					return nil, fmt.Errorf("could not marshal data: [%w]", err)
+				}
+			}
+			return b.Bytes(), nil
+		},
+	}
+
+	CSV = Encoding{
+		MediaType:   "text/csv",
+		ContentType: "text/csv; charset=utf-8; header=present",
+		Extension:   ".csv",
+		Encode: func(data interface{}) ([]byte, error) {
+			m, ok := data.(CSVMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("%w: %T as CSV", ErrNotEncodable, data)
+			}
+			records, err := m.MarshalCSV()
+			if err != nil {
+				return nil, err
+			}
+			var b bytes.Buffer
+			if err := csv.NewWriter(&b).WriteAll(records); err != nil {
+				return nil, fmt.Errorf("could not marshal data: [%w]", err)
+			}
+			return b.Bytes(), nil
+		},
+	}
+
+	Markdown = Encoding{
+		MediaType:   "text/markdown",
+		ContentType: "text/markdown; charset=utf-8",
+		Extension:   ".md",
+		Encode: func(data interface{}) ([]byte, error) {
+			m, ok := data.(MarkdownMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("%w: %T as Markdown", ErrNotEncodable, data)
+			}
+			return m.MarshalMarkdown()
+		},
+	}
+
+	PlainText = Encoding{
+		MediaType:   "text/plain",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   ".txt",
+		Encode: func(data interface{}) ([]byte, error) {
+			m, ok := data.(PlainTextMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("%w: %T as plain text", ErrNotEncodable, data)
+			}
+			return m.MarshalPlainText()
+		},
+	}
+
+	HTML = Encoding{
+		MediaType:   "text/html",
+		ContentType: "text/html; charset=utf-8",
+		Extension:   ".html",
+		Encode: func(data interface{}) ([]byte, error) {
+			m, ok := data.(HTMLMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("%w: %T as HTML", ErrNotEncodable, data)
+			}
+			return m.MarshalHTML()
+		},
+	}
+)
+
+// A Registry is the set of encodings a handler can respond with. The first one is the default.
+type Registry []Encoding
+
+// Find the encoding selected by a path extension, like ".md"
+func (reg Registry) ForExtension(ext string) (Encoding, bool) {
+	for _, encoding := range reg {
+		if encoding.Extension == ext {
+			return encoding, true
+		}
+	}
+	return Encoding{}, false
+}
+
+// Choose the encoding the client prefers, given the value of an Accept header. A missing header
+// accepts anything, so gets the default. If nothing in the registry is acceptable, ok is false.
+//
+// https://httpwg.org/specs/rfc9110.html#field.accept
+func (reg Registry) Negotiate(accept string) (encoding Encoding, ok bool) {
+	if len(reg) == 0 {
+		return Encoding{}, false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return reg[0], true
+	}
+
+	ranges := parseAccept(accept)
+	best := 0.0
+	for _, candidate := range reg {
+		// The most specific range that matches decides the quality
+		quality, specificity := 0.0, -1
+		for _, r := range ranges {
+			if s := r.matches(candidate.MediaType); s > specificity {
+				quality, specificity = r.quality, s
+			}
+		}
+		// Earlier encodings win ties
+		if quality > best {
+			encoding, best = candidate, quality
+		}
+	}
+	return encoding, best > 0
+}
+
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// How specifically the range matches the media type: 2 for an exact match, 1 for type/*, 0 for
+// */*, and -1 for no match
+func (r mediaRange) matches(mediaType string) int {
+	switch {
+	case r.mediaType == mediaType:
+		return 2
+	case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")):
+		return 1
+	case r.mediaType == "*/*":
+		return 0
+	default:
+		return -1
+	}
+}
+
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil || quality < 0 || quality > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, quality: quality})
+	}
+	return ranges
+}
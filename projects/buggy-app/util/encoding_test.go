@@ -0,0 +1,90 @@
+package util
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	reg := Registry{JSON, Markdown, PlainText, HTML}
+
+	tests := map[string]struct {
+		accept   string
+		expected string
+		ok       bool
+	}{
+		"no header":          {accept: "", expected: "application/json", ok: true},
+		"anything":           {accept: "*/*", expected: "application/json", ok: true},
+		"exact":              {accept: "text/markdown", expected: "text/markdown", ok: true},
+		"with parameters":    {accept: "text/html; charset=utf-8", expected: "text/html", ok: true},
+		"quality":            {accept: "text/html;q=0.5, text/plain", expected: "text/plain", ok: true},
+		"type wildcard":      {accept: "text/*", expected: "text/markdown", ok: true},
+		"specific beats any": {accept: "*/*;q=0.1, text/html", expected: "text/html", ok: true},
+		"browser": {
+			accept:   "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			expected: "text/html",
+			ok:       true,
+		},
+		"excluded":       {accept: "text/markdown;q=0, */*", expected: "application/json", ok: true},
+		"not acceptable": {accept: "image/png", ok: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			encoding, ok := reg.Negotiate(test.accept)
+			if ok != test.ok {
+				t.Fatalf("expected ok %v, got %v", test.ok, ok)
+			}
+			if ok && encoding.MediaType != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, encoding.MediaType)
+			}
+		})
+	}
+}
+
+type records []string
+
+func (r records) MarshalRecords() ([]interface{}, error) {
+	out := make([]interface{}, len(r))
+	for i, record := range r {
+		out[i] = map[string]string{"value": record}
+	}
+	return out, nil
+}
+
+func (r records) MarshalCSV() ([][]string, error) {
+	out := [][]string{{"value"}}
+	for _, record := range r {
+		out = append(out, []string{record})
+	}
+	return out, nil
+}
+
+func TestEncodeRecords(t *testing.T) {
+	data := records{"one", "two, \"three\""}
+
+	b, err := NDJSON.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "{\"value\":\"one\"}\n{\"value\":\"two, \\\"three\\\"\"}\n"
+	if string(b) != expected {
+		t.Fatalf("expected %q, got %q", expected, b)
+	}
+
+	b, err = CSV.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "value\none\n\"two, \"\"three\"\"\"\n"
+	if string(b) != expected {
+		t.Fatalf("expected %q, got %q", expected, b)
+	}
+}
+
+func TestEncodeNotEncodable(t *testing.T) {
+	_, err := Markdown.Encode(struct{}{})
+	if !errors.Is(err, ErrNotEncodable) {
+		t.Fatalf("expected ErrNotEncodable, got %v", err)
+	}
+}
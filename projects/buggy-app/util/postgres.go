@@ -1,25 +1,71 @@
 package util
 
 import (
-	"errors"
-	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Get the Postgres password from the environment, either via $POSTGRES_PASSWORD
-// or $POSTGRES_PASSWORD_FILE
-func ReadPasswd() (string, error) {
-	if os.Getenv("POSTGRES_PASSWORD") != "" {
-		return os.Getenv("POSTGRES_PASSWORD"), nil
-	}
+// PoolStat is the part of *pgxpool.Stat that PoolCollector reports
+type PoolStat interface {
+	AcquireCount() int64
+	AcquireDuration() time.Duration
+	AcquiredConns() int32
+	ConstructingConns() int32
+	EmptyAcquireCount() int64
+	IdleConns() int32
+	MaxConns() int32
+	TotalConns() int32
+}
 
-	passwordFile := os.Getenv("POSTGRES_PASSWORD_FILE")
-	if passwordFile == "" {
-		return "", errors.New("please set POSTGRES_PASSWORD_FILE environment variable")
-	}
+// PoolCollector reports the state of a connection pool as Prometheus metrics. Register it with:
+//
+//	registry.MustRegister(util.NewPoolCollector("api", func() util.PoolStat { return pool.Stat() }))
+//
+// pgxpool can't say how many callers are waiting for a connection right now, but it counts the
+// acquires that had to wait because none were idle, in <namespace>_db_pool_empty_acquires_total.
+type PoolCollector struct {
+	stat func() PoolStat
+
+	acquired        *prometheus.Desc
+	idle            *prometheus.Desc
+	constructing    *prometheus.Desc
+	total           *prometheus.Desc
+	max             *prometheus.Desc
+	acquires        *prometheus.Desc
+	emptyAcquires   *prometheus.Desc
+	acquireDuration *prometheus.Desc
+}
 
-	pwdFile, err := os.ReadFile(passwordFile)
-	if err != nil {
-		return "", err
+func NewPoolCollector(namespace string, stat func() PoolStat) *PoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
 	}
-	return string(pwdFile), nil
+	return &PoolCollector{
+		stat:            stat,
+		acquired:        desc("acquired_connections", "Connections in use."),
+		idle:            desc("idle_connections", "Connections waiting to be used."),
+		constructing:    desc("constructing_connections", "Connections being opened."),
+		total:           desc("connections", "All connections in the pool."),
+		max:             desc("max_connections", "The most connections the pool will open."),
+		acquires:        desc("acquires_total", "Connections acquired from the pool."),
+		emptyAcquires:   desc("empty_acquires_total", "Acquires that had to wait for a connection, because none were idle."),
+		acquireDuration: desc("acquire_duration_seconds_total", "Time spent acquiring connections."),
+	}
+}
+
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructing, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
 }
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakePoolStat struct{}
+
+func (fakePoolStat) AcquireCount() int64            { return 42 }
+func (fakePoolStat) AcquireDuration() time.Duration { return 1500 * time.Millisecond }
+func (fakePoolStat) AcquiredConns() int32           { return 3 }
+func (fakePoolStat) ConstructingConns() int32       { return 1 }
+func (fakePoolStat) EmptyAcquireCount() int64       { return 7 }
+func (fakePoolStat) IdleConns() int32               { return 2 }
+func (fakePoolStat) MaxConns() int32                { return 10 }
+func (fakePoolStat) TotalConns() int32              { return 6 }
+
+func TestPoolCollector(t *testing.T) {
+	collector := NewPoolCollector("api", func() PoolStat { return fakePoolStat{} })
+
+	expected := `
+# HELP api_db_pool_acquire_duration_seconds_total Time spent acquiring connections.
+# TYPE api_db_pool_acquire_duration_seconds_total counter
+api_db_pool_acquire_duration_seconds_total 1.5
+# HELP api_db_pool_acquired_connections Connections in use.
+# TYPE api_db_pool_acquired_connections gauge
+api_db_pool_acquired_connections 3
+# HELP api_db_pool_acquires_total Connections acquired from the pool.
+# TYPE api_db_pool_acquires_total counter
+api_db_pool_acquires_total 42
+# HELP api_db_pool_connections All connections in the pool.
+# TYPE api_db_pool_connections gauge
+api_db_pool_connections 6
+# HELP api_db_pool_constructing_connections Connections being opened.
+# TYPE api_db_pool_constructing_connections gauge
+api_db_pool_constructing_connections 1
+# HELP api_db_pool_empty_acquires_total Acquires that had to wait for a connection, because none were idle.
+# TYPE api_db_pool_empty_acquires_total counter
+api_db_pool_empty_acquires_total 7
+# HELP api_db_pool_idle_connections Connections waiting to be used.
+# TYPE api_db_pool_idle_connections gauge
+api_db_pool_idle_connections 2
+# HELP api_db_pool_max_connections The most connections the pool will open.
+# TYPE api_db_pool_max_connections gauge
+api_db_pool_max_connections 10
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+package requestidctx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// This package has methods for adding the ID of the request being handled to a context, so that
+// everything done for the request can be logged with it, and it can be reported back to the client.
+// For more on this idea, see https://go.dev/blog/context
+//
+// The ID travels between services in the X-Request-Id HTTP header, and in gRPC metadata under
+// MetadataKey.
+
+type key int
+
+// `requestIdKey` is the context key for the request identifier.
+const requestIdKey key = 0
+
+// HeaderName is the HTTP header the ID is sent in, both ways
+const HeaderName = "X-Request-Id"
+
+// MetadataKey is the gRPC metadata key the ID is sent in. gRPC metadata keys are lower case.
+const MetadataKey = "x-request-id"
+
+// IDs from elsewhere are limited in size, as they end up in every log line
+const maxIdLength = 128
+
+func NewRequestIdContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+func FromRequestIdContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIdKey).(string)
+	return id, ok
+}
+
+// Generate a new request ID: 16 random bytes, in hex
+func NewId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand doesn't fail on the platforms we run on
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Check whether an ID that came from a client or another service can be used. It must be short,
+// and only letters, digits and -._ so that it can't break up a log line or a header.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxIdLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Use the ID if it's valid, or generate a new one if not
+func ValidOrNew(id string) string {
+	if Valid(id) {
+		return id
+	}
+	return NewId()
+}
@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// This package sets up OpenTelemetry tracing (https://opentelemetry.io/docs/instrumentation/go/)
+// for the services. A request to the API is traced through the auth service and into Postgres:
+//
+//	GET /1/my/notes.json                 the API's HTTP handler
+//	├── auth.GrpcClient.Verify           auth.cache_hit says whether the auth service was asked
+//	│   └── service.Auth/Verify          the RPC, from the client...
+//	│       └── service.Auth/Verify      ...and the server
+//	│           └── SELECT               the auth service's query
+//	└── SELECT                           the API's queries
+//
+// The trace context is passed from the API to the auth service in gRPC metadata, in the W3C
+// traceparent format (https://www.w3.org/TR/trace-context/), which the API also accepts in HTTP
+// headers.
+//
+// Tracing goes through the otel package's global TracerProvider, which does nothing until Use is
+// called with one from NewProvider.
+
+// Exporters, which are where spans are sent
+const (
+	// Spans aren't recorded at all
+	ExporterNone = "none"
+	// Spans are written to stdout, for debugging
+	ExporterStdout = "stdout"
+	// Spans are sent to an OpenTelemetry collector with OTLP over gRPC
+	ExporterOTLP = "otlp"
+)
+
+type Config struct {
+	// Exporter is one of the Exporter constants; empty is the same as ExporterNone
+	Exporter string
+	// OTLPEndpoint is the host:port of the collector for ExporterOTLP
+	OTLPEndpoint string
+}
+
+// NewProvider creates a TracerProvider that sends the spans of the named service to the exporter
+// in the config. Call Shutdown on it before exiting, to send any spans that are waiting.
+func NewProvider(ctx context.Context, serviceName string, config Config) (*sdktrace.TracerProvider, error) {
+	var opts []sdktrace.TracerProviderOption
+	switch config.Exporter {
+	case "", ExporterNone:
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("tracing: failed to create stdout exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case ExporterOTLP:
+		exporter, err := otlptracegrpc.New(ctx,
+			otlptracegrpc.WithEndpoint(config.OTLPEndpoint),
+			// TODO: the collector is inside our network for now
+			otlptracegrpc.WithInsecure(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: failed to create otlp exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+	}
+
+	return sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(newResource(serviceName)))...), nil
+}
+
+// NewInMemoryProvider creates a TracerProvider that keeps spans in memory as soon as they end, so
+// that tests can look at them
+func NewInMemoryProvider(serviceName string) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(newResource(serviceName)),
+	), exporter
+}
+
+func newResource(serviceName string) *resource.Resource {
+	return resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))
+}
+
+// Use makes tp the provider for all tracing, and sets up propagation of the trace context between
+// services
+func Use(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// Tracer returns a tracer from the global provider, named after the package that uses it
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// QueryTracer is a pgx.QueryTracer that makes a span for every query. Set it as the Tracer of a
+// pgx.ConnConfig:
+//
+//	config, err := pgxpool.ParseConfig(url)
+//	config.ConnConfig.Tracer = tracing.QueryTracer{}
+type QueryTracer struct{}
+
+var _ pgx.QueryTracer = QueryTracer{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	operation := queryOperation(data.SQL)
+	ctx, _ = Tracer("github.com/CodeYourFuture/immersive-go-course/buggy-app/util/tracing").Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationKey.String(operation),
+			// Queries are always parameterised, so this doesn't have any user data in it
+			semconv.DBStatementKey.String(data.SQL),
+		),
+	)
+	return ctx
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	// Nothing found is an answer, not a failure
+	if data.Err != nil && data.Err != pgx.ErrNoRows {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+}
+
+// The operation is the first word of the query, like SELECT
+func queryOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(fields[0])
+}
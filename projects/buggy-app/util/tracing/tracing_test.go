@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewProvider(t *testing.T) {
+	for _, exporter := range []string{"", ExporterNone, ExporterStdout, ExporterOTLP} {
+		tp, err := NewProvider(context.Background(), "test", Config{Exporter: exporter, OTLPEndpoint: "localhost:4317"})
+		if err != nil {
+			t.Fatalf("exporter %q: %v", exporter, err)
+		}
+		tp.Shutdown(context.Background())
+	}
+
+	if _, err := NewProvider(context.Background(), "test", Config{Exporter: "carrier-pigeon"}); err == nil {
+		t.Fatalf("expected an error for an unknown exporter")
+	}
+}
+
+func TestQueryTracer(t *testing.T) {
+	tp, exporter := NewInMemoryProvider("test")
+	Use(tp)
+	defer Use(trace.NewNoopTracerProvider())
+
+	tracer := QueryTracer{}
+	sql := "SELECT id, password, status FROM public.user WHERE id = $1"
+
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: sql})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+
+	ctx = tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "\n\t  update public.note SET content = $1"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("connection reset")})
+
+	// Nothing found isn't a failure
+	ctx = tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: sql})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: pgx.ErrNoRows})
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	if spans[0].Name != "SELECT" {
+		t.Fatalf("expected span SELECT, got %s", spans[0].Name)
+	}
+	expected := map[attribute.Key]attribute.Value{
+		"db.system":        attribute.StringValue("postgresql"),
+		"db.operation":     attribute.StringValue("SELECT"),
+		"db.statement":     attribute.StringValue(sql),
+		"db.rows_affected": attribute.Int64Value(1),
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range spans[0].Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Errorf("expected %s %v, got %v", key, value.Emit(), attrs[key].Emit())
+		}
+	}
+
+	if spans[1].Name != "UPDATE" {
+		t.Fatalf("expected span UPDATE, got %s", spans[1].Name)
+	}
+	if spans[1].Status.Code != codes.Error {
+		t.Fatalf("expected an error status, got %v", spans[1].Status.Code)
+	}
+	if spans[2].Status.Code != codes.Unset {
+		t.Fatalf("expected no error for no rows, got %v", spans[2].Status.Code)
+	}
+}